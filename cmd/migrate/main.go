@@ -1,118 +1,173 @@
 // Package main представляет собой небольшой помощник для наката/отката миграций.
-// Использует POSTGRES_DSN и файлы в папке migrations.
+// Использует POSTGRES_DSN и миграции, встроенные в бинарник (или папку -dir),
+// применённые миграции учитываются в таблице schema_migrations (см. internal/migrate).
+//
+//	migrate [-dir DIR] up [N]     накатить N следующих миграций (по умолчанию все)
+//	migrate [-dir DIR] down [N]   откатить N последних миграций (по умолчанию одну)
+//	migrate [-dir DIR] goto V     привести базу к версии V (0 — откатить всё)
+//	migrate [-dir DIR] status     показать применённые и ожидающие миграции
+//	migrate [-dir DIR] force V    записать версию V без выполнения SQL
+//	migrate [-dir DIR] create NAME  создать пустую пару файлов следующей версии в DIR (по умолчанию migrations)
 package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"io/fs"
 	"log"
 	"os"
-	"path/filepath"
-	"sort"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/migrate"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/migrations"
 
 	"github.com/jackc/pgx/v5"
 )
 
 func main() {
-	if len(os.Args) < 2 {
-		log.Fatal("нужно указать команду: up или down")
-	}
-
-	cmd := os.Args[1]
-	if cmd != "up" && cmd != "down" {
-		log.Fatalf("неизвестная команда %q, нужно up или down", cmd)
+	dir := flag.String("dir", "", "папка с файлами миграций вместо встроенных")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: migrate [-dir DIR] up [N] | down [N] | goto V | status | force V | create NAME")
+		flag.PrintDefaults()
 	}
+	flag.Parse()
 
-	dsn := os.Getenv("POSTGRES_DSN")
-	if dsn == "" {
-		log.Fatal("env POSTGRES_DSN не задан (см. .env.example)")
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
 	}
+	cmd, args := args[0], args[1:]
 
-	ctx := context.Background()
-
-	conn, err := pgx.Connect(ctx, dsn)
-	if err != nil {
-		log.Fatalf("ошибка подключения к postgres: %v", err)
+	// create не ходит в базу
+	if cmd == "create" {
+		if len(args) != 1 {
+			log.Fatal("нужно указать имя миграции: create NAME")
+		}
+		target := *dir
+		if target == "" {
+			target = "migrations"
+		}
+		up, down, err := migrate.Create(target, args[0])
+		if err != nil {
+			log.Fatalf("ошибка создания миграции: %v", err)
+		}
+		log.Printf("созданы %s и %s", up, down)
+		return
 	}
-	defer conn.Close(ctx)
 
-	dir := "migrations"
-	pattern := "*.up.sql"
-	if cmd == "down" {
-		pattern = "*.down.sql"
+	var src fs.FS = migrations.FS
+	if *dir != "" {
+		src = os.DirFS(*dir)
 	}
-
-	paths, err := readMigrationFiles(dir, pattern)
+	list, err := migrate.Load(src)
 	if err != nil {
 		log.Fatalf("ошибка чтения миграций: %v", err)
 	}
-
-	if len(paths) == 0 {
-		log.Fatalf("в папке %s нет файлов %s", dir, pattern)
+	if len(list) == 0 {
+		log.Fatal("миграций не найдено")
 	}
 
-	// для up: от меньшего к большему
-	// для down: откатываем в обратном порядке
-	if cmd == "down" {
-		for i, j := 0, len(paths)-1; i < j; i, j = i+1, j-1 {
-			paths[i], paths[j] = paths[j], paths[i]
-		}
+	dsn := os.Getenv("POSTGRES_DSN")
+	if dsn == "" {
+		log.Fatal("env POSTGRES_DSN не задан (см. .env.example)")
 	}
 
-	log.Printf("выполняем миграции (%s):", cmd)
-	for _, p := range paths {
-		if err := runFile(ctx, conn, p); err != nil {
-			log.Fatalf("ошибка в миграции %s: %v", p, err)
-		}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	conn, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		log.Fatalf("ошибка подключения к postgres: %v", err)
 	}
-	log.Println("готово")
-}
+	defer conn.Close(context.Background())
 
-// readMigrationFiles собирает список файлов миграций по маске.
-func readMigrationFiles(dir, pattern string) ([]string, error) {
-	var files []string
+	m := migrate.New(conn, list)
 
-	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
+	switch cmd {
+	case "up":
+		done, err := m.Up(ctx, intArg(args, 0))
+		report("накачено", done, err)
+		if len(done) == 0 {
+			log.Println("база в актуальном состоянии")
 		}
-
-		if d.IsDir() {
-			return nil
+	case "down":
+		done, err := m.Down(ctx, intArg(args, 1))
+		report("откачено", done, err)
+	case "goto":
+		up, down, err := m.Goto(ctx, versionArg(args))
+		report("откачено", down, nil)
+		report("накачено", up, err)
+	case "force":
+		v := versionArg(args)
+		if err := m.Force(ctx, v); err != nil {
+			log.Fatalf("ошибка: %v", err)
 		}
-
-		match, err := filepath.Match(pattern, filepath.Base(path))
+		log.Printf("версия %d записана", v)
+	case "status":
+		st, err := m.Status(ctx)
 		if err != nil {
-			return err
-		}
-
-		if match {
-			files = append(files, path)
+			log.Fatalf("ошибка: %v", err)
 		}
-
-		return nil
-	})
-	if err != nil {
-		return nil, err
+		printStatus(st)
+	default:
+		log.Fatalf("неизвестная команда %q, нужно up, down, goto, status, force или create", cmd)
 	}
+}
 
-	sort.Strings(files)
-	return files, nil
+// intArg разбирает необязательный аргумент N.
+func intArg(args []string, def int) int {
+	if len(args) == 0 {
+		return def
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n <= 0 {
+		log.Fatalf("N должно быть положительным числом, получено %q", args[0])
+	}
+	return n
 }
 
-// runFile читает файл и выполняет его целиком как один запрос.
-func runFile(ctx context.Context, conn *pgx.Conn, path string) error {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return err
+// versionArg разбирает обязательный аргумент V.
+func versionArg(args []string) int64 {
+	if len(args) != 1 {
+		log.Fatal("нужно указать версию")
+	}
+	v, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || v < 0 {
+		log.Fatalf("версия должна быть неотрицательным числом, получено %q", args[0])
 	}
+	return v
+}
 
-	log.Printf("-> %s", filepath.Base(path))
-	_, err = conn.Exec(ctx, string(data))
+// report печатает выполненные миграции и завершает процесс при ошибке.
+func report(verb string, done []migrate.Migration, err error) {
+	for _, mig := range done {
+		log.Printf("%s: %s", verb, mig)
+	}
 	if err != nil {
-		return fmt.Errorf("sql error: %w", err)
+		log.Fatalf("ошибка: %v", err)
 	}
+}
 
-	return nil
+func printStatus(st []migrate.Status) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATE\tAPPLIED AT")
+	for _, s := range st {
+		state, at := "pending", ""
+		if s.Applied {
+			state, at = "applied", s.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		switch {
+		case s.Missing:
+			state = "applied, file missing"
+		case s.Modified:
+			state = "applied, modified"
+		}
+		fmt.Fprintf(w, "%03d\t%s\t%s\t%s\n", s.Version, s.Name, state, at)
+	}
+	_ = w.Flush()
 }
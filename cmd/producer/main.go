@@ -1,6 +1,9 @@
 // Package main реализует простой продюсер, который читает JSON и публикует его в топик.
 // По умолчанию отправляет все model*.json и broken*.json из текущей директории.
 // Можно включить режим генерации случайных заказов флагом -gen.
+// Флаг -status order_uid=status[/rid] отправляет событие смены статуса вместо заказов.
+// В заголовки каждого сообщения пишется контекст трассировки (traceparent),
+// спаны отправки пишутся так же, как в сервисе: TRACING_EXPORTER=stdout|file и TRACING_FILE.
 package main
 
 import (
@@ -17,10 +20,16 @@ import (
 
 	"github.com/joho/godotenv"
 	kafka "github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/models"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/tracing"
 )
 
+var tracer = otel.Tracer("github.com/Stanislav-Grinevich/wb-order-service-grinevich/cmd/producer")
+
 func main() {
 	if err := godotenv.Overload("cmd/producer/.env.local"); err != nil {
 		log.Printf("env load error: %v", err)
@@ -44,9 +53,25 @@ func main() {
 	badRate := flag.Float64("badRate", 0.1, "доля невалидных сообщений (0..1) в режиме -gen")
 	delay := flag.Duration("delay", 200*time.Millisecond, "задержка между сообщениями в режиме -gen")
 	filesEnv := flag.String("files", "", "доп. список файлов через запятую, если хочется отправить конкретные файлы")
+	statusArg := flag.String("status", "", "отправить событие смены статуса: order_uid=status или order_uid=status/rid")
 
 	flag.Parse()
 
+	shutdownTracing, err := tracing.Setup(tracing.Config{
+		ServiceName: "wb-order-producer",
+		Exporter:    os.Getenv("TRACING_EXPORTER"),
+		File:        os.Getenv("TRACING_FILE"),
+		SampleRatio: 1,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			log.Printf("tracing shutdown error: %v", err)
+		}
+	}()
+
 	// writer для записи в Kafka.
 	writer := &kafka.Writer{
 		Addr:         kafka.TCP(brokers...),
@@ -59,6 +84,13 @@ func main() {
 
 	ctx := context.Background()
 
+	if *statusArg != "" {
+		if err := sendStatus(ctx, writer, *statusArg); err != nil {
+			log.Fatalf("ошибка отправки статуса: %v", err)
+		}
+		return
+	}
+
 	if *genMode {
 		if *genN <= 0 {
 			log.Fatal("в режиме -gen нужно чтобы -n был > 0")
@@ -124,6 +156,49 @@ func sendFile(ctx context.Context, w *kafka.Writer, fileName string) error {
 	}
 
 	log.Printf("-> отправка %s", fileName)
+	return publish(ctx, w, msg)
+}
+
+// sendStatus отправляет событие смены статуса. Ключ — order_uid,
+// чтобы событие попало в ту же партицию, что и заказ, если заказ тоже отправлен с этим ключом.
+func sendStatus(ctx context.Context, w *kafka.Writer, arg string) error {
+	uid, st, ok := strings.Cut(arg, "=")
+	if !ok || uid == "" || st == "" {
+		return fmt.Errorf("ожидается order_uid=status[/rid], получено %q", arg)
+	}
+	st, rid, _ := strings.Cut(st, "/")
+
+	data, err := json.Marshal(models.StatusEvent{OrderUID: uid, Rid: rid, Status: st, At: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+
+	msg := kafka.Message{
+		Key:     []byte(uid),
+		Value:   data,
+		Time:    time.Now(),
+		Headers: []kafka.Header{{Key: "message-type", Value: []byte("status")}},
+	}
+
+	log.Printf("-> статус %s: %s", uid, data)
+	return publish(ctx, w, msg)
+}
+
+// publish отправляет сообщение в спане публикации и кладёт контекст
+// этого спана в заголовки, чтобы консьюмер продолжил ту же трассу.
+func publish(ctx context.Context, w *kafka.Writer, msg kafka.Message) (err error) {
+	ctx, span := tracer.Start(ctx, w.Topic+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "kafka"),
+			attribute.String("messaging.operation.type", "send"),
+			attribute.String("messaging.destination.name", w.Topic),
+			attribute.String("messaging.kafka.message.key", string(msg.Key)),
+		),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	tracing.Inject(ctx, &msg)
 	return w.WriteMessages(ctx, msg)
 }
 
@@ -158,7 +233,7 @@ func sendGenerated(ctx context.Context, w *kafka.Writer, n int, badRate float64,
 			Time:  time.Now(),
 		}
 
-		if err := w.WriteMessages(ctx, msg); err != nil {
+		if err := publish(ctx, w, msg); err != nil {
 			log.Printf("ошибка отправки сообщения %d: %v", i+1, err)
 			continue
 		}
@@ -179,10 +254,12 @@ func makeRandomOrder() models.Order {
 
 	itemsCount := rand.Intn(3) + 1
 	items := make([]models.Item, 0, itemsCount)
+	goodsTotal := 0
 	for i := 0; i < itemsCount; i++ {
 		price := rand.Intn(2000) + 100
 		sale := rand.Intn(50)
 		total := price * (100 - sale) / 100
+		goodsTotal += total
 
 		items = append(items, models.Item{
 			ChrtID:      int64(rand.Intn(10_000_000)),
@@ -199,6 +276,9 @@ func makeRandomOrder() models.Order {
 		})
 	}
 
+	// суммы сходятся, чтобы заказ проходил бизнес-правила (пакет validation)
+	const deliveryCost = 1500
+
 	return models.Order{
 		OrderUID:    uid,
 		TrackNumber: track,
@@ -217,11 +297,11 @@ func makeRandomOrder() models.Order {
 			RequestID:    "",
 			Currency:     "USD",
 			Provider:     "wbpay",
-			Amount:       1000 + rand.Intn(5000),
+			Amount:       goodsTotal + deliveryCost,
 			PaymentDt:    now.Unix(),
 			Bank:         "alpha",
-			DeliveryCost: 1500,
-			GoodsTotal:   317,
+			DeliveryCost: deliveryCost,
+			GoodsTotal:   goodsTotal,
 			CustomFee:    0,
 		},
 		Items:             items,
@@ -11,8 +11,6 @@ until nc -z kafka 9092 >/dev/null 2>&1; do
   sleep 1
 done
 
-echo "run migrations..."
-/app/migrate up
-
+# миграции встроены в бинарник и накатываются самим сервисом (MIGRATIONS_AUTO)
 echo "start service..."
 exec /app/wb-order-service
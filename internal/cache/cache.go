@@ -4,102 +4,396 @@
 package cache
 
 import (
+	"container/list"
+	"fmt"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/models"
 )
 
+// Policy — политика вытеснения, когда кэш заполнен.
+type Policy int
+
+const (
+	// LRU выкидывает запись, к которой дольше всего не обращались (по умолчанию).
+	LRU Policy = iota
+	// FIFO выкидывает запись, которая раньше всех была добавлена.
+	FIFO
+)
+
+// ParsePolicy разбирает название политики: lru или fifo (пустая строка — LRU).
+func ParsePolicy(s string) (Policy, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "lru":
+		return LRU, nil
+	case "fifo":
+		return FIFO, nil
+	}
+	return LRU, fmt.Errorf("unknown cache policy %q, expected lru or fifo", s)
+}
+
 // Cache хранит заказы в памяти.
-// Добавлен простой лимит на количество записей, чтобы кэш не разрастался бесконечно.
+// Записи лежат в двусвязном списке: в начале самые свежие, с конца идёт вытеснение.
+// Поиск, вставка, продвижение и вытеснение — O(1).
 type Cache struct {
-	mu      sync.RWMutex
-	data    map[string]models.Order
-	order   []string // порядок добавления, нужен для инвалидации
+	// обычный Mutex, а не RWMutex: в режиме LRU даже Get двигает запись в списке
+	mu      sync.Mutex
+	items   map[string]*list.Element
+	ll      *list.List
 	maxSize int
+	policy  Policy
+
+	// maxBytes — бюджет памяти по оценке OrderSize, 0 — без ограничения.
+	// bytes — текущая оценка, меняется под mu.
+	maxBytes int64
+	bytes    int64
+
+	// вторичные индексы: track_number и payment.transaction -> order_uid.
+	// У шардов Sharded индекс общий, см. secondaryIndex.
+	idx *secondaryIndex
+
+	ttl     time.Duration
+	cleanup time.Duration
+	now     func() time.Time
+
+	stop      chan struct{}
+	closeOnce sync.Once
+
+	// счётчики для Stats, меняются под mu
+	hits, misses, evictions, expirations uint64
+}
+
+// Stats — счётчики кэша с момента создания.
+type Stats struct {
+	Hits        uint64 `json:"hits"`
+	Misses      uint64 `json:"misses"`
+	Evictions   uint64 `json:"evictions"`   // вытеснено из-за лимита
+	Expirations uint64 `json:"expirations"` // удалено по TTL
+	Size        int    `json:"size"`
+	Capacity    int    `json:"capacity"`
+	Bytes       int64  `json:"bytes"`               // примерный объём заказов в памяти, см. OrderSize
+	MaxBytes    int64  `json:"max_bytes,omitempty"` // бюджет памяти, 0 — без ограничения
+}
+
+// entry — элемент списка.
+type entry struct {
+	order     models.Order
+	expiresAt time.Time // нулевое значение — запись не протухает
+	size      int       // оценка OrderSize на момент записи
+	// snapshot — запись загружена из снимка и с тех пор не перезаписывалась
+	snapshot bool
+	written  time.Time // время последнего Set, по нему Sharded упорядочивает снимок
 }
 
 // defaultMaxSize — максимально количество заказов в кэше.
-// Если заказов больше, самые старые будут выкидываться.
+// Если заказов больше, лишние вытесняются согласно политике.
 const defaultMaxSize = 1000
 
+// Option настраивает кэш.
+type Option func(*Cache)
+
+// WithPolicy задаёт политику вытеснения.
+func WithPolicy(p Policy) Option {
+	return func(c *Cache) { c.policy = p }
+}
+
+// WithMaxBytes ограничивает кэш примерным объёмом памяти (см. OrderSize).
+// Лимит по числу записей при этом тоже действует; вытеснение идёт,
+// пока не выполнены оба. n <= 0 — без ограничения по памяти.
+func WithMaxBytes(n int64) Option {
+	return func(c *Cache) { c.maxBytes = max(n, 0) }
+}
+
+// WithTTL задаёт время жизни записи. Протухшие записи не отдаются из Get
+// и периодически вычищаются фоновой горутиной (её останавливает Close).
+func WithTTL(ttl time.Duration) Option {
+	return func(c *Cache) { c.ttl = ttl }
+}
+
+// WithCleanupInterval задаёт, как часто вычищать протухшие записи.
+// По умолчанию — половина TTL, но не чаще раза в секунду.
+func WithCleanupInterval(d time.Duration) Option {
+	return func(c *Cache) { c.cleanup = d }
+}
+
 // New создаёт новый кэш с лимитом по умолчанию.
-func New() *Cache {
-	return &Cache{
-		data:    make(map[string]models.Order),
-		order:   make([]string, 0, defaultMaxSize),
-		maxSize: defaultMaxSize,
-	}
+func New(opts ...Option) *Cache {
+	return NewWithLimit(defaultMaxSize, opts...)
 }
 
 // NewWithLimit создаёт кэш с заданным лимитом.
-// Оставил на будущее — удобно для тестов или кастомной настройки.
-func NewWithLimit(max int) *Cache {
-	if max <= 0 {
-		max = defaultMaxSize
+func NewWithLimit(limit int, opts ...Option) *Cache {
+	if limit <= 0 {
+		limit = defaultMaxSize
+	}
+
+	c := &Cache{
+		items:   make(map[string]*list.Element, limit),
+		ll:      list.New(),
+		idx:     newSecondaryIndex(limit),
+		maxSize: limit,
+		now:     time.Now,
+		stop:    make(chan struct{}),
 	}
-	return &Cache{
-		data:    make(map[string]models.Order),
-		order:   make([]string, 0, max),
-		maxSize: max,
+	for _, opt := range opts {
+		opt(c)
 	}
+
+	if c.ttl > 0 {
+		if c.cleanup <= 0 {
+			c.cleanup = max(c.ttl/2, time.Second)
+		}
+		go c.janitor()
+	}
+	return c
+}
+
+// Close останавливает фоновую очистку. Кэшем можно пользоваться и после Close.
+func (c *Cache) Close() {
+	c.closeOnce.Do(func() { close(c.stop) })
 }
 
 // Get возвращает заказ по ID из кэша.
+// В режиме LRU запись становится самой свежей.
 func (c *Cache) Get(id string) (models.Order, bool) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.get(id)
+}
+
+// GetByTrack возвращает заказ по track_number.
+func (c *Cache) GetByTrack(track string) (models.Order, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	id, ok := c.idx.track(track)
+	if !ok {
+		c.misses++
+		return models.Order{}, false
+	}
+	return c.get(id)
+}
 
-	o, ok := c.data[id]
-	return o, ok
+// GetByTransaction возвращает заказ по payment.transaction.
+func (c *Cache) GetByTransaction(tx string) (models.Order, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	id, ok := c.idx.tx(tx)
+	if !ok {
+		c.misses++
+		return models.Order{}, false
+	}
+	return c.get(id)
+}
+
+// get ищет запись по order_uid и считает попадание или промах. Вызывается под блокировкой.
+func (c *Cache) get(id string) (models.Order, bool) {
+	e, ok := c.lookup(id)
+	if !ok {
+		c.misses++
+		return models.Order{}, false
+	}
+	c.hits++
+	return e.order, true
+}
+
+// lookup ищет живую запись по order_uid, без счётчиков попаданий.
+// Протухшая запись удаляется. Вызывается под блокировкой.
+func (c *Cache) lookup(id string) (*entry, bool) {
+	el, ok := c.items[id]
+	if !ok {
+		return nil, false
+	}
+
+	e := el.Value.(*entry)
+	if c.expired(e) {
+		c.remove(el)
+		c.expirations++
+		return nil, false
+	}
+
+	if c.policy == LRU {
+		c.ll.MoveToFront(el)
+	}
+	return e, true
 }
 
 // Set добавляет или обновляет заказ в кэше по его OrderUID.
-// Если записей становится больше лимита, то выкидывается самый старый.
+// Если записей становится больше лимита или превышен бюджет памяти (WithMaxBytes),
+// то вытесняются записи с конца списка.
 func (c *Cache) Set(o models.Order) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if _, exists := c.data[o.OrderUID]; !exists {
-		c.order = append(c.order, o.OrderUID)
-	}
-	c.data[o.OrderUID] = o
+	c.set(o)
+}
 
-	if c.maxSize > 0 && len(c.order) > c.maxSize {
-		// выкидываем самый старый
-		oldestID := c.order[0]
-		c.order = c.order[1:]
-		delete(c.data, oldestID)
+// SetIfAbsent кладёт заказ, только если его нет в кэше или запись протухла.
+// Нужен для чтения из БД при промахе: пока шёл запрос, консьюмер мог записать
+// и положить в кэш более свежую копию, затирать её прочитанной строкой нельзя.
+func (c *Cache) SetIfAbsent(o models.Order) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[o.OrderUID]; ok && !c.expired(el.Value.(*entry)) {
+		// ключ индекса мог освободиться при вытеснении другого заказа,
+		// тогда запись, за которой ходили в БД, снова его займёт
+		c.idx.add(el.Value.(*entry).order)
+		return false
 	}
+	c.set(o)
+	return true
 }
 
 // Load загружает список заказов в кэш.
 // Используется при прогреве кэша при старте сервиса.
+// Если заказов больше лимита, остаются последние из списка.
 func (c *Cache) Load(os []models.Order) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	c.data = make(map[string]models.Order, len(os))
-	c.order = c.order[:0]
+	c.load(os, false)
+}
 
-	for _, o := range os {
-		c.data[o.OrderUID] = o
-		c.order = append(c.order, o.OrderUID)
-	}
+// load заменяет содержимое кэша. Вызывается под блокировкой.
+func (c *Cache) load(os []models.Order, fromSnapshot bool) {
+	c.idx.reset(len(os))
+	c.fill(os, fromSnapshot)
+}
+
+// fill заменяет записи кэша, не сбрасывая вторичные индексы: у шардов Sharded
+// индекс общий, его сбрасывает сам Sharded. Вызывается под блокировкой.
+func (c *Cache) fill(os []models.Order, fromSnapshot bool) {
+	c.items = make(map[string]*list.Element, len(os))
+	c.ll.Init()
+	c.bytes = 0
 
-	// Если из БД пришло больше, чем maxSize, то оставляем только последние maxSize.
-	if c.maxSize > 0 && len(c.order) > c.maxSize {
-		start := len(c.order) - c.maxSize
-		for _, id := range c.order[:start] {
-			delete(c.data, id)
+	for _, o := range os {
+		c.set(o)
+		// заказ больше бюджета памяти мог сразу же вытесниться
+		if el, ok := c.items[o.OrderUID]; ok && fromSnapshot {
+			el.Value.(*entry).snapshot = true
 		}
-		c.order = c.order[start:]
 	}
 }
 
 // Size возвращает количество записей в кэше.
 // Полезно для отладки и/или тестов.
 func (c *Cache) Size() int {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	return len(c.data)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+// Bytes возвращает примерный объём заказов в кэше в байтах (см. OrderSize).
+func (c *Cache) Bytes() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.bytes
+}
+
+// Stats возвращает счётчики попаданий, промахов и вытеснений.
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return Stats{
+		Hits:        c.hits,
+		Misses:      c.misses,
+		Evictions:   c.evictions,
+		Expirations: c.expirations,
+		Size:        c.ll.Len(),
+		Capacity:    c.maxSize,
+		Bytes:       c.bytes,
+		MaxBytes:    c.maxBytes,
+	}
+}
+
+// set добавляет запись. Вызывается под блокировкой.
+// Если записей становится больше лимита или оценка памяти больше бюджета,
+// записи вытесняются с конца списка. Заказ больше всего бюджета
+// в итоге вытесняется и сам.
+func (c *Cache) set(o models.Order) {
+	now := c.now()
+	var expiresAt time.Time
+	if c.ttl > 0 {
+		expiresAt = now.Add(c.ttl)
+	}
+	size := OrderSize(o)
+
+	if el, ok := c.items[o.OrderUID]; ok {
+		e := el.Value.(*entry)
+		c.idx.remove(e.order)
+		c.idx.add(o)
+		c.bytes += int64(size - e.size)
+		e.order = o
+		e.size = size
+		e.expiresAt = expiresAt
+		e.snapshot = false
+		e.written = now
+		if c.policy == LRU {
+			c.ll.MoveToFront(el)
+		}
+	} else {
+		c.items[o.OrderUID] = c.ll.PushFront(&entry{order: o, expiresAt: expiresAt, size: size, written: now})
+		c.idx.add(o)
+		c.bytes += int64(size)
+	}
+
+	for c.ll.Len() > c.maxSize || c.overBudget() {
+		c.remove(c.ll.Back())
+		c.evictions++
+	}
+}
+
+// overBudget сообщает, превышен ли бюджет памяти. Вызывается под блокировкой.
+func (c *Cache) overBudget() bool {
+	return c.maxBytes > 0 && c.bytes > c.maxBytes
+}
+
+// remove удаляет элемент из списка и индекса. Вызывается под блокировкой.
+func (c *Cache) remove(el *list.Element) {
+	e := c.ll.Remove(el).(*entry)
+	delete(c.items, e.order.OrderUID)
+	c.idx.remove(e.order)
+	c.bytes -= int64(e.size)
+}
+
+// expired сообщает, протухла ли запись.
+func (c *Cache) expired(e *entry) bool {
+	return !e.expiresAt.IsZero() && !c.now().Before(e.expiresAt)
+}
+
+// janitor периодически вычищает протухшие записи.
+func (c *Cache) janitor() {
+	t := time.NewTicker(c.cleanup)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-t.C:
+			c.removeExpired()
+		}
+	}
+}
+
+// removeExpired удаляет все протухшие записи.
+func (c *Cache) removeExpired() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for el := c.ll.Back(); el != nil; {
+		prev := el.Prev()
+		if c.expired(el.Value.(*entry)) {
+			c.remove(el)
+			c.expirations++
+		}
+		el = prev
+	}
 }
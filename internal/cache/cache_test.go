@@ -1,6 +1,13 @@
 package cache
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
@@ -69,3 +76,750 @@ func TestCacheEviction(t *testing.T) {
 		t.Fatalf("expected order 3 to exist")
 	}
 }
+
+func TestCacheLRUPromotesOnGet(t *testing.T) {
+	c := NewWithLimit(2)
+
+	c.Set(models.Order{OrderUID: "1"})
+	c.Set(models.Order{OrderUID: "2"})
+
+	// 1 становится самым свежим, вытеснен должен быть 2
+	if _, ok := c.Get("1"); !ok {
+		t.Fatalf("expected order 1 to exist")
+	}
+	c.Set(models.Order{OrderUID: "3"})
+
+	if _, ok := c.Get("2"); ok {
+		t.Fatalf("expected order 2 to be evicted")
+	}
+	if _, ok := c.Get("1"); !ok {
+		t.Fatalf("expected order 1 to survive")
+	}
+
+	// повторный Set тоже освежает запись
+	c.Set(models.Order{OrderUID: "3", TrackNumber: "new"})
+	c.Set(models.Order{OrderUID: "4"})
+	if _, ok := c.Get("1"); ok {
+		t.Fatalf("expected order 1 to be evicted after re-set of 3")
+	}
+	if got, _ := c.Get("3"); got.TrackNumber != "new" {
+		t.Fatalf("expected updated order 3, got %+v", got)
+	}
+}
+
+func TestCacheFIFOPolicy(t *testing.T) {
+	c := NewWithLimit(2, WithPolicy(FIFO))
+
+	c.Set(models.Order{OrderUID: "1"})
+	c.Set(models.Order{OrderUID: "2"})
+	c.Get("1")
+	c.Set(models.Order{OrderUID: "3"})
+
+	if _, ok := c.Get("1"); ok {
+		t.Fatalf("expected order 1 to be evicted in FIFO mode")
+	}
+	if _, ok := c.Get("2"); !ok {
+		t.Fatalf("expected order 2 to exist")
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	c := NewWithLimit(10, WithTTL(time.Minute), WithCleanupInterval(time.Hour))
+	defer c.Close()
+	c.now = func() time.Time { return now }
+
+	c.Set(models.Order{OrderUID: "1"})
+	now = now.Add(30 * time.Second)
+	c.Set(models.Order{OrderUID: "2"})
+
+	if _, ok := c.Get("1"); !ok {
+		t.Fatalf("expected order 1 to be alive")
+	}
+
+	now = now.Add(45 * time.Second)
+	if _, ok := c.Get("1"); ok {
+		t.Fatalf("expected order 1 to expire")
+	}
+
+	now = now.Add(time.Minute)
+	c.removeExpired()
+	if c.Size() != 0 {
+		t.Fatalf("expected empty cache after cleanup, got %d", c.Size())
+	}
+}
+
+func TestCacheLoadKeepsLast(t *testing.T) {
+	c := NewWithLimit(2)
+
+	c.Load([]models.Order{{OrderUID: "1"}, {OrderUID: "2"}, {OrderUID: "3"}})
+
+	if c.Size() != 2 {
+		t.Fatalf("expected size=2, got %d", c.Size())
+	}
+	if _, ok := c.Get("1"); ok {
+		t.Fatalf("expected order 1 to be dropped")
+	}
+}
+
+func TestCacheSecondaryIndexes(t *testing.T) {
+	c := NewWithLimit(2)
+
+	o := models.Order{OrderUID: "1", TrackNumber: "T1", Payment: models.Payment{Transaction: "tx1"}}
+	c.Set(o)
+
+	if got, ok := c.GetByTrack("T1"); !ok || got.OrderUID != "1" {
+		t.Fatalf("expected order 1 by track, got %v %v", got.OrderUID, ok)
+	}
+	if got, ok := c.GetByTransaction("tx1"); !ok || got.OrderUID != "1" {
+		t.Fatalf("expected order 1 by transaction, got %v %v", got.OrderUID, ok)
+	}
+
+	// смена трека у того же заказа убирает старый ключ
+	o.TrackNumber = "T2"
+	c.Set(o)
+	if _, ok := c.GetByTrack("T1"); ok {
+		t.Fatalf("old track should not be indexed")
+	}
+	if _, ok := c.GetByTrack("T2"); !ok {
+		t.Fatalf("new track should be indexed")
+	}
+
+	// вытесненный заказ пропадает и из индексов
+	c.Set(models.Order{OrderUID: "2", TrackNumber: "T3"})
+	c.Set(models.Order{OrderUID: "3", TrackNumber: "T4"})
+	if _, ok := c.GetByTransaction("tx1"); ok {
+		t.Fatalf("evicted order should not be found by transaction")
+	}
+	if _, ok := c.GetByTrack("T2"); ok {
+		t.Fatalf("evicted order should not be found by track")
+	}
+}
+
+func TestCacheSetIfAbsent(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewWithLimit(10, WithTTL(time.Minute), WithCleanupInterval(time.Hour))
+	defer c.Close()
+	c.now = func() time.Time { return now }
+
+	if !c.SetIfAbsent(models.Order{OrderUID: "1", TrackNumber: "db"}) {
+		t.Fatalf("expected absent order to be set")
+	}
+	c.Set(models.Order{OrderUID: "1", TrackNumber: "consumer"})
+	if c.SetIfAbsent(models.Order{OrderUID: "1", TrackNumber: "stale"}) {
+		t.Fatalf("expected present order to be kept")
+	}
+	if o, _ := c.Get("1"); o.TrackNumber != "consumer" {
+		t.Fatalf("expected consumer copy, got %q", o.TrackNumber)
+	}
+
+	// протухшая запись считается отсутствующей
+	now = now.Add(2 * time.Minute)
+	if !c.SetIfAbsent(models.Order{OrderUID: "1", TrackNumber: "db"}) {
+		t.Fatalf("expected expired order to be replaced")
+	}
+}
+
+func TestCacheSecondaryIndexPrefersNewest(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	older := models.Order{OrderUID: "a", TrackNumber: "SHARED", DateCreated: base, Payment: models.Payment{Transaction: "tx"}}
+	newest := models.Order{OrderUID: "b", TrackNumber: "SHARED", DateCreated: base.Add(time.Hour), Payment: models.Payment{Transaction: "tx"}}
+
+	// прогрев отдаёт заказы от новых к старым
+	c := NewWithLimit(10)
+	c.Load([]models.Order{newest, older})
+	if o, _ := c.GetByTrack("SHARED"); o.OrderUID != "b" {
+		t.Fatalf("expected newest order b by track after load, got %q", o.OrderUID)
+	}
+	if o, _ := c.GetByTransaction("tx"); o.OrderUID != "b" {
+		t.Fatalf("expected newest order b by transaction after load, got %q", o.OrderUID)
+	}
+
+	// повторная запись старого заказа индекс не перехватывает
+	c.Set(older)
+	if o, _ := c.GetByTrack("SHARED"); o.OrderUID != "b" {
+		t.Fatalf("expected newest order b after re-set of a, got %q", o.OrderUID)
+	}
+
+	// при равной дате побеждает больший order_uid, как в БД
+	same := older
+	same.OrderUID = "c"
+	same.DateCreated = newest.DateCreated
+	c.Set(same)
+	if o, _ := c.GetByTrack("SHARED"); o.OrderUID != "c" {
+		t.Fatalf("expected order c on equal date, got %q", o.OrderUID)
+	}
+}
+
+func TestCacheStats(t *testing.T) {
+	c := NewWithLimit(2)
+	c.Set(models.Order{OrderUID: "a"})
+	c.Set(models.Order{OrderUID: "b"})
+	c.Set(models.Order{OrderUID: "c"}) // вытесняет a
+
+	c.Get("b")
+	c.Get("a")
+	c.GetByTrack("unknown")
+
+	st := c.Stats()
+	if st.Hits != 1 || st.Misses != 2 || st.Evictions != 1 || st.Size != 2 || st.Capacity != 2 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+}
+
+func TestCacheSnapshotRoundTrip(t *testing.T) {
+	src := NewWithLimit(10)
+	src.Set(models.Order{OrderUID: "1", TrackNumber: "T1", Payment: models.Payment{Transaction: "tx1"}})
+	src.Set(models.Order{OrderUID: "2", TrackNumber: "T2"})
+	src.Set(models.Order{OrderUID: "3", TrackNumber: "T3"})
+	src.Get("1") // 1 самый свежий
+
+	var buf bytes.Buffer
+	info, err := src.WriteSnapshot(&buf)
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if info.Orders != 3 {
+		t.Fatalf("expected 3 orders, got %d", info.Orders)
+	}
+
+	// в кэше поменьше остаются самые свежие
+	dst := NewWithLimit(2)
+	dst.Set(models.Order{OrderUID: "old"})
+	got, err := dst.ReadSnapshot(&buf)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if got.Orders != 3 || !got.TakenAt.Equal(info.TakenAt) {
+		t.Fatalf("unexpected info: %+v, want %+v", got, info)
+	}
+	if dst.Size() != 2 {
+		t.Fatalf("expected size=2, got %d", dst.Size())
+	}
+	for _, id := range []string{"old", "2"} {
+		if _, ok := dst.Get(id); ok {
+			t.Fatalf("expected order %s to be gone", id)
+		}
+	}
+	if o, ok := dst.GetByTransaction("tx1"); !ok || o.OrderUID != "1" {
+		t.Fatalf("expected order 1 by transaction, got %v %v", o.OrderUID, ok)
+	}
+	if _, ok := dst.GetByTrack("T3"); !ok {
+		t.Fatalf("expected order 3 by track")
+	}
+}
+
+func TestCacheSnapshotSkipsExpired(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewWithLimit(10, WithTTL(time.Minute), WithCleanupInterval(time.Hour))
+	defer c.Close()
+	c.now = func() time.Time { return now }
+
+	c.Set(models.Order{OrderUID: "1"})
+	now = now.Add(45 * time.Second)
+	c.Set(models.Order{OrderUID: "2"})
+	now = now.Add(30 * time.Second)
+
+	info, err := c.WriteSnapshot(&bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if info.Orders != 1 || !info.TakenAt.Equal(now) {
+		t.Fatalf("unexpected info: %+v", info)
+	}
+}
+
+func TestCacheSnapshotBroken(t *testing.T) {
+	src := NewWithLimit(10)
+	src.Set(models.Order{OrderUID: "1"})
+
+	var buf bytes.Buffer
+	if _, err := src.WriteSnapshot(&buf); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	good := buf.Bytes()
+
+	flip := func(i int) []byte {
+		b := bytes.Clone(good)
+		b[i] ^= 0xff
+		return b
+	}
+	cases := map[string][]byte{
+		"empty":     nil,
+		"magic":     flip(0),
+		"version":   flip(9),
+		"checksum":  flip(snapshotHeader - 1),
+		"data":      flip(len(good) - 1),
+		"truncated": good[:len(good)-1],
+	}
+	for name, b := range cases {
+		t.Run(name, func(t *testing.T) {
+			c := NewWithLimit(10)
+			c.Set(models.Order{OrderUID: "keep"})
+
+			if _, err := c.ReadSnapshot(bytes.NewReader(b)); !errors.Is(err, ErrBadSnapshot) {
+				t.Fatalf("expected ErrBadSnapshot, got %v", err)
+			}
+			if _, ok := c.Get("keep"); !ok || c.Size() != 1 {
+				t.Fatalf("cache must stay unchanged")
+			}
+		})
+	}
+}
+
+func TestCacheSnapshotFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.snap")
+
+	c := NewWithLimit(10)
+	if _, err := c.LoadSnapshot(path); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected fs.ErrNotExist, got %v", err)
+	}
+
+	c.Set(models.Order{OrderUID: "1"})
+	if _, err := c.SaveSnapshot(path); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	c.Set(models.Order{OrderUID: "2"})
+	if _, err := c.SaveSnapshot(path); err != nil {
+		t.Fatalf("save over existing: %v", err)
+	}
+
+	restored := NewWithLimit(10)
+	info, err := restored.LoadSnapshot(path)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if info.Orders != 2 || restored.Size() != 2 {
+		t.Fatalf("expected 2 orders, got %+v size=%d", info, restored.Size())
+	}
+
+	// временные файлы не остаются
+	if m, _ := filepath.Glob(path + ".tmp-*"); len(m) != 0 {
+		t.Fatalf("temp files left: %v", m)
+	}
+}
+
+// fakeChanges — ChangeSource для тестов Reconcile.
+type fakeChanges struct {
+	since  time.Time
+	orders []models.Order
+}
+
+func (f *fakeChanges) StreamOrdersUpdatedSince(_ context.Context, since time.Time, fn func(models.Order) error) error {
+	f.since = since
+	for _, o := range f.orders {
+		if err := fn(o); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestCacheReconcile(t *testing.T) {
+	src := NewWithLimit(10)
+	src.Set(models.Order{OrderUID: "1", TrackNumber: "snap"})
+	src.Set(models.Order{OrderUID: "2", TrackNumber: "snap"})
+
+	var buf bytes.Buffer
+	info, err := src.WriteSnapshot(&buf)
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	c := NewWithLimit(10)
+	if _, err := c.ReadSnapshot(&buf); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	// консьюмер успел записать более свежую версию 2
+	c.Set(models.Order{OrderUID: "2", TrackNumber: "consumer"})
+
+	ch := &fakeChanges{orders: []models.Order{
+		{OrderUID: "1", TrackNumber: "db"},
+		{OrderUID: "2", TrackNumber: "db"},
+		{OrderUID: "3", TrackNumber: "db"},
+	}}
+	n, err := c.Reconcile(context.Background(), ch, info.TakenAt)
+	if err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if n != 2 {
+		t.Fatalf("expected 2 updated, got %d", n)
+	}
+	if !ch.since.Equal(info.TakenAt) {
+		t.Fatalf("expected since=%v, got %v", info.TakenAt, ch.since)
+	}
+
+	want := map[string]string{"1": "db", "2": "consumer", "3": "db"}
+	for id, track := range want {
+		if o, _ := c.Get(id); o.TrackNumber != track {
+			t.Fatalf("order %s: expected track %q, got %q", id, track, o.TrackNumber)
+		}
+	}
+
+	// повторная догрузка уже ничего не меняет
+	if n, _ := c.Reconcile(context.Background(), ch, info.TakenAt); n != 0 {
+		t.Fatalf("expected nothing to update, got %d", n)
+	}
+}
+
+// orderWithItems — заказ с n одинаковыми позициями.
+func orderWithItems(id string, n int) models.Order {
+	items := make([]models.Item, n)
+	for i := range items {
+		items[i] = models.Item{ChrtID: int64(i + 1), TrackNumber: "T", Rid: "rid", Name: "item", Size: "0", Brand: "b"}
+	}
+	return models.Order{OrderUID: id, TrackNumber: "T" + id, Items: items}
+}
+
+func TestOrderSizeGrowsWithItems(t *testing.T) {
+	small := OrderSize(orderWithItems("1", 1))
+	perItem := OrderSize(orderWithItems("1", 2)) - small
+	big := OrderSize(orderWithItems("1", 500))
+
+	if small <= 0 || perItem <= 0 {
+		t.Fatalf("expected positive sizes, got %d and %d per item", small, perItem)
+	}
+	if big != small+499*perItem {
+		t.Fatalf("expected size to grow linearly with items: %d vs %d+499*%d", big, small, perItem)
+	}
+}
+
+func TestCacheMaxBytes(t *testing.T) {
+	one := int64(OrderSize(orderWithItems("1", 1)))
+	c := NewWithLimit(100, WithMaxBytes(3*one))
+
+	c.Set(orderWithItems("1", 1))
+	c.Set(orderWithItems("2", 1))
+	c.Set(orderWithItems("3", 1))
+	if c.Size() != 3 || c.Bytes() != 3*one {
+		t.Fatalf("expected 3 orders and %d bytes, got %d and %d", 3*one, c.Size(), c.Bytes())
+	}
+
+	// большой заказ вытесняет столько старых, сколько нужно
+	big := orderWithItems("4", 2)
+	c.Set(big)
+	if c.Bytes() > 3*one {
+		t.Fatalf("bytes %d over budget %d", c.Bytes(), 3*one)
+	}
+	for _, id := range []string{"1", "2"} {
+		if _, ok := c.Get(id); ok {
+			t.Fatalf("expected order %s to be evicted", id)
+		}
+	}
+	if _, ok := c.Get("4"); !ok {
+		t.Fatalf("expected order 4 to exist")
+	}
+
+	// обновление заказа пересчитывает его размер
+	c.Set(orderWithItems("4", 1))
+	if want := 2 * one; c.Bytes() != want {
+		t.Fatalf("expected %d bytes after shrinking order 4, got %d", want, c.Bytes())
+	}
+
+	st := c.Stats()
+	if st.Bytes != c.Bytes() || st.MaxBytes != 3*one || st.Evictions != 2 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+}
+
+func TestCacheMaxBytesOversizedOrder(t *testing.T) {
+	one := int64(OrderSize(orderWithItems("1", 1)))
+	c := NewWithLimit(100, WithMaxBytes(2*one))
+	c.Set(orderWithItems("1", 1))
+
+	// заказ больше всего бюджета в кэш не попадает
+	c.Set(orderWithItems("big", 50))
+	if _, ok := c.Get("big"); ok {
+		t.Fatalf("expected oversized order not to be cached")
+	}
+	if c.Size() != 0 || c.Bytes() != 0 {
+		t.Fatalf("expected empty cache, got size=%d bytes=%d", c.Size(), c.Bytes())
+	}
+
+	// и не ломает загрузку из снимка
+	src := NewWithLimit(100)
+	src.Set(orderWithItems("big", 50))
+	src.Set(orderWithItems("2", 1))
+	var buf bytes.Buffer
+	if _, err := src.WriteSnapshot(&buf); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, err := c.ReadSnapshot(&buf); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if _, ok := c.Get("2"); !ok || c.Bytes() != one {
+		t.Fatalf("expected only order 2 after load, bytes=%d", c.Bytes())
+	}
+}
+
+func TestCacheBytesTrackRemoval(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewWithLimit(1, WithTTL(time.Minute), WithCleanupInterval(time.Hour))
+	defer c.Close()
+	c.now = func() time.Time { return now }
+
+	c.Set(orderWithItems("1", 3))
+	c.Set(orderWithItems("2", 1)) // вытесняет 1 по лимиту записей
+	if want := int64(OrderSize(orderWithItems("2", 1))); c.Bytes() != want {
+		t.Fatalf("expected %d bytes, got %d", want, c.Bytes())
+	}
+
+	now = now.Add(2 * time.Minute)
+	c.removeExpired()
+	if c.Bytes() != 0 {
+		t.Fatalf("expected 0 bytes after expiration, got %d", c.Bytes())
+	}
+
+	c.Set(orderWithItems("3", 1))
+	c.Load(nil)
+	if c.Bytes() != 0 {
+		t.Fatalf("expected 0 bytes after load, got %d", c.Bytes())
+	}
+}
+
+func TestShardedSetGet(t *testing.T) {
+	s := NewSharded(4, 100)
+	for i := range 50 {
+		s.Set(models.Order{OrderUID: fmt.Sprint(i), TrackNumber: fmt.Sprint("T", i), Payment: models.Payment{Transaction: fmt.Sprint("tx", i)}})
+	}
+
+	if s.Size() != 50 {
+		t.Fatalf("expected size=50, got %d", s.Size())
+	}
+	for i := range 50 {
+		if o, ok := s.Get(fmt.Sprint(i)); !ok || o.OrderUID != fmt.Sprint(i) {
+			t.Fatalf("expected order %d, got %v %v", i, o.OrderUID, ok)
+		}
+		if o, ok := s.GetByTrack(fmt.Sprint("T", i)); !ok || o.OrderUID != fmt.Sprint(i) {
+			t.Fatalf("expected order %d by track, got %v %v", i, o.OrderUID, ok)
+		}
+		if o, ok := s.GetByTransaction(fmt.Sprint("tx", i)); !ok || o.OrderUID != fmt.Sprint(i) {
+			t.Fatalf("expected order %d by transaction, got %v %v", i, o.OrderUID, ok)
+		}
+	}
+
+	// заказы разошлись по шардам
+	used := 0
+	for _, c := range s.shards {
+		if c.Size() > 0 {
+			used++
+		}
+	}
+	if used < 2 {
+		t.Fatalf("expected orders spread over shards, used %d", used)
+	}
+}
+
+func TestShardedLimits(t *testing.T) {
+	s := NewSharded(4, 10, WithMaxBytes(4000))
+	for _, c := range s.shards {
+		if c.maxSize != 3 || c.maxBytes != 1000 {
+			t.Fatalf("expected per-shard limits 3 and 1000, got %d and %d", c.maxSize, c.maxBytes)
+		}
+	}
+
+	for i := range 100 {
+		s.Set(models.Order{OrderUID: fmt.Sprint(i)})
+	}
+	st := s.Stats()
+	if st.Size > 12 || st.Capacity != 12 || st.MaxBytes != 4000 || st.Evictions != uint64(100-st.Size) {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+	if st.Bytes != s.Bytes() || st.Bytes > 4000 {
+		t.Fatalf("unexpected bytes: %d", st.Bytes)
+	}
+}
+
+func TestShardedSecondaryIndexPrefersNewest(t *testing.T) {
+	s := NewSharded(8, 100)
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// у разных заказов один трек, заказы почти наверняка в разных шардах;
+	// самый свежий записан первым
+	for i := range 20 {
+		s.Set(models.Order{OrderUID: fmt.Sprint(i), TrackNumber: "SAME", DateCreated: base.Add(-time.Duration(i) * time.Hour)})
+	}
+	if o, ok := s.GetByTrack("SAME"); !ok || o.OrderUID != "0" {
+		t.Fatalf("expected newest order 0, got %v %v", o.OrderUID, ok)
+	}
+
+	s.Set(models.Order{OrderUID: "3", TrackNumber: "SAME", DateCreated: base.Add(time.Hour)})
+	if o, _ := s.GetByTrack("SAME"); o.OrderUID != "3" {
+		t.Fatalf("expected order 3 with later date, got %v", o.OrderUID)
+	}
+
+	s.GetByTrack("unknown")
+	if st := s.Stats(); st.Hits != 2 || st.Misses != 1 {
+		t.Fatalf("expected 2 hits and 1 miss, got %+v", st)
+	}
+}
+
+func TestShardedSecondaryIndexFollowsRemoval(t *testing.T) {
+	// по одной записи на шард: новый заказ в шарде вытесняет прежний
+	s := NewSharded(2, 2)
+
+	// два заказа из одного шарда
+	var ids []string
+	for i := 0; len(ids) < 2; i++ {
+		if id := fmt.Sprint(i); s.shard(id) == s.shards[0] {
+			ids = append(ids, id)
+		}
+	}
+
+	s.Set(models.Order{OrderUID: ids[0], TrackNumber: "T1", Payment: models.Payment{Transaction: "tx1"}})
+	if o, ok := s.GetByTransaction("tx1"); !ok || o.OrderUID != ids[0] {
+		t.Fatalf("expected order %s by transaction, got %v %v", ids[0], o.OrderUID, ok)
+	}
+
+	// вытесненный заказ пропадает и из общего индекса
+	s.Set(models.Order{OrderUID: ids[1], TrackNumber: "T2"})
+	if _, ok := s.GetByTrack("T1"); ok {
+		t.Fatalf("evicted order should not be found by track")
+	}
+	if _, ok := s.GetByTransaction("tx1"); ok {
+		t.Fatalf("evicted order should not be found by transaction")
+	}
+
+	// Load заменяет индекс целиком
+	s.Load([]models.Order{{OrderUID: ids[0], TrackNumber: "T3"}})
+	if _, ok := s.GetByTrack("T2"); ok {
+		t.Fatalf("order dropped by load should not be found by track")
+	}
+	if o, ok := s.GetByTrack("T3"); !ok || o.OrderUID != ids[0] {
+		t.Fatalf("expected loaded order %s by track, got %v %v", ids[0], o.OrderUID, ok)
+	}
+}
+
+func TestShardedSnapshot(t *testing.T) {
+	s := NewSharded(4, 100)
+	for i := range 10 {
+		s.Set(models.Order{OrderUID: fmt.Sprint(i)})
+	}
+
+	var buf bytes.Buffer
+	if _, err := s.WriteSnapshot(&buf); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	// снимок шардированного кэша читается обычным, порядок — по времени записи
+	c := NewWithLimit(3)
+	if _, err := c.ReadSnapshot(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	for _, id := range []string{"7", "8", "9"} {
+		if _, ok := c.Get(id); !ok {
+			t.Fatalf("expected freshest order %s to be loaded", id)
+		}
+	}
+
+	// и наоборот, с догрузкой изменений
+	restored := NewSharded(2, 100)
+	info, err := restored.ReadSnapshot(&buf)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if info.Orders != 10 || restored.Size() != 10 {
+		t.Fatalf("expected 10 orders, got %+v size=%d", info, restored.Size())
+	}
+	restored.Set(models.Order{OrderUID: "1", TrackNumber: "consumer"})
+	ch := &fakeChanges{orders: []models.Order{{OrderUID: "1", TrackNumber: "db"}, {OrderUID: "2", TrackNumber: "db"}}}
+	if n, err := restored.Reconcile(context.Background(), ch, info.TakenAt); err != nil || n != 1 {
+		t.Fatalf("expected 1 updated, got %d %v", n, err)
+	}
+	if o, _ := restored.Get("1"); o.TrackNumber != "consumer" {
+		t.Fatalf("reconcile must not override order 1, got %q", o.TrackNumber)
+	}
+}
+
+func TestShardedConcurrent(t *testing.T) {
+	s := NewSharded(4, 1000)
+
+	var wg sync.WaitGroup
+	for w := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 500 {
+				id := fmt.Sprint(w, "-", i)
+				s.Set(models.Order{OrderUID: id, TrackNumber: "T" + id})
+				s.Get(id)
+				s.GetByTrack("T" + id)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if s.Size() > 1000 {
+		t.Fatalf("size %d over limit", s.Size())
+	}
+	if st := s.Stats(); st.Evictions != uint64(8*500-st.Size) {
+		t.Fatalf("evictions do not add up: %+v", st)
+	}
+}
+
+// benchOrders — заказы для бенчмарков, с парой позиций, как в реальном потоке.
+func benchOrders(n int) []models.Order {
+	os := make([]models.Order, n)
+	for i := range os {
+		os[i] = orderWithItems(fmt.Sprint("order-", i), 2)
+	}
+	return os
+}
+
+// BenchmarkCacheParallel сравнивает кэш с одной блокировкой и шардированный
+// при параллельных Get/Set в разных пропорциях:
+//
+//	go test ./internal/cache -run ^$ -bench CacheParallel -cpu 1,4,8
+func BenchmarkCacheParallel(b *testing.B) {
+	const keys = 10_000
+	orders := benchOrders(keys)
+
+	impls := []struct {
+		name string
+		make func() OrderCache
+	}{
+		{"single", func() OrderCache { return NewWithLimit(keys) }},
+		{"sharded-16", func() OrderCache { return NewSharded(16, keys) }},
+		{"sharded-64", func() OrderCache { return NewSharded(64, keys) }},
+	}
+	mixes := []struct {
+		name   string
+		writes int // записей на каждые 100 операций
+	}{
+		{"read-only", 0},
+		{"read-90", 10},
+		{"read-50", 50},
+	}
+
+	for _, impl := range impls {
+		for _, mix := range mixes {
+			b.Run(impl.name+"/"+mix.name, func(b *testing.B) {
+				c := impl.make()
+				c.Load(orders)
+
+				var worker sync.Mutex
+				next := 0
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					// у каждой горутины своё смещение, чтобы они не ходили по одним ключам
+					worker.Lock()
+					i := next * 7919
+					next++
+					worker.Unlock()
+
+					for pb.Next() {
+						i++
+						o := orders[i%keys]
+						if i%100 < mix.writes {
+							c.Set(o)
+						} else {
+							c.Get(o.OrderUID)
+						}
+					}
+				})
+			})
+		}
+	}
+}
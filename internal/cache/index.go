@@ -0,0 +1,104 @@
+package cache
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/models"
+)
+
+// indexRef — заказ, на который указывает ключ вторичного индекса.
+// Дата создания лежит рядом, чтобы сравнить заказы (см. newer),
+// не заглядывая в шард, где хранится заказ.
+type indexRef struct {
+	id      string
+	created time.Time
+}
+
+// secondaryIndex — вторичные индексы: track_number и payment.transaction -> order_uid.
+// У Cache индекс свой, у Sharded — один на все шарды. Поэтому у индекса свой мьютекс:
+// запись идёт под мьютексом шарда, который меняет заказ, а поиск в Sharded читает
+// индекс без блокировки шардов. Наоборот (шард под индексом) блокировки не берутся.
+type secondaryIndex struct {
+	mu      sync.RWMutex
+	byTrack map[string]indexRef
+	byTx    map[string]indexRef
+}
+
+func newSecondaryIndex(n int) *secondaryIndex {
+	x := &secondaryIndex{}
+	x.reset(n)
+	return x
+}
+
+// reset очищает индекс.
+func (x *secondaryIndex) reset(n int) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.byTrack = make(map[string]indexRef, n)
+	x.byTx = make(map[string]indexRef, n)
+}
+
+// track возвращает order_uid по track_number.
+func (x *secondaryIndex) track(key string) (string, bool) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	ref, ok := x.byTrack[key]
+	return ref.id, ok
+}
+
+// tx возвращает order_uid по payment.transaction.
+func (x *secondaryIndex) tx(key string) (string, bool) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	ref, ok := x.byTx[key]
+	return ref.id, ok
+}
+
+// add добавляет заказ в индексы. Если у нескольких заказов одинаковый track_number
+// или transaction, индекс указывает на самый свежий по DateCreated, как и выборка
+// из БД (см. newer), а не на последний записанный: иначе после прогрева, где заказы
+// идут от новых к старым, он указывал бы на самый старый.
+func (x *secondaryIndex) add(o models.Order) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	ref := indexRef{id: o.OrderUID, created: o.DateCreated}
+	if o.TrackNumber != "" && canIndex(x.byTrack, o.TrackNumber, ref) {
+		x.byTrack[o.TrackNumber] = ref
+	}
+	if o.Payment.Transaction != "" && canIndex(x.byTx, o.Payment.Transaction, ref) {
+		x.byTx[o.Payment.Transaction] = ref
+	}
+}
+
+// remove убирает заказ из индексов, если они указывают на него.
+func (x *secondaryIndex) remove(o models.Order) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if x.byTrack[o.TrackNumber].id == o.OrderUID {
+		delete(x.byTrack, o.TrackNumber)
+	}
+	if x.byTx[o.Payment.Transaction].id == o.OrderUID {
+		delete(x.byTx, o.Payment.Transaction)
+	}
+}
+
+// canIndex сообщает, может ли заказ занять ключ в индексе. Вызывается под блокировкой.
+func canIndex(idx map[string]indexRef, key string, ref indexRef) bool {
+	cur, ok := idx[key]
+	return !ok || cur.id == ref.id || newer(ref, cur)
+}
+
+// newer сообщает, свежее ли заказ a заказа b для вторичных индексов.
+// Порядок тот же, что в БД: date_created DESC, затем order_uid DESC.
+func newer(a, b indexRef) bool {
+	if d := a.created.Compare(b.created); d != 0 {
+		return d > 0
+	}
+	return a.id > b.id
+}
@@ -1,12 +1,33 @@
 // Package cache — интерфейсы для работы с кэшем.
 package cache
 
-import "github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/models"
+import (
+	"context"
+	"time"
+
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/models"
+)
 
 // OrderCache описывает, что нам нужно от кэша заказов.
 type OrderCache interface {
 	Get(id string) (models.Order, bool)
+	GetByTrack(track string) (models.Order, bool)
+	GetByTransaction(tx string) (models.Order, bool)
 	Set(o models.Order)
+	SetIfAbsent(o models.Order) bool
 	Load(os []models.Order)
 	Size() int
 }
+
+// ManagedCache — кэш заказов вместе со статистикой, снимками и фоновой очисткой.
+// Его реализуют Cache и Sharded, реализация выбирается при старте сервиса.
+type ManagedCache interface {
+	OrderCache
+	Bytes() int64
+	Stats() Stats
+	Close()
+
+	SaveSnapshot(path string) (SnapshotInfo, error)
+	LoadSnapshot(path string) (SnapshotInfo, error)
+	Reconcile(ctx context.Context, src ChangeSource, since time.Time) (int, error)
+}
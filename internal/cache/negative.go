@@ -0,0 +1,77 @@
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+// NegativeCache помнит ID, которых нет в БД, чтобы повторные запросы
+// за несуществующим заказом не доходили до базы. Записи живут недолго,
+// чтобы заказ, пришедший позже из Kafka, быстро стал доступен.
+type NegativeCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	maxSize int
+	items   map[string]time.Time // id -> когда забыть
+	now     func() time.Time
+}
+
+// NewNegative создаёт негативный кэш с временем жизни записи ttl и лимитом maxSize.
+func NewNegative(ttl time.Duration, maxSize int) *NegativeCache {
+	if maxSize <= 0 {
+		maxSize = defaultMaxSize
+	}
+	return &NegativeCache{
+		ttl:     ttl,
+		maxSize: maxSize,
+		items:   make(map[string]time.Time),
+		now:     time.Now,
+	}
+}
+
+// Add запоминает, что id в БД нет.
+func (n *NegativeCache) Add(id string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	now := n.now()
+	if len(n.items) >= n.maxSize {
+		for k, until := range n.items {
+			if !now.Before(until) {
+				delete(n.items, k)
+			}
+		}
+	}
+	// если всё ещё полно — выкидываем любую запись, точность тут не важна
+	for k := range n.items {
+		if len(n.items) < n.maxSize {
+			break
+		}
+		delete(n.items, k)
+	}
+
+	n.items[id] = now.Add(n.ttl)
+}
+
+// Has сообщает, известно ли, что id в БД нет.
+func (n *NegativeCache) Has(id string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	until, ok := n.items[id]
+	if !ok {
+		return false
+	}
+	if !n.now().Before(until) {
+		delete(n.items, id)
+		return false
+	}
+	return true
+}
+
+// Remove забывает id, например, когда заказ появился в БД.
+func (n *NegativeCache) Remove(id string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.items, id)
+}
@@ -0,0 +1,234 @@
+package cache
+
+import (
+	"context"
+	"hash/maphash"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/models"
+)
+
+// Sharded — кэш из нескольких независимых Cache, шард выбирается по хэшу order_uid.
+// У каждого шарда свой мьютекс, свой список и своё вытеснение, поэтому запись
+// консьюмера блокирует только читателей того же шарда.
+//
+// Лимиты (размер и WithMaxBytes) делятся между шардами поровну, так что
+// при неравномерном хэшировании вытеснение может начаться чуть раньше,
+// чем кэш заполнится целиком.
+//
+// Вторичные индексы (track_number и payment.transaction) общие для всех шардов
+// и лежат под своим RWMutex: поиск по ним находит order_uid в индексе и читает
+// только шард этого заказа. Если у нескольких заказов одинаковый ключ,
+// как и в Cache возвращается самый свежий по DateCreated.
+type Sharded struct {
+	shards []*Cache
+	seed   maphash.Seed
+	idx    *secondaryIndex
+}
+
+// NewSharded создаёт кэш из n шардов с общим лимитом limit.
+// Опции применяются к каждому шарду.
+func NewSharded(n, limit int, opts ...Option) *Sharded {
+	n = max(n, 1)
+	if limit <= 0 {
+		limit = defaultMaxSize
+	}
+
+	s := &Sharded{shards: make([]*Cache, n), seed: maphash.MakeSeed(), idx: newSecondaryIndex(limit)}
+	for i := range s.shards {
+		c := NewWithLimit((limit+n-1)/n, opts...)
+		c.idx = s.idx
+		if c.maxBytes > 0 {
+			c.maxBytes = (c.maxBytes + int64(n) - 1) / int64(n)
+		}
+		s.shards[i] = c
+	}
+	return s
+}
+
+// shard возвращает шард для order_uid.
+func (s *Sharded) shard(id string) *Cache {
+	return s.shards[maphash.String(s.seed, id)%uint64(len(s.shards))]
+}
+
+// Close останавливает фоновую очистку во всех шардах.
+func (s *Sharded) Close() {
+	for _, c := range s.shards {
+		c.Close()
+	}
+}
+
+// Get возвращает заказ по ID из кэша.
+func (s *Sharded) Get(id string) (models.Order, bool) {
+	c := s.shard(id)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.get(id)
+}
+
+// GetByTrack возвращает заказ по track_number.
+func (s *Sharded) GetByTrack(track string) (models.Order, bool) {
+	return s.getBy(track, s.idx.track, func(o models.Order) string { return o.TrackNumber })
+}
+
+// GetByTransaction возвращает заказ по payment.transaction.
+func (s *Sharded) GetByTransaction(tx string) (models.Order, bool) {
+	return s.getBy(tx, s.idx.tx, func(o models.Order) string { return o.Payment.Transaction })
+}
+
+// getBy находит order_uid в общем индексе и читает заказ из его шарда.
+// Индекс и шард блокируются по очереди, поэтому между ними заказ могли удалить
+// или сменить ему ключ (field): тогда это промах. Промах без ключа в индексе
+// засчитывается шарду ключа.
+func (s *Sharded) getBy(key string, find func(string) (string, bool), field func(models.Order) string) (models.Order, bool) {
+	id, ok := find(key)
+	if !ok {
+		c := s.shard(key)
+		c.mu.Lock()
+		c.misses++
+		c.mu.Unlock()
+		return models.Order{}, false
+	}
+
+	c := s.shard(id)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.lookup(id)
+	if !ok || field(e.order) != key {
+		c.misses++
+		return models.Order{}, false
+	}
+	c.hits++
+	return e.order, true
+}
+
+// Set добавляет или обновляет заказ в его шарде.
+func (s *Sharded) Set(o models.Order) {
+	s.shard(o.OrderUID).Set(o)
+}
+
+// SetIfAbsent кладёт заказ, только если его нет в его шарде, см. Cache.SetIfAbsent.
+func (s *Sharded) SetIfAbsent(o models.Order) bool {
+	return s.shard(o.OrderUID).SetIfAbsent(o)
+}
+
+// Load загружает список заказов в кэш, заменяя содержимое всех шардов.
+// Если в шард попало больше заказов, чем его лимит, остаются последние из списка.
+func (s *Sharded) Load(os []models.Order) {
+	s.load(os, false)
+}
+
+// load раскладывает заказы по шардам с сохранением порядка и загружает каждый шард.
+// Индекс общий, поэтому на время загрузки блокируются все шарды: иначе Set в ещё
+// не загруженный шард оставил бы в индексе ссылку на заказ, который загрузка выкинет.
+func (s *Sharded) load(os []models.Order, fromSnapshot bool) {
+	parts := make([][]models.Order, len(s.shards))
+	for _, o := range os {
+		i := maphash.String(s.seed, o.OrderUID) % uint64(len(s.shards))
+		parts[i] = append(parts[i], o)
+	}
+
+	for _, c := range s.shards {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+	}
+	s.idx.reset(len(os))
+	for i, c := range s.shards {
+		c.fill(parts[i], fromSnapshot)
+	}
+}
+
+// Size возвращает количество записей во всех шардах.
+func (s *Sharded) Size() int {
+	n := 0
+	for _, c := range s.shards {
+		n += c.Size()
+	}
+	return n
+}
+
+// Bytes возвращает примерный объём заказов во всех шардах (см. OrderSize).
+func (s *Sharded) Bytes() int64 {
+	var n int64
+	for _, c := range s.shards {
+		n += c.Bytes()
+	}
+	return n
+}
+
+// Stats возвращает сумму счётчиков всех шардов.
+// Шарды опрашиваются по очереди, поэтому под нагрузкой это не точный срез.
+func (s *Sharded) Stats() Stats {
+	var st Stats
+	for _, c := range s.shards {
+		cs := c.Stats()
+		st.Hits += cs.Hits
+		st.Misses += cs.Misses
+		st.Evictions += cs.Evictions
+		st.Expirations += cs.Expirations
+		st.Size += cs.Size
+		st.Capacity += cs.Capacity
+		st.Bytes += cs.Bytes
+		st.MaxBytes += cs.MaxBytes
+	}
+	return st
+}
+
+// WriteSnapshot записывает непротухшие заказы всех шардов в w.
+// Формат тот же, что у Cache: снимок можно загрузить в кэш с другим числом шардов.
+// Заказы упорядочены по времени последней записи, порядок LRU внутри шарда не сохраняется.
+// Общего счётчика записей у шардов нет, чтобы Set в разных шардах не делили одну кэш-линию.
+func (s *Sharded) WriteSnapshot(w io.Writer) (SnapshotInfo, error) {
+	type stamped struct {
+		order   models.Order
+		written time.Time
+	}
+	var all []stamped
+	for _, c := range s.shards {
+		c.mu.Lock()
+		for el := c.ll.Back(); el != nil; el = el.Prev() {
+			if e := el.Value.(*entry); !c.expired(e) {
+				all = append(all, stamped{e.order, e.written})
+			}
+		}
+		c.mu.Unlock()
+	}
+	slices.SortStableFunc(all, func(a, b stamped) int { return a.written.Compare(b.written) })
+
+	data := snapshotData{TakenAt: s.shards[0].now(), Orders: make([]models.Order, len(all))}
+	for i, st := range all {
+		data.Orders[i] = st.order
+	}
+	return writeSnapshot(w, data)
+}
+
+// ReadSnapshot заменяет содержимое кэша заказами из снимка, см. Cache.ReadSnapshot.
+func (s *Sharded) ReadSnapshot(r io.Reader) (SnapshotInfo, error) {
+	data, err := readSnapshot(r)
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	s.load(data.Orders, true)
+	return SnapshotInfo{TakenAt: data.TakenAt, Orders: len(data.Orders)}, nil
+}
+
+// SaveSnapshot записывает снимок в файл path, см. Cache.SaveSnapshot.
+func (s *Sharded) SaveSnapshot(path string) (SnapshotInfo, error) {
+	return saveSnapshot(path, s.WriteSnapshot)
+}
+
+// LoadSnapshot загружает снимок из файла path, см. Cache.LoadSnapshot.
+func (s *Sharded) LoadSnapshot(path string) (SnapshotInfo, error) {
+	return loadSnapshot(path, s.ReadSnapshot)
+}
+
+// Reconcile догоняет загруженный снимок изменениями из src, см. Cache.Reconcile.
+func (s *Sharded) Reconcile(ctx context.Context, src ChangeSource, since time.Time) (int, error) {
+	return reconcile(ctx, src, since, func(o models.Order) bool {
+		return s.shard(o.OrderUID).refresh(o)
+	})
+}
@@ -0,0 +1,46 @@
+package cache
+
+import (
+	"container/list"
+	"unsafe"
+
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/models"
+)
+
+// Оценка занимаемой памяти. Точный размер в Go не узнать, поэтому считаются
+// сами структуры, байты строк и накладные расходы кэша на запись:
+// элемент списка, entry и записи в map (items и оба вторичных индекса).
+// Ключи map ссылаются на те же байты, что и строки заказа, отдельно не считаются.
+const (
+	orderSize = int(unsafe.Sizeof(models.Order{}))
+	itemSize  = int(unsafe.Sizeof(models.Item{}))
+
+	// mapEntrySize — ключ-строка и значение в map с запасом на бакеты
+	mapEntrySize = 48
+
+	entryOverhead = int(unsafe.Sizeof(list.Element{})) +
+		int(unsafe.Sizeof(entry{})) - orderSize +
+		3*mapEntrySize
+)
+
+// OrderSize возвращает примерный размер заказа в кэше в байтах.
+// Растёт с числом позиций и длиной строк, этого достаточно, чтобы
+// заказ на 500 позиций весил в кэше соответственно больше заказа на одну.
+func OrderSize(o models.Order) int {
+	n := orderSize + entryOverhead +
+		len(o.OrderUID) + len(o.TrackNumber) + len(o.Entry) + len(o.Locale) +
+		len(o.InternalSignature) + len(o.CustomerID) + len(o.DeliveryService) +
+		len(o.ShardKey) + len(o.OofShard)
+
+	d := o.Delivery
+	n += len(d.Name) + len(d.Phone) + len(d.Zip) + len(d.City) + len(d.Address) + len(d.Region) + len(d.Email)
+
+	p := o.Payment
+	n += len(p.Transaction) + len(p.RequestID) + len(p.Currency) + len(p.Provider) + len(p.Bank)
+
+	n += cap(o.Items) * itemSize
+	for _, it := range o.Items {
+		n += len(it.TrackNumber) + len(it.Rid) + len(it.Name) + len(it.Size) + len(it.Brand)
+	}
+	return n
+}
@@ -0,0 +1,227 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/models"
+)
+
+// Формат снимка: заголовок, затем gob-кодированный snapshotData.
+//
+//	magic   [8]byte  "WBOCACHE"
+//	version uint16   snapshotVersion
+//	length  uint64   длина данных в байтах
+//	crc     uint32   CRC-32C данных
+//	data    [length]byte
+//
+// Контрольная сумма проверяется до разбора, поэтому недописанный
+// или повреждённый файл не попадёт в кэш даже частично.
+const (
+	snapshotMagic   = "WBOCACHE"
+	snapshotVersion = 1
+	snapshotHeader  = 8 + 2 + 8 + 4
+
+	// maxSnapshotData ограничивает длину данных из заголовка, чтобы битый файл
+	// не заставил выделить гигабайты под буфер
+	maxSnapshotData = 4 << 30
+)
+
+// ErrBadSnapshot — файл снимка повреждён или записан в другом формате.
+var ErrBadSnapshot = errors.New("bad cache snapshot")
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// SnapshotInfo описывает записанный или загруженный снимок.
+type SnapshotInfo struct {
+	TakenAt time.Time // момент снимка по часам сервиса
+	Orders  int
+}
+
+// snapshotData — то, что лежит в файле после заголовка.
+// Заказы идут от самых старых к самым свежим.
+type snapshotData struct {
+	TakenAt time.Time
+	Orders  []models.Order
+}
+
+// WriteSnapshot записывает непротухшие заказы кэша в w.
+// Заказы копируются под блокировкой, а кодируются уже без неё.
+func (c *Cache) WriteSnapshot(w io.Writer) (SnapshotInfo, error) {
+	c.mu.Lock()
+	data := snapshotData{TakenAt: c.now(), Orders: make([]models.Order, 0, c.ll.Len())}
+	for el := c.ll.Back(); el != nil; el = el.Prev() {
+		if e := el.Value.(*entry); !c.expired(e) {
+			data.Orders = append(data.Orders, e.order)
+		}
+	}
+	c.mu.Unlock()
+
+	return writeSnapshot(w, data)
+}
+
+// ReadSnapshot заменяет содержимое кэша заказами из снимка, как Load.
+// Загруженные записи помечаются как взятые из снимка, см. Reconcile.
+// Если снимок не читается, кэш не меняется и возвращается ошибка с ErrBadSnapshot.
+func (c *Cache) ReadSnapshot(r io.Reader) (SnapshotInfo, error) {
+	data, err := readSnapshot(r)
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+
+	c.mu.Lock()
+	c.load(data.Orders, true)
+	c.mu.Unlock()
+	return SnapshotInfo{TakenAt: data.TakenAt, Orders: len(data.Orders)}, nil
+}
+
+// SaveSnapshot записывает снимок в файл path. Сначала пишется временный файл
+// рядом, потом он переименовывается: при сбое посреди записи старый снимок остаётся целым.
+func (c *Cache) SaveSnapshot(path string) (SnapshotInfo, error) {
+	return saveSnapshot(path, c.WriteSnapshot)
+}
+
+// LoadSnapshot загружает снимок из файла path. Если файла нет,
+// возвращается ошибка, для которой errors.Is(err, fs.ErrNotExist).
+func (c *Cache) LoadSnapshot(path string) (SnapshotInfo, error) {
+	return loadSnapshot(path, c.ReadSnapshot)
+}
+
+// writeSnapshot кодирует data и пишет её в w с заголовком.
+func writeSnapshot(w io.Writer, data snapshotData) (SnapshotInfo, error) {
+	var body bytes.Buffer
+	if err := gob.NewEncoder(&body).Encode(&data); err != nil {
+		return SnapshotInfo{}, fmt.Errorf("encode cache snapshot: %w", err)
+	}
+
+	var hdr [snapshotHeader]byte
+	copy(hdr[:8], snapshotMagic)
+	binary.BigEndian.PutUint16(hdr[8:], snapshotVersion)
+	binary.BigEndian.PutUint64(hdr[10:], uint64(body.Len()))
+	binary.BigEndian.PutUint32(hdr[18:], crc32.Checksum(body.Bytes(), crcTable))
+	if _, err := w.Write(hdr[:]); err != nil {
+		return SnapshotInfo{}, err
+	}
+	if _, err := body.WriteTo(w); err != nil {
+		return SnapshotInfo{}, err
+	}
+	return SnapshotInfo{TakenAt: data.TakenAt, Orders: len(data.Orders)}, nil
+}
+
+// readSnapshot читает и проверяет снимок целиком, прежде чем отдать заказы.
+func readSnapshot(r io.Reader) (snapshotData, error) {
+	var data snapshotData
+	var hdr [snapshotHeader]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return data, fmt.Errorf("%w: header: %v", ErrBadSnapshot, err)
+	}
+	if string(hdr[:8]) != snapshotMagic {
+		return data, fmt.Errorf("%w: not a snapshot file", ErrBadSnapshot)
+	}
+	if v := binary.BigEndian.Uint16(hdr[8:]); v != snapshotVersion {
+		return data, fmt.Errorf("%w: unsupported version %d", ErrBadSnapshot, v)
+	}
+	size := binary.BigEndian.Uint64(hdr[10:])
+	if size > maxSnapshotData {
+		return data, fmt.Errorf("%w: data length %d", ErrBadSnapshot, size)
+	}
+
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return data, fmt.Errorf("%w: data: %v", ErrBadSnapshot, err)
+	}
+	if crc32.Checksum(body, crcTable) != binary.BigEndian.Uint32(hdr[18:]) {
+		return data, fmt.Errorf("%w: checksum mismatch", ErrBadSnapshot)
+	}
+
+	if err := gob.NewDecoder(bytes.NewReader(body)).Decode(&data); err != nil {
+		return snapshotData{}, fmt.Errorf("%w: decode: %v", ErrBadSnapshot, err)
+	}
+	return data, nil
+}
+
+// saveSnapshot пишет снимок через write во временный файл и переименовывает его в path.
+func saveSnapshot(path string, write func(io.Writer) (SnapshotInfo, error)) (SnapshotInfo, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	defer os.Remove(tmp.Name()) // после успешного Rename файла уже нет
+
+	bw := bufio.NewWriter(tmp)
+	info, err := write(bw)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return SnapshotInfo{}, fmt.Errorf("write cache snapshot: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return SnapshotInfo{}, fmt.Errorf("write cache snapshot: %w", err)
+	}
+	return info, nil
+}
+
+// loadSnapshot открывает path и передаёт его в read.
+func loadSnapshot(path string, read func(io.Reader) (SnapshotInfo, error)) (SnapshotInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	defer f.Close()
+	return read(bufio.NewReader(f))
+}
+
+// ChangeSource отдаёт заказы, изменённые начиная с момента since,
+// от более ранних изменений к более поздним.
+type ChangeSource interface {
+	StreamOrdersUpdatedSince(ctx context.Context, since time.Time, fn func(models.Order) error) error
+}
+
+// Reconcile догоняет загруженный снимок: берёт из src заказы, изменённые после since,
+// и кладёт их в кэш. Записи, которые после загрузки снимка уже перезаписал Set
+// (например, консьюмер), не трогаются: в них версия новее, чем могла прочитаться из БД.
+// Возвращает число обновлённых записей.
+func (c *Cache) Reconcile(ctx context.Context, src ChangeSource, since time.Time) (int, error) {
+	return reconcile(ctx, src, since, c.refresh)
+}
+
+// reconcile прогоняет изменения из src через refresh и считает обновлённые записи.
+func reconcile(ctx context.Context, src ChangeSource, since time.Time, refresh func(models.Order) bool) (int, error) {
+	n := 0
+	err := src.StreamOrdersUpdatedSince(ctx, since, func(o models.Order) error {
+		if refresh(o) {
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// refresh кладёт заказ, если его нет в кэше или в кэше лежит копия из снимка.
+func (c *Cache) refresh(o models.Order) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[o.OrderUID]; ok && !el.Value.(*entry).snapshot {
+		return false
+	}
+	c.set(o)
+	return true
+}
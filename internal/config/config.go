@@ -0,0 +1,274 @@
+// Package config собирает настройки сервиса в одном месте.
+//
+// Источники применяются по порядку, каждый следующий перекрывает предыдущий:
+// значения по умолчанию, YAML-файл (-config или CONFIG_FILE), переменные
+// окружения, флаги командной строки. После загрузки конфиг целиком проверяется
+// в Validate, чтобы сервис не стартовал с заведомо неверными настройками.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config — все настройки сервиса.
+type Config struct {
+	HTTP     HTTP     `yaml:"http"`
+	Postgres Postgres `yaml:"postgres"`
+	Cache    Cache    `yaml:"cache"`
+	Kafka    Kafka    `yaml:"kafka"`
+	Health   Health   `yaml:"health"`
+	Log      Log      `yaml:"log"`
+	Tracing  Tracing  `yaml:"tracing"`
+
+	Validation Validation `yaml:"validation"`
+	Migrations Migrations `yaml:"migrations"`
+}
+
+// HTTP — настройки HTTP-сервера.
+type HTTP struct {
+	Addr              string        `yaml:"addr"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+	ShutdownTimeout   time.Duration `yaml:"shutdown_timeout"`
+	// WebDir — папка со статикой UI вместо встроенной в бинарник (для разработки).
+	WebDir string `yaml:"web_dir"`
+}
+
+// Postgres — подключение и размер пула.
+type Postgres struct {
+	DSN               string        `yaml:"dsn"`
+	MaxConns          int32         `yaml:"max_conns"` // 0 — по умолчанию pgxpool
+	MinConns          int32         `yaml:"min_conns"`
+	MaxConnLifetime   time.Duration `yaml:"max_conn_lifetime"`
+	MaxConnIdleTime   time.Duration `yaml:"max_conn_idle_time"`
+	HealthCheckPeriod time.Duration `yaml:"health_check_period"`
+	ConnectTimeout    time.Duration `yaml:"connect_timeout"`
+}
+
+// Cache — кэш заказов и его прогрев.
+type Cache struct {
+	Size        int           `yaml:"size"`
+	Policy      string        `yaml:"policy"`
+	TTL         time.Duration `yaml:"ttl"`
+	NegativeTTL time.Duration `yaml:"negative_ttl"`
+	WarmupSize  int           `yaml:"warmup_size"`
+
+	// MaxBytes — бюджет памяти кэша в байтах по примерной оценке размера заказа.
+	// 0 — ограничен только Size.
+	MaxBytes int64 `yaml:"max_bytes"`
+	// Shards — на сколько независимых частей со своими блокировками делить кэш.
+	// 1 — обычный кэш с одной блокировкой.
+	Shards int `yaml:"shards"`
+
+	// SnapshotPath — файл снимка кэша. Пустой — снимки выключены,
+	// кэш прогревается из БД.
+	SnapshotPath string `yaml:"snapshot_path"`
+	// SnapshotInterval — как часто записывать снимок, кроме записи при остановке.
+	// 0 — только при остановке.
+	SnapshotInterval time.Duration `yaml:"snapshot_interval"`
+}
+
+// Kafka — консьюмер и параметры kafka.Reader.
+type Kafka struct {
+	Brokers  []string `yaml:"brokers"`
+	Topic    string   `yaml:"topic"`
+	GroupID  string   `yaml:"group_id"`
+	DLQTopic string   `yaml:"dlq_topic"`
+
+	MaxRetries      int           `yaml:"max_retries"`
+	RetryBackoff    time.Duration `yaml:"retry_backoff"`
+	RetryMaxBackoff time.Duration `yaml:"retry_max_backoff"`
+	GiveUp          string        `yaml:"give_up"`
+
+	BatchSize    int           `yaml:"batch_size"`
+	BatchTimeout time.Duration `yaml:"batch_timeout"`
+
+	Workers      int           `yaml:"workers"`
+	DispatchBy   string        `yaml:"dispatch_by"`
+	StallTimeout time.Duration `yaml:"stall_timeout"`
+
+	// Freshness — по чему сравнивать копию заказа с сохранённой:
+	// kafka_time, payment_dt, header или none.
+	Freshness       string `yaml:"freshness"`
+	FreshnessHeader string `yaml:"freshness_header"`
+
+	// OrderWait — сколько событие статуса ждёт свой заказ, прежде чем уйти в DLQ.
+	OrderWait time.Duration `yaml:"order_wait"`
+
+	// параметры kafka.Reader
+	StartOffset       string        `yaml:"start_offset"` // first или last
+	MinBytes          int           `yaml:"min_bytes"`
+	MaxBytes          int           `yaml:"max_bytes"`
+	MaxWait           time.Duration `yaml:"max_wait"`
+	QueueCapacity     int           `yaml:"queue_capacity"`
+	SessionTimeout    time.Duration `yaml:"session_timeout"`
+	HeartbeatInterval time.Duration `yaml:"heartbeat_interval"`
+	RebalanceTimeout  time.Duration `yaml:"rebalance_timeout"`
+}
+
+// Health — таймауты проверок готовности. 0 — общий Timeout.
+type Health struct {
+	Timeout      time.Duration `yaml:"timeout"`
+	DBTimeout    time.Duration `yaml:"db_timeout"`
+	KafkaTimeout time.Duration `yaml:"kafka_timeout"`
+}
+
+// Log — уровень и формат логов.
+type Log struct {
+	Level  string `yaml:"level"`  // debug, info, warn, error
+	Format string `yaml:"format"` // json или text
+}
+
+// Tracing — экспорт спанов OpenTelemetry.
+type Tracing struct {
+	Exporter    string  `yaml:"exporter"` // none, stdout или file
+	File        string  `yaml:"file"`
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+// Validation — бизнес-правила заказа (см. пакет validation). По умолчанию
+// все правила включены и отклоняют заказ.
+type Validation struct {
+	Disable []string `yaml:"disable"` // выключенные правила
+	Warn    []string `yaml:"warn"`    // правила, которые только пишут предупреждение
+}
+
+// Migrations — миграции схемы при старте сервиса.
+type Migrations struct {
+	// Auto — накатывать неприменённые миграции перед запуском.
+	Auto bool `yaml:"auto"`
+	// Dir — папка с миграциями вместо встроенных в бинарник (для разработки).
+	Dir string `yaml:"dir"`
+}
+
+// Default возвращает настройки по умолчанию. DSN, брокеры, топик
+// и группа по умолчанию пустые и должны прийти из файла, env или флагов.
+func Default() Config {
+	return Config{
+		HTTP: HTTP{
+			Addr:              ":8081",
+			ReadHeaderTimeout: 5 * time.Second,
+			ReadTimeout:       30 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+			ShutdownTimeout:   5 * time.Second,
+		},
+		Postgres: Postgres{
+			ConnectTimeout: 10 * time.Second,
+		},
+		Cache: Cache{
+			Size:             1000,
+			Policy:           "lru",
+			NegativeTTL:      5 * time.Second,
+			WarmupSize:       200,
+			Shards:           1,
+			SnapshotInterval: 5 * time.Minute,
+		},
+		Kafka: Kafka{
+			RetryBackoff:    200 * time.Millisecond,
+			RetryMaxBackoff: 30 * time.Second,
+			GiveUp:          "stop",
+			BatchTimeout:    100 * time.Millisecond,
+			Workers:         1,
+			DispatchBy:      "partition",
+			StallTimeout:    2 * time.Minute,
+			Freshness:       "kafka_time",
+			FreshnessHeader: "x-updated-at",
+			OrderWait:       10 * time.Second,
+
+			StartOffset:       "last",
+			MinBytes:          1,
+			MaxBytes:          10 << 20,
+			MaxWait:           10 * time.Second,
+			QueueCapacity:     100,
+			SessionTimeout:    30 * time.Second,
+			HeartbeatInterval: 3 * time.Second,
+			RebalanceTimeout:  30 * time.Second,
+		},
+		Health: Health{
+			Timeout: 2 * time.Second,
+		},
+		Log: Log{
+			Level:  "info",
+			Format: "json",
+		},
+		Tracing: Tracing{
+			Exporter:    "none",
+			SampleRatio: 1,
+		},
+	}
+}
+
+// Load собирает конфиг из всех источников и проверяет его.
+// args — аргументы командной строки без имени программы.
+func Load(args []string) (Config, error) {
+	fs := flag.NewFlagSet("wb-order-service", flag.ContinueOnError)
+	path := fs.String("config", os.Getenv("CONFIG_FILE"), "путь к YAML-файлу конфигурации")
+
+	cfg := Default()
+	opts := options(&cfg)
+
+	// флаги разбираются сразу, чтобы узнать путь к файлу, а применяются последними
+	var set []func() error
+	for _, o := range opts {
+		if o.flag == "" {
+			continue
+		}
+		fs.Func(o.flag, o.usage+" (env "+o.env+")", func(v string) error {
+			set = append(set, func() error { return o.apply("flag -"+o.flag, v) })
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	if *path != "" {
+		if err := loadFile(*path, &cfg); err != nil {
+			return Config{}, err
+		}
+	}
+
+	var errs []error
+	for _, o := range opts {
+		if v, ok := os.LookupEnv(o.env); ok {
+			errs = append(errs, o.apply("env "+o.env, v))
+		}
+	}
+	for _, apply := range set {
+		errs = append(errs, apply())
+	}
+	if err := errors.Join(errs...); err != nil {
+		return Config{}, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// loadFile читает YAML-файл поверх значений по умолчанию.
+// Неизвестные ключи — ошибка, чтобы опечатка не потерялась молча.
+func loadFile(path string, cfg *Config) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// requiredEnv — минимум, без которого конфиг не проходит проверку.
+func requiredEnv(t *testing.T) {
+	t.Helper()
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("POSTGRES_DSN", "postgres://localhost/test")
+	t.Setenv("KAFKA_BROKERS", "localhost:9092")
+	t.Setenv("KAFKA_TOPIC", "orders")
+	t.Setenv("KAFKA_GROUP", "test")
+}
+
+func writeFile(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	requiredEnv(t)
+
+	cfg, err := Load(nil)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.HTTP.Addr != ":8081" || cfg.Cache.Size != 1000 || cfg.Cache.WarmupSize != 200 {
+		t.Fatalf("unexpected defaults: %+v", cfg)
+	}
+	if got := cfg.Kafka.Brokers; len(got) != 1 || got[0] != "localhost:9092" {
+		t.Fatalf("brokers = %v", got)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	requiredEnv(t)
+
+	path := writeFile(t, `
+http:
+  addr: ":9000"
+  write_timeout: 15s
+cache:
+  size: 500
+  warmup_size: 100
+kafka:
+  workers: 2
+  start_offset: first
+`)
+	t.Setenv("CACHE_SIZE", "700")
+	t.Setenv("KAFKA_WORKERS", "3")
+
+	cfg, err := Load([]string{"-config", path, "-kafka-workers", "4"})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	// файл перекрывает умолчания
+	if cfg.HTTP.Addr != ":9000" || cfg.HTTP.WriteTimeout != 15*time.Second || cfg.Kafka.StartOffset != "first" {
+		t.Fatalf("file values not applied: %+v", cfg.HTTP)
+	}
+	// env перекрывает файл
+	if cfg.Cache.Size != 700 {
+		t.Fatalf("cache.size = %d, want 700 from env", cfg.Cache.Size)
+	}
+	// флаг перекрывает env
+	if cfg.Kafka.Workers != 4 {
+		t.Fatalf("kafka.workers = %d, want 4 from flag", cfg.Kafka.Workers)
+	}
+	// что не задано в env и флагах, остаётся из файла
+	if cfg.Cache.WarmupSize != 100 {
+		t.Fatalf("cache.warmup_size = %d, want 100 from file", cfg.Cache.WarmupSize)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		file string
+		args []string
+		want []string
+	}{
+		{
+			name: "bad env value",
+			env:  map[string]string{"KAFKA_MAX_WAIT": "ten"},
+			want: []string{"env KAFKA_MAX_WAIT", `invalid duration "ten"`},
+		},
+		{
+			name: "bad bool value",
+			env:  map[string]string{"MIGRATIONS_AUTO": "yes please"},
+			want: []string{"env MIGRATIONS_AUTO", `invalid boolean "yes please"`},
+		},
+		{
+			name: "bad flag value",
+			args: []string{"-cache-size", "many"},
+			want: []string{"flag -cache-size", `invalid integer "many"`},
+		},
+		{
+			name: "unknown file key",
+			file: "cache:\n  sise: 10\n",
+			want: []string{"field sise not found"},
+		},
+		{
+			name: "missing file",
+			args: []string{"-config", "/nonexistent/config.yaml"},
+			want: []string{"config file"},
+		},
+		{
+			name: "all validation errors at once",
+			env: map[string]string{
+				"POSTGRES_DSN":  "",
+				"CACHE_POLICY":  "random",
+				"KAFKA_WORKERS": "0",
+			},
+			want: []string{
+				"config: postgres.dsn is required",
+				`config: cache.policy is "random", expected one of: lru, fifo`,
+				"config: kafka.workers must be positive",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requiredEnv(t)
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"-config", writeFile(t, tt.file)}, args...)
+			}
+
+			_, err := Load(args)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(err.Error(), w) {
+					t.Errorf("error %q does not contain %q", err, w)
+				}
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := func() Config {
+		cfg := Default()
+		cfg.Postgres.DSN = "postgres://localhost/test"
+		cfg.Kafka.Brokers = []string{"localhost:9092"}
+		cfg.Kafka.Topic = "orders"
+		cfg.Kafka.GroupID = "test"
+		return cfg
+	}
+
+	if err := valid().Validate(); err != nil {
+		t.Fatalf("valid config: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		want   string
+	}{
+		{"warmup above size", func(c *Config) { c.Cache.WarmupSize = 2000 }, "cache.warmup_size must not exceed cache.size (1000)"},
+		{"min above max conns", func(c *Config) { c.Postgres.MaxConns, c.Postgres.MinConns = 2, 5 }, "postgres.min_conns must not exceed max_conns (2)"},
+		{"dlq equals topic", func(c *Config) { c.Kafka.DLQTopic = "orders" }, "kafka.dlq_topic must differ from kafka.topic"},
+		{"backoff order", func(c *Config) { c.Kafka.RetryMaxBackoff = time.Millisecond }, "kafka.retry_max_backoff must not be less than retry_backoff"},
+		{"heartbeat vs session", func(c *Config) { c.Kafka.HeartbeatInterval = time.Minute }, "kafka.heartbeat_interval must be less than session_timeout"},
+		{"negative ttl", func(c *Config) { c.Cache.TTL = -time.Second }, "cache.ttl must not be negative"},
+		{"zero shards", func(c *Config) { c.Cache.Shards = 0 }, "cache.shards must be positive"},
+		{"negative max bytes", func(c *Config) { c.Cache.MaxBytes = -1 }, "cache.max_bytes must not be negative"},
+		{"negative order wait", func(c *Config) { c.Kafka.OrderWait = -time.Second }, "kafka.order_wait must not be negative"},
+		{"negative snapshot interval", func(c *Config) { c.Cache.SnapshotInterval = -time.Second }, "cache.snapshot_interval must not be negative"},
+		{"unknown rule", func(c *Config) { c.Validation.Warn = []string{"amount", "total"} }, `validation.warn is "total"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid()
+			tt.modify(&cfg)
+			err := cfg.Validate()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Validate() = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// option связывает поле конфига с переменной окружения и флагом.
+// Флаг заведён не для всех полей, а для тех, что удобно менять при запуске.
+type option struct {
+	env   string
+	flag  string
+	usage string
+	set   func(string) error
+}
+
+// apply разбирает значение и пишет его в поле. source попадает в текст ошибки.
+func (o option) apply(source, v string) error {
+	if err := o.set(v); err != nil {
+		return fmt.Errorf("%s: %w", source, err)
+	}
+	return nil
+}
+
+// options перечисляет все настройки, которые можно задать через env и флаги.
+// Имена переменных окружения совпадают с теми, что уже используются в docker-compose.
+func options(c *Config) []option {
+	return []option{
+		{"HTTP_ADDR", "http-addr", "адрес HTTP-сервера", str(&c.HTTP.Addr)},
+		{"HTTP_READ_HEADER_TIMEOUT", "", "таймаут чтения заголовков", dur(&c.HTTP.ReadHeaderTimeout)},
+		{"HTTP_READ_TIMEOUT", "", "таймаут чтения запроса", dur(&c.HTTP.ReadTimeout)},
+		{"HTTP_WRITE_TIMEOUT", "", "таймаут записи ответа", dur(&c.HTTP.WriteTimeout)},
+		{"HTTP_IDLE_TIMEOUT", "", "таймаут keep-alive соединения", dur(&c.HTTP.IdleTimeout)},
+		{"HTTP_SHUTDOWN_TIMEOUT", "", "сколько ждать запросы при остановке", dur(&c.HTTP.ShutdownTimeout)},
+		{"WEB_DIR", "web-dir", "папка со статикой UI вместо встроенной", str(&c.HTTP.WebDir)},
+
+		{"POSTGRES_DSN", "postgres-dsn", "строка подключения к Postgres", str(&c.Postgres.DSN)},
+		{"POSTGRES_MAX_CONNS", "postgres-max-conns", "максимум соединений в пуле", int32Var(&c.Postgres.MaxConns)},
+		{"POSTGRES_MIN_CONNS", "", "минимум соединений в пуле", int32Var(&c.Postgres.MinConns)},
+		{"POSTGRES_MAX_CONN_LIFETIME", "", "время жизни соединения", dur(&c.Postgres.MaxConnLifetime)},
+		{"POSTGRES_MAX_CONN_IDLE_TIME", "", "время простоя соединения", dur(&c.Postgres.MaxConnIdleTime)},
+		{"POSTGRES_HEALTH_CHECK_PERIOD", "", "период проверки простаивающих соединений", dur(&c.Postgres.HealthCheckPeriod)},
+		{"POSTGRES_CONNECT_TIMEOUT", "", "таймаут подключения при старте", dur(&c.Postgres.ConnectTimeout)},
+
+		{"CACHE_SIZE", "cache-size", "максимум заказов в кэше", intVar(&c.Cache.Size)},
+		{"CACHE_POLICY", "cache-policy", "политика вытеснения: lru или fifo", str(&c.Cache.Policy)},
+		{"CACHE_TTL", "", "время жизни записи кэша, 0 — без ограничения", dur(&c.Cache.TTL)},
+		{"CACHE_NEGATIVE_TTL", "", "сколько помнить отсутствующие заказы", dur(&c.Cache.NegativeTTL)},
+		{"CACHE_MAX_BYTES", "cache-max-bytes", "бюджет памяти кэша в байтах, 0 — без ограничения", int64Var(&c.Cache.MaxBytes)},
+		{"CACHE_SHARDS", "cache-shards", "число шардов кэша, 1 — одна блокировка на весь кэш", intVar(&c.Cache.Shards)},
+		{"CACHE_WARMUP_SIZE", "cache-warmup-size", "сколько заказов загрузить в кэш при старте", intVar(&c.Cache.WarmupSize)},
+		{"CACHE_SNAPSHOT_PATH", "cache-snapshot", "файл снимка кэша, пусто — без снимков", str(&c.Cache.SnapshotPath)},
+		{"CACHE_SNAPSHOT_INTERVAL", "", "как часто писать снимок кэша, 0 — только при остановке", dur(&c.Cache.SnapshotInterval)},
+
+		{"KAFKA_BROKERS", "kafka-brokers", "брокеры через запятую", list(&c.Kafka.Brokers)},
+		{"KAFKA_TOPIC", "kafka-topic", "топик заказов", str(&c.Kafka.Topic)},
+		{"KAFKA_GROUP", "kafka-group", "группа консьюмеров", str(&c.Kafka.GroupID)},
+		{"KAFKA_DLQ_TOPIC", "kafka-dlq-topic", "топик отклонённых сообщений", str(&c.Kafka.DLQTopic)},
+		{"KAFKA_MAX_RETRIES", "", "повторов при временной ошибке, 0 — без ограничения", intVar(&c.Kafka.MaxRetries)},
+		{"KAFKA_RETRY_BACKOFF", "", "задержка перед первым повтором", dur(&c.Kafka.RetryBackoff)},
+		{"KAFKA_RETRY_MAX_BACKOFF", "", "максимальная задержка между повторами", dur(&c.Kafka.RetryMaxBackoff)},
+		{"KAFKA_GIVE_UP", "", "что делать после повторов: stop или dlq", str(&c.Kafka.GiveUp)},
+		{"KAFKA_BATCH_SIZE", "kafka-batch-size", "размер пачки, 0 или 1 — по одному", intVar(&c.Kafka.BatchSize)},
+		{"KAFKA_BATCH_TIMEOUT", "", "сколько добирать пачку", dur(&c.Kafka.BatchTimeout)},
+		{"KAFKA_WORKERS", "kafka-workers", "параллельных воркеров", intVar(&c.Kafka.Workers)},
+		{"KAFKA_DISPATCH_BY", "", "раскладка по воркерам: partition или key", str(&c.Kafka.DispatchBy)},
+		{"KAFKA_STALL_TIMEOUT", "", "сколько коммит может стоять до неготовности", dur(&c.Kafka.StallTimeout)},
+		{"KAFKA_FRESHNESS", "kafka-freshness", "свежесть копии заказа: kafka_time, payment_dt, header или none", str(&c.Kafka.Freshness)},
+		{"KAFKA_FRESHNESS_HEADER", "", "заголовок со временем изменения для freshness=header", str(&c.Kafka.FreshnessHeader)},
+		{"KAFKA_ORDER_WAIT", "", "сколько событие статуса ждёт свой заказ, 0 — не ждать", dur(&c.Kafka.OrderWait)},
+		{"KAFKA_START_OFFSET", "", "с чего читать новую группу: first или last", str(&c.Kafka.StartOffset)},
+		{"KAFKA_MIN_BYTES", "", "минимум байт в ответе fetch", intVar(&c.Kafka.MinBytes)},
+		{"KAFKA_MAX_BYTES", "", "максимум байт в ответе fetch", intVar(&c.Kafka.MaxBytes)},
+		{"KAFKA_MAX_WAIT", "", "сколько брокер ждёт MinBytes", dur(&c.Kafka.MaxWait)},
+		{"KAFKA_QUEUE_CAPACITY", "", "размер внутренней очереди reader", intVar(&c.Kafka.QueueCapacity)},
+		{"KAFKA_SESSION_TIMEOUT", "", "таймаут сессии в группе", dur(&c.Kafka.SessionTimeout)},
+		{"KAFKA_HEARTBEAT_INTERVAL", "", "интервал heartbeat в группе", dur(&c.Kafka.HeartbeatInterval)},
+		{"KAFKA_REBALANCE_TIMEOUT", "", "таймаут ребаланса группы", dur(&c.Kafka.RebalanceTimeout)},
+
+		{"HEALTH_CHECK_TIMEOUT", "", "таймаут проверок готовности", dur(&c.Health.Timeout)},
+		{"HEALTH_DB_TIMEOUT", "", "таймаут проверки Postgres", dur(&c.Health.DBTimeout)},
+		{"HEALTH_KAFKA_TIMEOUT", "", "таймаут проверки Kafka", dur(&c.Health.KafkaTimeout)},
+
+		{"LOG_LEVEL", "log-level", "уровень логов: debug, info, warn, error", str(&c.Log.Level)},
+		{"LOG_FORMAT", "log-format", "формат логов: json или text", str(&c.Log.Format)},
+
+		{"TRACING_EXPORTER", "tracing-exporter", "куда писать спаны: none, stdout или file", str(&c.Tracing.Exporter)},
+		{"TRACING_FILE", "tracing-file", "файл для спанов при exporter=file", str(&c.Tracing.File)},
+		{"TRACING_SAMPLE_RATIO", "", "доля записываемых трасс, 0..1", floatVar(&c.Tracing.SampleRatio)},
+
+		{"VALIDATION_DISABLE", "validation-disable", "выключенные бизнес-правила через запятую", list(&c.Validation.Disable)},
+		{"VALIDATION_WARN", "validation-warn", "бизнес-правила только с предупреждением, через запятую", list(&c.Validation.Warn)},
+
+		{"MIGRATIONS_AUTO", "", "накатывать миграции при старте: true или false", boolVar(&c.Migrations.Auto)},
+		{"MIGRATIONS_DIR", "migrations-dir", "папка с миграциями вместо встроенных", str(&c.Migrations.Dir)},
+	}
+}
+
+func str(p *string) func(string) error {
+	return func(v string) error {
+		*p = strings.TrimSpace(v)
+		return nil
+	}
+}
+
+func intVar(p *int) func(string) error {
+	return func(v string) error {
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", v)
+		}
+		*p = n
+		return nil
+	}
+}
+
+func int32Var(p *int32) func(string) error {
+	return func(v string) error {
+		n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", v)
+		}
+		*p = int32(n)
+		return nil
+	}
+}
+
+func int64Var(p *int64) func(string) error {
+	return func(v string) error {
+		n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", v)
+		}
+		*p = n
+		return nil
+	}
+}
+
+func boolVar(p *bool) func(string) error {
+	return func(v string) error {
+		b, err := strconv.ParseBool(strings.TrimSpace(v))
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q, expected true or false", v)
+		}
+		*p = b
+		return nil
+	}
+}
+
+func floatVar(p *float64) func(string) error {
+	return func(v string) error {
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", v)
+		}
+		*p = f
+		return nil
+	}
+}
+
+func dur(p *time.Duration) func(string) error {
+	return func(v string) error {
+		d, err := time.ParseDuration(strings.TrimSpace(v))
+		if err != nil {
+			return fmt.Errorf("invalid duration %q, expected e.g. 500ms or 30s", v)
+		}
+		*p = d
+		return nil
+	}
+}
+
+// list разбирает значения через запятую.
+func list(p *[]string) func(string) error {
+	return func(v string) error {
+		var out []string
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				out = append(out, s)
+			}
+		}
+		*p = out
+		return nil
+	}
+}
@@ -0,0 +1,174 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/validation"
+)
+
+// Validate проверяет конфиг целиком и возвращает все найденные ошибки разом,
+// каждая с именем поля в том виде, как оно записано в YAML.
+func (c Config) Validate() error {
+	var v validator
+
+	v.required("http.addr", c.HTTP.Addr)
+	v.positive("http.read_header_timeout", c.HTTP.ReadHeaderTimeout)
+	v.nonNegative("http.read_timeout", c.HTTP.ReadTimeout)
+	v.nonNegative("http.write_timeout", c.HTTP.WriteTimeout)
+	v.nonNegative("http.idle_timeout", c.HTTP.IdleTimeout)
+	v.positive("http.shutdown_timeout", c.HTTP.ShutdownTimeout)
+
+	v.required("postgres.dsn", c.Postgres.DSN)
+	if c.Postgres.MaxConns < 0 {
+		v.add("postgres.max_conns", "must not be negative")
+	}
+	if c.Postgres.MinConns < 0 {
+		v.add("postgres.min_conns", "must not be negative")
+	}
+	if c.Postgres.MaxConns > 0 && c.Postgres.MinConns > c.Postgres.MaxConns {
+		v.add("postgres.min_conns", fmt.Sprintf("must not exceed max_conns (%d)", c.Postgres.MaxConns))
+	}
+	v.nonNegative("postgres.max_conn_lifetime", c.Postgres.MaxConnLifetime)
+	v.nonNegative("postgres.max_conn_idle_time", c.Postgres.MaxConnIdleTime)
+	v.nonNegative("postgres.health_check_period", c.Postgres.HealthCheckPeriod)
+	v.positive("postgres.connect_timeout", c.Postgres.ConnectTimeout)
+
+	if c.Cache.Size <= 0 {
+		v.add("cache.size", "must be positive")
+	}
+	v.oneOf("cache.policy", c.Cache.Policy, "lru", "fifo")
+	v.nonNegative("cache.ttl", c.Cache.TTL)
+	v.nonNegative("cache.negative_ttl", c.Cache.NegativeTTL)
+	if c.Cache.Shards <= 0 {
+		v.add("cache.shards", "must be positive")
+	}
+	if c.Cache.MaxBytes < 0 {
+		v.add("cache.max_bytes", "must not be negative")
+	}
+	if c.Cache.WarmupSize < 0 {
+		v.add("cache.warmup_size", "must not be negative")
+	}
+	if c.Cache.WarmupSize > c.Cache.Size {
+		v.add("cache.warmup_size", fmt.Sprintf("must not exceed cache.size (%d)", c.Cache.Size))
+	}
+	v.nonNegative("cache.snapshot_interval", c.Cache.SnapshotInterval)
+
+	if len(c.Kafka.Brokers) == 0 {
+		v.add("kafka.brokers", "is required")
+	}
+	v.required("kafka.topic", c.Kafka.Topic)
+	v.required("kafka.group_id", c.Kafka.GroupID)
+	if c.Kafka.DLQTopic != "" && c.Kafka.DLQTopic == c.Kafka.Topic {
+		v.add("kafka.dlq_topic", "must differ from kafka.topic")
+	}
+	if c.Kafka.MaxRetries < 0 {
+		v.add("kafka.max_retries", "must not be negative")
+	}
+	v.positive("kafka.retry_backoff", c.Kafka.RetryBackoff)
+	v.positive("kafka.retry_max_backoff", c.Kafka.RetryMaxBackoff)
+	if c.Kafka.RetryMaxBackoff < c.Kafka.RetryBackoff {
+		v.add("kafka.retry_max_backoff", "must not be less than retry_backoff")
+	}
+	v.oneOf("kafka.give_up", c.Kafka.GiveUp, "stop", "dlq")
+	if c.Kafka.BatchSize < 0 {
+		v.add("kafka.batch_size", "must not be negative")
+	}
+	v.positive("kafka.batch_timeout", c.Kafka.BatchTimeout)
+	if c.Kafka.Workers <= 0 {
+		v.add("kafka.workers", "must be positive")
+	}
+	v.oneOf("kafka.dispatch_by", c.Kafka.DispatchBy, "partition", "key")
+	v.positive("kafka.stall_timeout", c.Kafka.StallTimeout)
+	v.oneOf("kafka.freshness", c.Kafka.Freshness, "kafka_time", "payment_dt", "header", "none")
+	if c.Kafka.Freshness == "header" {
+		v.required("kafka.freshness_header", c.Kafka.FreshnessHeader)
+	}
+	if c.Kafka.OrderWait < 0 {
+		v.add("kafka.order_wait", "must not be negative")
+	}
+	v.oneOf("kafka.start_offset", c.Kafka.StartOffset, "first", "last")
+	if c.Kafka.MinBytes <= 0 {
+		v.add("kafka.min_bytes", "must be positive")
+	}
+	if c.Kafka.MaxBytes < c.Kafka.MinBytes {
+		v.add("kafka.max_bytes", "must not be less than min_bytes")
+	}
+	v.positive("kafka.max_wait", c.Kafka.MaxWait)
+	if c.Kafka.QueueCapacity <= 0 {
+		v.add("kafka.queue_capacity", "must be positive")
+	}
+	v.positive("kafka.session_timeout", c.Kafka.SessionTimeout)
+	v.positive("kafka.heartbeat_interval", c.Kafka.HeartbeatInterval)
+	if c.Kafka.HeartbeatInterval >= c.Kafka.SessionTimeout {
+		v.add("kafka.heartbeat_interval", "must be less than session_timeout")
+	}
+	v.positive("kafka.rebalance_timeout", c.Kafka.RebalanceTimeout)
+
+	v.positive("health.timeout", c.Health.Timeout)
+	v.nonNegative("health.db_timeout", c.Health.DBTimeout)
+	v.nonNegative("health.kafka_timeout", c.Health.KafkaTimeout)
+
+	v.oneOf("log.level", c.Log.Level, "debug", "info", "warn", "error")
+	v.oneOf("log.format", c.Log.Format, "json", "text")
+
+	v.oneOf("tracing.exporter", c.Tracing.Exporter, "none", "stdout", "file")
+	if c.Tracing.Exporter == "file" {
+		v.required("tracing.file", c.Tracing.File)
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		v.add("tracing.sample_ratio", "must be between 0 and 1")
+	}
+
+	rules := validation.Names()
+	for _, name := range c.Validation.Disable {
+		v.oneOf("validation.disable", name, rules...)
+	}
+	for _, name := range c.Validation.Warn {
+		v.oneOf("validation.warn", name, rules...)
+	}
+
+	return v.err()
+}
+
+// validator копит ошибки, чтобы показать их все за один запуск.
+type validator struct {
+	errs []error
+}
+
+func (v *validator) add(field, msg string) {
+	v.errs = append(v.errs, fmt.Errorf("config: %s %s", field, msg))
+}
+
+func (v *validator) required(field, s string) {
+	if strings.TrimSpace(s) == "" {
+		v.add(field, "is required")
+	}
+}
+
+func (v *validator) positive(field string, d time.Duration) {
+	if d <= 0 {
+		v.add(field, "must be positive")
+	}
+}
+
+func (v *validator) nonNegative(field string, d time.Duration) {
+	if d < 0 {
+		v.add(field, "must not be negative")
+	}
+}
+
+func (v *validator) oneOf(field, s string, allowed ...string) {
+	for _, a := range allowed {
+		if s == a {
+			return
+		}
+	}
+	v.add(field, fmt.Sprintf("is %q, expected one of: %s", s, strings.Join(allowed, ", ")))
+}
+
+func (v *validator) err() error {
+	return errors.Join(v.errs...)
+}
@@ -4,25 +4,51 @@ package db
 import (
 	"context"
 	"fmt"
-	"os"
+
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/config"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-// NewPostgresPool создаёт пул соединений к psql.
-// DSN берётся из переменной окружения POSTGRES_DSN.
-func NewPostgresPool(ctx context.Context) (*pgxpool.Pool, error) {
-	dsn := os.Getenv("POSTGRES_DSN")
-	if dsn == "" {
-		return nil, fmt.Errorf("env POSTGRES_DSN is not set")
+// NewPostgresPool создаёт пул соединений к psql по настройкам из конфига.
+// Нулевые размеры и времена жизни оставляют умолчания pgxpool.
+// Каждый запрос пишется спаном OpenTelemetry.
+func NewPostgresPool(ctx context.Context, cfg config.Postgres) (*pgxpool.Pool, error) {
+	pcfg, err := pgxpool.ParseConfig(cfg.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("некорректный POSTGRES_DSN: %w", err)
+	}
+
+	if cfg.MaxConns > 0 {
+		pcfg.MaxConns = cfg.MaxConns
+	}
+	if cfg.MinConns > 0 {
+		pcfg.MinConns = cfg.MinConns
+	}
+	if cfg.MaxConnLifetime > 0 {
+		pcfg.MaxConnLifetime = cfg.MaxConnLifetime
+	}
+	if cfg.MaxConnIdleTime > 0 {
+		pcfg.MaxConnIdleTime = cfg.MaxConnIdleTime
+	}
+	if cfg.HealthCheckPeriod > 0 {
+		pcfg.HealthCheckPeriod = cfg.HealthCheckPeriod
+	}
+	pcfg.ConnConfig.Tracer = queryTracer{}
+
+	if cfg.ConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.ConnectTimeout)
+		defer cancel()
 	}
 
-	pool, err := pgxpool.New(ctx, dsn)
+	pool, err := pgxpool.NewWithConfig(ctx, pcfg)
 	if err != nil {
 		return nil, fmt.Errorf("ошибка при подключении к постгрес: %w", err)
 	}
 
 	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
 		return nil, fmt.Errorf("постгрес не отвечает. Ошибка: %w", err)
 	}
 
@@ -0,0 +1,94 @@
+package db
+
+import (
+	"context"
+	"strings"
+
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/tracing"
+
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/db")
+
+// queryTracer пишет спан на каждый запрос к Postgres, включая пакеты и COPY,
+// какой бы репозиторий его ни выполнял. Родитель — спан из контекста запроса.
+type queryTracer struct{}
+
+var (
+	_ pgx.QueryTracer    = queryTracer{}
+	_ pgx.BatchTracer    = queryTracer{}
+	_ pgx.CopyFromTracer = queryTracer{}
+)
+
+// dbAttrs — общие атрибуты спанов БД.
+var dbAttrs = []attribute.KeyValue{attribute.String("db.system.name", "postgresql")}
+
+func (queryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	ctx, _ = tracer.Start(ctx, "postgres "+operation(data.SQL),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(dbAttrs...),
+		trace.WithAttributes(attribute.String("db.query.text", compact(data.SQL))),
+	)
+	return ctx
+}
+
+func (queryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.Int64("db.response.rows_affected", data.CommandTag.RowsAffected()))
+	tracing.End(span, data.Err)
+}
+
+func (queryTracer) TraceBatchStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchStartData) context.Context {
+	ctx, _ = tracer.Start(ctx, "postgres BATCH",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(dbAttrs...),
+		trace.WithAttributes(attribute.Int("db.operation.batch.size", data.Batch.Len())),
+	)
+	return ctx
+}
+
+// TraceBatchQuery отмечает в спане пакета каждый его запрос событием.
+func (queryTracer) TraceBatchQuery(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchQueryData) {
+	attrs := []attribute.KeyValue{attribute.String("db.query.text", compact(data.SQL))}
+	if data.Err != nil {
+		attrs = append(attrs, attribute.String("error.message", data.Err.Error()))
+	}
+	trace.SpanFromContext(ctx).AddEvent("query "+operation(data.SQL), trace.WithAttributes(attrs...))
+}
+
+func (queryTracer) TraceBatchEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchEndData) {
+	tracing.End(trace.SpanFromContext(ctx), data.Err)
+}
+
+func (queryTracer) TraceCopyFromStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceCopyFromStartData) context.Context {
+	ctx, _ = tracer.Start(ctx, "postgres COPY "+data.TableName.Sanitize(),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(dbAttrs...),
+		trace.WithAttributes(attribute.String("db.collection.name", data.TableName.Sanitize())),
+	)
+	return ctx
+}
+
+func (queryTracer) TraceCopyFromEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceCopyFromEndData) {
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.Int64("db.response.rows_affected", data.CommandTag.RowsAffected()))
+	tracing.End(span, data.Err)
+}
+
+// operation возвращает первое слово запроса (SELECT, INSERT, ...) для имени спана.
+func operation(sql string) string {
+	f := strings.Fields(sql)
+	if len(f) == 0 {
+		return "QUERY"
+	}
+	return strings.ToUpper(f[0])
+}
+
+// compact схлопывает переводы строк и отступы, чтобы SQL читался в одну строку.
+func compact(sql string) string {
+	return strings.Join(strings.Fields(sql), " ")
+}
@@ -0,0 +1,189 @@
+// Package health реализует проверки живости (/healthz) и готовности (/readyz).
+// Живость говорит только о том, что процесс отвечает. Готовность проверяет
+// зависимости: каждая проверка выполняется со своим таймаутом, и если хоть одна
+// не прошла, оркестратор не должен направлять трафик на инстанс.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Статусы проверок.
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// defaultTimeout — таймаут проверки по умолчанию.
+const defaultTimeout = 2 * time.Second
+
+// CheckFunc проверяет одну зависимость. nil — зависимость в порядке.
+type CheckFunc func(ctx context.Context) error
+
+// check — зарегистрированная проверка.
+type check struct {
+	name    string
+	fn      CheckFunc
+	timeout time.Duration
+}
+
+// Checker выполняет проверки готовности.
+type Checker struct {
+	timeout time.Duration
+	checks  []check
+}
+
+// Option настраивает Checker.
+type Option func(*Checker)
+
+// WithTimeout задаёт таймаут проверок, у которых он не указан явно.
+func WithTimeout(d time.Duration) Option {
+	return func(c *Checker) {
+		if d > 0 {
+			c.timeout = d
+		}
+	}
+}
+
+// New создаёт Checker без проверок.
+func New(opts ...Option) *Checker {
+	c := &Checker{timeout: defaultTimeout}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Add регистрирует проверку. timeout <= 0 — таймаут по умолчанию.
+// Проверки добавляются до того, как сервер начнёт принимать запросы.
+func (c *Checker) Add(name string, fn CheckFunc, timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = c.timeout
+	}
+	c.checks = append(c.checks, check{name: name, fn: fn, timeout: timeout})
+}
+
+// Component — результат одной проверки.
+type Component struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// Report — результат всех проверок.
+type Report struct {
+	Status     string               `json:"status"`
+	Components map[string]Component `json:"components,omitempty"`
+}
+
+// Check выполняет все проверки параллельно.
+func (c *Checker) Check(ctx context.Context) Report {
+	rep := Report{Status: StatusOK, Components: make(map[string]Component, len(c.checks))}
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for _, ch := range c.checks {
+		wg.Add(1)
+		go func(ch check) {
+			defer wg.Done()
+			comp := run(ctx, ch)
+
+			mu.Lock()
+			defer mu.Unlock()
+			rep.Components[ch.name] = comp
+			if comp.Status != StatusOK {
+				rep.Status = StatusFail
+			}
+		}(ch)
+	}
+	wg.Wait()
+
+	return rep
+}
+
+// run выполняет проверку с таймаутом. Зависшая проверка считается упавшей,
+// даже если CheckFunc не уважает контекст.
+func run(ctx context.Context, ch check) Component {
+	ctx, cancel := context.WithTimeout(ctx, ch.timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- ch.fn(ctx) }()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = errors.New("timed out after " + ch.timeout.String())
+	}
+
+	comp := Component{Status: StatusOK, Duration: time.Since(start).Round(time.Microsecond).String()}
+	if err != nil {
+		comp.Status, comp.Error = StatusFail, err.Error()
+	}
+	return comp
+}
+
+// LiveHandler отвечает 200, пока процесс способен обрабатывать запросы.
+func (c *Checker) LiveHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeReport(w, http.StatusOK, Report{Status: StatusOK})
+	})
+}
+
+// ReadyHandler отвечает 200, если все проверки прошли, иначе 503.
+// В теле — результат по каждой зависимости.
+func (c *Checker) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rep := c.Check(r.Context())
+		status := http.StatusOK
+		if rep.Status != StatusOK {
+			status = http.StatusServiceUnavailable
+		}
+		writeReport(w, status, rep)
+	})
+}
+
+func writeReport(w http.ResponseWriter, status int, rep Report) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(rep)
+}
+
+// Flag — проверка, которая проходит после вызова Set.
+// Подходит для одноразовых этапов запуска, например прогрева кэша.
+type Flag struct {
+	ready  atomic.Bool
+	reason string
+}
+
+// NewFlag создаёт флаг. reason — текст ошибки, пока флаг не выставлен.
+func NewFlag(reason string) *Flag {
+	return &Flag{reason: reason}
+}
+
+// Set отмечает этап завершённым.
+func (f *Flag) Set() { f.ready.Store(true) }
+
+// Check реализует CheckFunc.
+func (f *Flag) Check(ctx context.Context) error {
+	if !f.ready.Load() {
+		return errors.New(f.reason)
+	}
+	return nil
+}
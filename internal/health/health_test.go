@@ -0,0 +1,70 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReadyHandler(t *testing.T) {
+	warm := NewFlag("warming up")
+
+	c := New(WithTimeout(50 * time.Millisecond))
+	c.Add("postgres", func(ctx context.Context) error { return nil }, 0)
+	c.Add("cache", warm.Check, 0)
+
+	rr := httptest.NewRecorder()
+	c.ReadyHandler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 before warmup, got %d", rr.Code)
+	}
+
+	var rep Report
+	if err := json.NewDecoder(rr.Body).Decode(&rep); err != nil {
+		t.Fatal(err)
+	}
+	if rep.Status != StatusFail || rep.Components["postgres"].Status != StatusOK ||
+		rep.Components["cache"].Error != "warming up" {
+		t.Fatalf("unexpected report: %+v", rep)
+	}
+
+	warm.Set()
+	rr = httptest.NewRecorder()
+	c.ReadyHandler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 after warmup, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestCheckTimeout(t *testing.T) {
+	c := New()
+	// проверка игнорирует контекст и висит дольше своего таймаута
+	block := make(chan struct{})
+	defer close(block)
+	c.Add("stuck", func(ctx context.Context) error { <-block; return nil }, 20*time.Millisecond)
+	c.Add("broken", func(ctx context.Context) error { return errors.New("boom") }, 0)
+
+	start := time.Now()
+	rep := c.Check(context.Background())
+	if time.Since(start) > time.Second {
+		t.Fatalf("check did not respect timeout")
+	}
+	if rep.Components["stuck"].Status != StatusFail || rep.Components["broken"].Error != "boom" {
+		t.Fatalf("unexpected report: %+v", rep)
+	}
+}
+
+func TestLiveHandler(t *testing.T) {
+	c := New()
+	c.Add("broken", func(ctx context.Context) error { return errors.New("boom") }, 0)
+
+	rr := httptest.NewRecorder()
+	c.LiveHandler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("liveness must not depend on checks, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,73 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/logging"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/repo"
+)
+
+// Коды ошибок в ответах API. Клиенты ориентируются на них, а не на текст.
+const (
+	codeBadRequest       = "bad_request"
+	codeBadJSON          = "bad_json"
+	codeValidationFailed = "validation_failed"
+	codeRuleViolation    = "rule_violation"
+	codePayloadTooLarge  = "payload_too_large"
+	codeNotFound         = "not_found"
+	codeMethodNotAllowed = "method_not_allowed"
+	codeConflict         = "conflict"
+	codeInProgress       = "in_progress"
+	codeStale            = "stale"
+	codeUnavailable      = "unavailable"
+	codeInternal         = "internal"
+)
+
+// apiError — единый формат ошибки API.
+type apiError struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+	Details   any    `json:"details,omitempty"`
+}
+
+// newAPIError собирает ошибку с идентификатором текущего запроса.
+func newAPIError(ctx context.Context, code, message string, details any) apiError {
+	return apiError{Code: code, Message: message, RequestID: logging.RequestID(ctx), Details: details}
+}
+
+// writeError отдаёт ошибку в едином формате.
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, message string, details any) {
+	writeJSONStatus(w, status, newAPIError(r.Context(), code, message, details))
+}
+
+// storeError сопоставляет ошибку хранилища коду ответа:
+// 404 — записи нет, 409 — конфликт, 503 — БД недоступна, 500 — всё остальное.
+func storeError(ctx context.Context, err error) (int, apiError) {
+	switch {
+	case errors.Is(err, repo.ErrNotFound):
+		return http.StatusNotFound, newAPIError(ctx, codeNotFound, err.Error(), nil)
+	case errors.Is(err, repo.ErrStale):
+		return http.StatusConflict, newAPIError(ctx, codeStale, "a newer copy of the order is already stored", nil)
+	case errors.Is(err, repo.ErrConflict):
+		return http.StatusConflict, newAPIError(ctx, codeConflict, "conflicts with stored data", nil)
+	case errors.Is(err, repo.ErrUnavailable), errors.Is(err, context.DeadlineExceeded):
+		return http.StatusServiceUnavailable, newAPIError(ctx, codeUnavailable, "storage temporarily unavailable", nil)
+	}
+	return http.StatusInternalServerError, newAPIError(ctx, codeInternal, "internal error", nil)
+}
+
+// writeStoreError логирует ошибку хранилища и отдаёт её клиенту.
+// Если клиент уже ушёл, ответ не пишется. args — дополнительные поля для лога.
+func (s *Server) writeStoreError(w http.ResponseWriter, r *http.Request, op string, err error, args ...any) {
+	if errors.Is(err, context.Canceled) && r.Context().Err() != nil {
+		return
+	}
+	if !errors.Is(err, repo.ErrNotFound) {
+		s.log.ErrorContext(r.Context(), op+" failed", append(args, "error", err)...)
+	}
+	status, body := storeError(r.Context(), err)
+	writeJSONStatus(w, status, body)
+}
@@ -0,0 +1,128 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/jsondiff"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/models"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// historyResponse — ответ GET /order/{id}/history.
+type historyResponse struct {
+	OrderUID string                `json:"order_uid"`
+	Versions []models.OrderVersion `json:"versions"`
+}
+
+// diffResponse — ответ GET /order/{id}/history/diff.
+type diffResponse struct {
+	OrderUID string            `json:"order_uid"`
+	From     int               `json:"from"`
+	To       int               `json:"to"`
+	Changes  []jsondiff.Change `json:"changes"`
+}
+
+// handleHistory отдаёт список версий заказа без снимков.
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	versions, err := s.history.ListOrderVersions(r.Context(), id)
+	if err != nil {
+		s.writeStoreError(w, r, "list order versions", err, "order_uid", id)
+		return
+	}
+	writeJSON(w, historyResponse{OrderUID: id, Versions: versions})
+}
+
+// handleVersion отдаёт снимок заказа в указанной версии.
+func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	version, err := parseVersion(chi.URLParam(r, "version"), "version")
+	if err != nil || version == 0 {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "version must be a positive integer", nil)
+		return
+	}
+
+	v, err := s.history.GetOrderVersion(r.Context(), id, version)
+	if err != nil {
+		s.writeStoreError(w, r, "get order version", err, "order_uid", id, "version", version)
+		return
+	}
+	writeJSON(w, v)
+}
+
+// handleDiff сравнивает две версии заказа.
+// По умолчанию to — последняя версия, from — предыдущая перед to.
+func (s *Server) handleDiff(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	q := r.URL.Query()
+	from, err := parseVersion(q.Get("from"), "from")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, err.Error(), nil)
+		return
+	}
+	to, err := parseVersion(q.Get("to"), "to")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, err.Error(), nil)
+		return
+	}
+
+	ctx := r.Context()
+	vTo, err := s.history.GetOrderVersion(ctx, id, to)
+	if err != nil {
+		s.writeStoreError(w, r, "get order version", err, "order_uid", id, "version", to)
+		return
+	}
+	if from == 0 {
+		from = vTo.Version - 1
+	}
+	if from < 1 {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "version 1 has no previous version, pass from", nil)
+		return
+	}
+	vFrom, err := s.history.GetOrderVersion(ctx, id, from)
+	if err != nil {
+		s.writeStoreError(w, r, "get order version", err, "order_uid", id, "version", from)
+		return
+	}
+
+	changes, err := diffVersions(vFrom, vTo)
+	if err != nil {
+		s.log.ErrorContext(ctx, "diff order versions failed", "order_uid", id, "error", err)
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "internal error", nil)
+		return
+	}
+	writeJSON(w, diffResponse{OrderUID: id, From: vFrom.Version, To: vTo.Version, Changes: changes})
+}
+
+// diffVersions сравнивает снимки двух версий.
+func diffVersions(from, to models.OrderVersion) ([]jsondiff.Change, error) {
+	a, err := json.Marshal(from.Order)
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(to.Order)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := jsondiff.Diff(a, b)
+	if changes == nil {
+		changes = []jsondiff.Change{}
+	}
+	return changes, err
+}
+
+// parseVersion разбирает номер версии. Пустая строка — 0 (значение по умолчанию).
+func parseVersion(s, name string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < 1 {
+		return 0, fmt.Errorf("%s must be a positive integer", name)
+	}
+	return v, nil
+}
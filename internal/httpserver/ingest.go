@@ -0,0 +1,339 @@
+package httpserver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/ingest"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/logging"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/models"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/repo"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/validation"
+)
+
+// ограничения на тело запросов приёма заказов
+const (
+	maxOrderBody = 1 << 20  // один заказ
+	maxBatchBody = 32 << 20 // NDJSON-пачка
+	maxBatchLine = 4 << 20  // одна строка пачки
+)
+
+// заголовки идемпотентности
+const (
+	headerIdempotencyKey = "Idempotency-Key"
+	headerReplayed       = "Idempotent-Replayed"
+)
+
+// Статусы строк в ответе на пачку.
+const (
+	statusStored   = "stored"
+	statusRejected = "rejected"
+	statusStale    = "stale" // в БД уже более свежая копия, см. WithFreshness
+)
+
+// ingestResponse — ответ POST /orders.
+type ingestResponse struct {
+	OrderUID string                 `json:"order_uid"`
+	Status   string                 `json:"status"`
+	Warnings []validation.Violation `json:"warnings,omitempty"`
+}
+
+// batchLine — результат одной строки пачки. Line считается с единицы.
+type batchLine struct {
+	Line     int                 `json:"line"`
+	OrderUID string              `json:"order_uid,omitempty"`
+	Status   string              `json:"status"`
+	Reason   string              `json:"reason,omitempty"`
+	Error    string              `json:"error,omitempty"`
+	Fields   []ingest.FieldError `json:"fields,omitempty"`
+
+	Warnings []validation.Violation `json:"warnings,omitempty"`
+}
+
+// batchResponse — ответ POST /orders:batch.
+type batchResponse struct {
+	Stored   int         `json:"stored"`
+	Rejected int         `json:"rejected"`
+	Stale    int         `json:"stale,omitempty"`
+	Results  []batchLine `json:"results"`
+}
+
+// ingestFunc обрабатывает тело запроса и возвращает код и объект ответа.
+// Заголовки нужны для свежести копии, см. WithFreshness.
+type ingestFunc func(ctx context.Context, h http.Header, body []byte) (int, any)
+
+// handleIngest читает тело с ограничением размера и передаёт его в fn.
+// Если пришёл Idempotency-Key и хранилище ключей подключено, ключ занимается
+// до записи: повтор запроса получает сохранённый ответ без повторной записи,
+// а повтор, пришедший пока первый запрос ещё выполняется, — 409 in_progress.
+// Ответы 5xx не сохраняются, чтобы клиент мог повторить запрос после сбоя.
+func (s *Server) handleIngest(limit int64, fn ingestFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := readBody(w, r, limit)
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				writeError(w, r, http.StatusRequestEntityTooLarge, codePayloadTooLarge, "request body too large", nil)
+				return
+			}
+			writeError(w, r, http.StatusBadRequest, codeBadRequest, "cannot read request body", nil)
+			return
+		}
+
+		key := r.Header.Get(headerIdempotencyKey)
+		if key == "" || s.idempotency == nil {
+			status, resp := fn(r.Context(), r.Header, body)
+			writeJSONStatus(w, status, resp)
+			return
+		}
+
+		hash := requestHash(r, body)
+		rec, reserved, err := s.idempotency.ReserveIdempotency(r.Context(), key, hash)
+		if err != nil {
+			s.writeStoreError(w, r, "reserve idempotency key", err, "key", key)
+			return
+		}
+		if !reserved {
+			switch {
+			case rec.RequestHash != hash:
+				writeError(w, r, http.StatusConflict, codeConflict, "idempotency key reused with a different request", nil)
+			case rec.Pending():
+				w.Header().Set("Retry-After", "1")
+				writeError(w, r, http.StatusConflict, codeInProgress, "a request with this idempotency key is still in progress", nil)
+			default:
+				w.Header().Set(headerReplayed, "true")
+				writeRawJSON(w, rec.StatusCode, rec.Response)
+			}
+			return
+		}
+
+		status, resp := fn(r.Context(), r.Header, body)
+		b := encodeJSON(resp)
+		s.finishIdempotency(r.Context(), key, hash, status, b)
+		writeRawJSON(w, status, b)
+	}
+}
+
+// finishIdempotency записывает ответ в занятый ключ, а при 5xx снимает резерв.
+// Клиент мог уже отключиться, но ключ всё равно нужно довести до конца,
+// поэтому отмена контекста запроса здесь не учитывается.
+func (s *Server) finishIdempotency(ctx context.Context, key, hash string, status int, resp []byte) {
+	ctx = context.WithoutCancel(ctx)
+
+	var err error
+	if status >= http.StatusInternalServerError {
+		err = s.idempotency.ReleaseIdempotency(ctx, key, hash)
+	} else {
+		err = s.idempotency.CompleteIdempotency(ctx, models.IdempotencyRecord{
+			Key:         key,
+			RequestHash: hash,
+			StatusCode:  status,
+			Response:    resp,
+		})
+	}
+	if err != nil {
+		// запись уже выполнена, поэтому клиенту отдаётся ответ, а не ошибка;
+		// резерв освободится сам через repo.IdempotencyPendingTTL
+		s.log.ErrorContext(ctx, "finish idempotency key failed", "key", key, "status", status, "error", err)
+	}
+}
+
+// readBody читает тело запроса не больше limit байт.
+func readBody(w http.ResponseWriter, r *http.Request, limit int64) ([]byte, error) {
+	var buf bytes.Buffer
+	_, err := buf.ReadFrom(http.MaxBytesReader(w, r.Body, limit))
+	return buf.Bytes(), err
+}
+
+// requestHash отличает разные запросы с одним Idempotency-Key.
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// decode разбирает заказ и проверяет его бизнес-правилами. Все нарушения сразу пишутся
+// в лог и метрики: повторов записи, как в консьюмере, здесь нет.
+func (s *Server) decode(ctx context.Context, body []byte) (models.Order, []validation.Violation, error) {
+	check := ingest.Checker{Rules: s.rules, Log: s.log, Metrics: s.metrics}
+	o, warnings, err := check.Decode(ctx, body)
+	if err != nil {
+		return models.Order{}, nil, err
+	}
+	check.Report(ctx, o.OrderUID, warnings)
+	return o, warnings, nil
+}
+
+// ingestOrder обрабатывает POST /orders: разбор, валидация и бизнес-правила как в консьюмере,
+// затем запись в БД и в кэш. Нарушения правил с серьёзностью warn возвращаются в warnings.
+// Если в БД уже более свежая копия заказа, возвращается 409 с кодом stale.
+func (s *Server) ingestOrder(ctx context.Context, h http.Header, body []byte) (int, any) {
+	o, warnings, err := s.decode(ctx, body)
+	if err != nil {
+		return rejectError(ctx, err)
+	}
+
+	err = s.repo.InsertOrUpdateOrder(ctx, o, s.source(ctx, h, o))
+	if errors.Is(err, repo.ErrStale) {
+		s.log.InfoContext(ctx, "stale update skipped", "order_uid", o.OrderUID)
+		return storeError(ctx, err)
+	}
+	if err != nil {
+		s.log.ErrorContext(ctx, "ingest order failed", "order_uid", o.OrderUID, "error", err)
+		return storeError(ctx, err)
+	}
+	s.cache.Set(o)
+
+	return http.StatusOK, ingestResponse{OrderUID: o.OrderUID, Status: statusStored, Warnings: warnings}
+}
+
+// ingestBatch обрабатывает POST /orders:batch: по заказу на строку (NDJSON).
+// Битые строки отклоняются по отдельности, валидные заказы пишутся одной пачкой.
+// Если пачка не записалась из-за постоянной ошибки, заказы пишутся по одному,
+// как в консьюмере, и отклоняются только строки, которые не записались.
+// Если ни одна строка не прошла проверку, возвращается 422 с результатами строк в details.
+func (s *Server) ingestBatch(ctx context.Context, h http.Header, body []byte) (int, any) {
+	var (
+		resp   = batchResponse{Results: []batchLine{}}
+		writes []repo.OrderWrite
+		lines  []int // индекс в resp.Results для каждой записи writes
+	)
+
+	sc := bufio.NewScanner(bytes.NewReader(body))
+	sc.Buffer(make([]byte, 0, 64*1024), maxBatchLine)
+	for line := 1; sc.Scan(); line++ {
+		payload := bytes.TrimSpace(sc.Bytes())
+		if len(payload) == 0 {
+			continue
+		}
+
+		o, warnings, err := s.decode(ctx, payload)
+		if err != nil {
+			res := batchLine{Line: line, Status: statusRejected}
+			var rej *ingest.RejectError
+			if errors.As(err, &rej) {
+				res.Reason, res.Error, res.Fields = rej.Reason, rej.Err.Error(), rej.Fields()
+			}
+			resp.Results = append(resp.Results, res)
+			resp.Rejected++
+			continue
+		}
+
+		writes = append(writes, repo.OrderWrite{Order: o, Source: s.source(ctx, h, o)})
+		lines = append(lines, len(resp.Results))
+		resp.Results = append(resp.Results, batchLine{Line: line, OrderUID: o.OrderUID, Status: statusStored, Warnings: warnings})
+	}
+	if err := sc.Err(); err != nil {
+		return http.StatusBadRequest, newAPIError(ctx, codeBadRequest, "cannot read ndjson: "+err.Error(), nil)
+	}
+	if len(resp.Results) == 0 {
+		return http.StatusBadRequest, newAPIError(ctx, codeBadRequest, "empty batch", nil)
+	}
+	if len(writes) == 0 {
+		return http.StatusUnprocessableEntity, newAPIError(ctx, codeValidationFailed, "no valid orders in batch", resp)
+	}
+
+	var failed map[int]error
+	stale, err := s.repo.InsertOrUpdateOrders(ctx, writes)
+	if err != nil {
+		// БД недоступна или клиент ушёл: по одному тоже не запишется, пачку можно повторить
+		if errors.Is(err, repo.ErrUnavailable) || ctx.Err() != nil {
+			s.log.ErrorContext(ctx, "ingest batch failed", "orders", len(writes), "error", err)
+			return storeError(ctx, err)
+		}
+		s.log.WarnContext(ctx, "ingest batch failed, falling back to single writes", "orders", len(writes), "error", err)
+		stale, failed = s.writeSingly(ctx, writes)
+	}
+
+	skipped := make(map[int]bool, len(stale)+len(failed))
+	for _, i := range stale {
+		skipped[i] = true
+		resp.Results[lines[i]].Status = statusStale
+		s.log.InfoContext(ctx, "stale update skipped", "order_uid", writes[i].Order.OrderUID)
+	}
+	for i, err := range failed {
+		skipped[i] = true
+		_, apiErr := storeError(ctx, err)
+		res := &resp.Results[lines[i]]
+		res.Status, res.Reason, res.Error, res.Warnings = statusRejected, apiErr.Code, apiErr.Message, nil
+		s.log.ErrorContext(ctx, "ingest order failed", "order_uid", writes[i].Order.OrderUID, "error", err)
+	}
+	for i, w := range writes {
+		if !skipped[i] {
+			s.cache.Set(w.Order)
+		}
+	}
+	resp.Stored, resp.Stale = len(writes)-len(stale)-len(failed), len(stale)
+	resp.Rejected += len(failed)
+
+	return http.StatusOK, resp
+}
+
+// writeSingly пишет заказы пачки по одному. Возвращает индексы устаревших копий
+// и ошибки записи остальных не записавшихся заказов по их индексам.
+func (s *Server) writeSingly(ctx context.Context, writes []repo.OrderWrite) (stale []int, failed map[int]error) {
+	failed = make(map[int]error)
+	for i, w := range writes {
+		err := s.repo.InsertOrUpdateOrder(ctx, w.Order, w.Source)
+		switch {
+		case errors.Is(err, repo.ErrStale):
+			stale = append(stale, i)
+		case err != nil:
+			failed[i] = err
+		}
+	}
+	return stale, failed
+}
+
+// source описывает запрос как источник записи для истории версий вместе со свежестью копии.
+// Свежесть считается так же, как в консьюмере (см. WithFreshness), поэтому
+// HTTP-запись не затирает более новую копию из Kafka и проходит ту же проверку.
+func (s *Server) source(ctx context.Context, h http.Header, o models.Order) repo.Source {
+	src := repo.Source{Kind: repo.SourceHTTP, Ref: logging.RequestID(ctx)}
+	switch s.freshness {
+	case ingest.FreshnessKafkaTime:
+		src.At = time.Now()
+	case ingest.FreshnessPaymentDt:
+		if o.Payment.PaymentDt > 0 {
+			src.At = time.Unix(o.Payment.PaymentDt, 0)
+		}
+	case ingest.FreshnessHeader:
+		if v := h.Get(s.freshnessHeader); v != "" {
+			src.At = ingest.ParseTime(v)
+		}
+	}
+	return src
+}
+
+// rejectError описывает отклонённый заказ: 400 для битого JSON
+// и 422 с ошибками по полям в details для заказа, не прошедшего валидацию
+// или нарушившего бизнес-правила.
+func rejectError(ctx context.Context, err error) (int, apiError) {
+	var rej *ingest.RejectError
+	if errors.As(err, &rej) {
+		switch rej.Reason {
+		case ingest.ReasonValidation:
+			return http.StatusUnprocessableEntity, newAPIError(ctx, codeValidationFailed, "validation failed", rej.Fields())
+		case ingest.ReasonRules:
+			return http.StatusUnprocessableEntity, newAPIError(ctx, codeRuleViolation, "business rules violated", rej.Fields())
+		}
+	}
+	return http.StatusBadRequest, newAPIError(ctx, codeBadJSON, err.Error(), nil)
+}
+
+// encodeJSON сериализует ответ так же, как writeJSON.
+func encodeJSON(v any) []byte {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+	return buf.Bytes()
+}
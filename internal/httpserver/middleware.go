@@ -0,0 +1,140 @@
+package httpserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/logging"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/httpserver")
+
+// headerRequestID — заголовок с идентификатором запроса.
+const headerRequestID = "X-Request-Id"
+
+// maxRequestIDLen — входящий идентификатор длиннее этого заменяется своим.
+const maxRequestIDLen = 128
+
+// requestID берёт идентификатор запроса из X-Request-Id (например, от балансировщика)
+// или генерирует новый. Идентификатор кладётся в контекст, откуда попадает
+// в логи и в ответы с ошибкой, и возвращается в заголовке ответа.
+func requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(headerRequestID)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(headerRequestID, id)
+		next.ServeHTTP(w, r.WithContext(logging.WithRequestID(r.Context(), id)))
+	})
+}
+
+// validRequestID пропускает только короткие идентификаторы из видимых ASCII-символов,
+// чтобы клиент не мог подсунуть в логи перевод строки или мегабайт мусора.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID генерирует случайный идентификатор из 16 байт.
+func newRequestID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// accessLog пишет строку лога на каждый запрос: метод, маршрут, статус, размер ответа
+// и длительность. Пробы /healthz, /readyz и сбор /metrics идут на уровне debug
+// (503 от /readyz — нормальное состояние при старте), остальные ответы 5xx — на уровне error.
+func (s *Server) accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		status := responseStatus(ww)
+		route := routePattern(r)
+
+		level := slog.LevelInfo
+		switch {
+		case route == "/healthz" || route == "/readyz" || route == "/metrics":
+			level = slog.LevelDebug
+		case status >= http.StatusInternalServerError:
+			level = slog.LevelError
+		}
+
+		s.log.LogAttrs(r.Context(), level, "http request",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("route", route),
+			slog.Int("status", status),
+			slog.Int("bytes", ww.BytesWritten()),
+			slog.Duration("took", time.Since(start)),
+			slog.String("remote", r.RemoteAddr),
+		)
+	})
+}
+
+// traceRequest начинает спан на каждый запрос. Если клиент прислал traceparent,
+// спан продолжает его трассу. Имя спана — метод и шаблон маршрута chi.
+func traceRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+				attribute.String("client.address", r.RemoteAddr),
+			),
+		)
+		defer span.End()
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		status := responseStatus(ww)
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if route := routePattern(r); route != "" {
+			span.SetName(r.Method + " " + route)
+			span.SetAttributes(attribute.String("http.route", route))
+		}
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	})
+}
+
+// responseStatus возвращает код ответа; 0 значит, что хендлер ничего не записал, то есть 200.
+func responseStatus(ww middleware.WrapResponseWriter) int {
+	if st := ww.Status(); st != 0 {
+		return st
+	}
+	return http.StatusOK
+}
+
+// routePattern возвращает шаблон маршрута chi. Известен только после обработки запроса.
+func routePattern(r *http.Request) string {
+	if rctx := chi.RouteContext(r.Context()); rctx != nil {
+		return rctx.RoutePattern()
+	}
+	return ""
+}
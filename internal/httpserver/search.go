@@ -0,0 +1,106 @@
+package httpserver
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/models"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/repo"
+)
+
+// searchResponse — ответ GET /orders.
+type searchResponse struct {
+	Orders     []models.Order `json:"orders"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+// handleSearchOrders ищет заказы по фильтрам из query-параметров.
+// Следующая страница запрашивается с параметром cursor из next_cursor.
+func (s *Server) handleSearchOrders(w http.ResponseWriter, r *http.Request) {
+	f, err := parseOrderFilter(r.URL.Query())
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, err.Error(), nil)
+		return
+	}
+
+	page, err := s.repo.SearchOrders(r.Context(), f)
+	if err != nil {
+		s.writeStoreError(w, r, "search orders", err)
+		return
+	}
+
+	resp := searchResponse{Orders: page.Orders}
+	if page.Next != nil {
+		resp.NextCursor = page.Next.Encode()
+	}
+	writeJSON(w, resp)
+}
+
+// parseOrderFilter собирает фильтр поиска из query-параметров.
+func parseOrderFilter(q url.Values) (repo.OrderFilter, error) {
+	f := repo.OrderFilter{
+		CustomerID:      q.Get("customer_id"),
+		TrackNumber:     q.Get("track_number"),
+		DeliveryService: q.Get("delivery_service"),
+		Provider:        q.Get("provider"),
+		Currency:        q.Get("currency"),
+		Brand:           q.Get("brand"),
+	}
+
+	var err error
+	if f.CreatedFrom, err = parseTimeParam(q, "created_from"); err != nil {
+		return f, err
+	}
+	if f.CreatedTo, err = parseTimeParam(q, "created_to"); err != nil {
+		return f, err
+	}
+
+	if v := q.Get("nm_id"); v != "" {
+		if f.NmID, err = strconv.ParseInt(v, 10, 64); err != nil || f.NmID <= 0 {
+			return f, fmt.Errorf("bad nm_id %q", v)
+		}
+	}
+
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > repo.MaxSearchLimit {
+			return f, fmt.Errorf("limit must be in 1..%d", repo.MaxSearchLimit)
+		}
+		f.Limit = n
+	}
+
+	switch sort := repo.SortOrder(q.Get("sort")); sort {
+	case "", repo.SortNewest, repo.SortOldest:
+		f.Sort = sort
+	default:
+		return f, fmt.Errorf("sort must be %s or %s", repo.SortNewest, repo.SortOldest)
+	}
+
+	if v := q.Get("cursor"); v != "" {
+		c, err := repo.DecodeCursor(v)
+		if err != nil {
+			return f, err
+		}
+		f.After = &c
+	}
+
+	return f, nil
+}
+
+// parseTimeParam читает время в формате RFC3339 или дату YYYY-MM-DD.
+func parseTimeParam(q url.Values, name string) (time.Time, error) {
+	v := q.Get(name)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.DateOnly, v); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("bad %s %q: expected RFC3339 or YYYY-MM-DD", name, v)
+}
@@ -2,29 +2,167 @@
 package httpserver
 
 import (
-	"encoding/json"
-	"log"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
 	"net/http"
+	"time"
 
 	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/cache"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/health"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/ingest"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/metrics"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/models"
 	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/repo"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/validation"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/web"
 
 	"github.com/go-chi/chi/v5"
+	"golang.org/x/sync/singleflight"
 )
 
+// значения по умолчанию для чтения через кэш
+const (
+	defaultNegativeTTL  = 5 * time.Second
+	defaultNegativeSize = 10000
+	dbLookupTimeout     = 5 * time.Second
+)
+
+// errOrderNotFound — заказа нет ни в кэше, ни в БД.
+var errOrderNotFound = fmt.Errorf("order %w", repo.ErrNotFound)
+
 // Server инкапсулирует кэш, репозиторий и роутер.
 type Server struct {
 	cache cache.OrderCache
 	repo  repo.OrdersStorage
 	mux   *chi.Mux
+
+	// lookups склеивает параллельные походы в БД за одним и тем же заказом
+	lookups  singleflight.Group
+	notFound *cache.NegativeCache
+
+	// history отдаёт историю версий заказов, nil — эндпоинты истории не подключаются
+	history repo.VersionStorage
+	// statuses отдаёт статусы заказов, nil — GET /order/{id}/status не подключается
+	statuses repo.StatusStorage
+
+	// rules проверяет принимаемые заказы бизнес-правилами, nil — не проверяет
+	rules *validation.Engine
+
+	// freshness — как считать свежесть принимаемой копии заказа, см. WithFreshness
+	freshness       ingest.Freshness
+	freshnessHeader string
+
+	// idempotency хранит ответы на POST с Idempotency-Key, nil — заголовок игнорируется
+	idempotency repo.IdempotencyStorage
+
+	// web — статика UI, по умолчанию встроенная в бинарник
+	web fs.FS
+
+	metrics *metrics.Metrics
+	health  *health.Checker
+	log     *slog.Logger
+}
+
+// Option настраивает сервер.
+type Option func(*Server)
+
+// WithNegativeTTL задаёт, сколько помнить, что заказа нет в БД. 0 — не помнить.
+func WithNegativeTTL(ttl time.Duration) Option {
+	return func(s *Server) {
+		s.notFound = nil
+		if ttl > 0 {
+			s.notFound = cache.NewNegative(ttl, defaultNegativeSize)
+		}
+	}
+}
+
+// WithIdempotency включает поддержку заголовка Idempotency-Key в приёме заказов.
+func WithIdempotency(st repo.IdempotencyStorage) Option {
+	return func(s *Server) {
+		s.idempotency = st
+	}
+}
+
+// WithHistory включает эндпоинты истории версий заказа:
+// /order/{id}/history, /order/{id}/history/{version} и /order/{id}/history/diff.
+func WithHistory(st repo.VersionStorage) Option {
+	return func(s *Server) {
+		s.history = st
+	}
+}
+
+// WithStatus включает GET /order/{id}/status.
+func WithStatus(st repo.StatusStorage) Option {
+	return func(s *Server) {
+		s.statuses = st
+	}
+}
+
+// WithRules включает проверку бизнес-правилами заказов, принятых через POST /orders
+// и POST /orders:batch, — тех же, что и в консьюмере.
+func WithRules(e *validation.Engine) Option {
+	return func(s *Server) {
+		s.rules = e
+	}
+}
+
+// WithFreshness задаёт свежесть копий, принятых через POST /orders и /orders:batch,
+// по тому же правилу, что у консьюмера (KAFKA_FRESHNESS): kafka_time — время приёма запроса,
+// payment_dt — payment.payment_dt, header — заголовок header в запросе.
+// Копия старее сохранённой не пишется. По умолчанию свежесть не задаётся
+// и запись через HTTP применяется всегда.
+func WithFreshness(mode ingest.Freshness, header string) Option {
+	return func(s *Server) {
+		s.freshness, s.freshnessHeader = mode, header
+		if s.freshnessHeader == "" {
+			s.freshnessHeader = ingest.DefaultFreshnessHeader
+		}
+	}
+}
+
+// WithWebFS задаёт статику UI вместо встроенной (например, os.DirFS("web") при разработке).
+func WithWebFS(fsys fs.FS) Option {
+	return func(s *Server) {
+		s.web = fsys
+	}
+}
+
+// WithMetrics включает метрики HTTP-запросов и эндпоинт /metrics.
+func WithMetrics(m *metrics.Metrics) Option {
+	return func(s *Server) {
+		s.metrics = m
+	}
+}
+
+// WithHealth включает /healthz и /readyz.
+func WithHealth(h *health.Checker) Option {
+	return func(s *Server) {
+		s.health = h
+	}
+}
+
+// WithLogger задаёт логгер сервера. По умолчанию slog.Default().
+func WithLogger(l *slog.Logger) Option {
+	return func(s *Server) {
+		s.log = l
+	}
 }
 
 // New создаёт новый http-сервер.
-func New(c cache.OrderCache, r repo.OrdersStorage) *Server {
+func New(c cache.OrderCache, r repo.OrdersStorage, opts ...Option) *Server {
 	s := &Server{
-		cache: c,
-		repo:  r,
-		mux:   chi.NewRouter(),
+		cache:    c,
+		repo:     r,
+		mux:      chi.NewRouter(),
+		notFound: cache.NewNegative(defaultNegativeTTL, defaultNegativeSize),
+		web:      web.FS,
+		log:      slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
 	s.routes()
 	return s
@@ -32,46 +170,158 @@ func New(c cache.OrderCache, r repo.OrdersStorage) *Server {
 
 // routes настраивает хендлеры.
 func (s *Server) routes() {
+	s.mux.Use(traceRequest, requestID, s.accessLog)
+	if s.metrics != nil {
+		s.mux.Use(s.metrics.Middleware)
+		s.mux.Handle("/metrics", s.metrics.Handler())
+	}
+	if s.health != nil {
+		s.mux.Method(http.MethodGet, "/healthz", s.health.LiveHandler())
+		s.mux.Method(http.MethodGet, "/readyz", s.health.ReadyHandler())
+	}
+	s.mux.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "route not found", nil)
+	})
+	s.mux.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed", nil)
+	})
+
 	s.mux.Get("/", s.handleIndex)
 	s.mux.Get("/order/{id}", s.handleGetOrder)
+	if s.history != nil {
+		s.mux.Get("/order/{id}/history", s.handleHistory)
+		s.mux.Get("/order/{id}/history/diff", s.handleDiff)
+		s.mux.Get("/order/{id}/history/{version}", s.handleVersion)
+	}
+	if s.statuses != nil {
+		s.mux.Get("/order/{id}/status", s.handleStatus)
+	}
+	s.mux.Get("/orders", s.handleSearchOrders)
+	s.mux.Post("/orders", s.handleIngest(maxOrderBody, s.ingestOrder))
+	s.mux.Post("/orders:batch", s.handleIngest(maxBatchBody, s.ingestBatch))
+	s.mux.Get("/orders/by-track/{track}", s.handleGetOrderByTrack)
+	s.mux.Get("/orders/by-transaction/{tx}", s.handleGetOrderByTransaction)
 }
 
 // handleIndex отдаёт простую html страницу.
 func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
-	http.ServeFile(w, r, "web/index.html")
+	http.ServeFileFS(w, r, s.web, "index.html")
+}
+
+// orderLookup описывает один из способов найти заказ: по order_uid, треку или транзакции.
+type orderLookup struct {
+	// kind отделяет ключи разных способов в singleflight и негативном кэше
+	kind      string
+	fromCache func(key string) (models.Order, bool)
+	fromRepo  func(ctx context.Context, key string) (models.Order, error)
 }
 
 // handleGetOrder ищет заказ по order_uid.
 func (s *Server) handleGetOrder(w http.ResponseWriter, r *http.Request) {
-	id := chi.URLParam(r, "id")
-	if id == "" {
-		http.Error(w, "empty id", http.StatusBadRequest)
+	s.serveOrder(w, r, chi.URLParam(r, "id"), orderLookup{
+		kind:      "id",
+		fromCache: s.cache.Get,
+		fromRepo:  s.repo.GetOrder,
+	})
+}
+
+// handleGetOrderByTrack ищет заказ по track_number.
+func (s *Server) handleGetOrderByTrack(w http.ResponseWriter, r *http.Request) {
+	s.serveOrder(w, r, chi.URLParam(r, "track"), orderLookup{
+		kind:      "track",
+		fromCache: s.cache.GetByTrack,
+		fromRepo:  s.repo.GetOrderByTrack,
+	})
+}
+
+// handleGetOrderByTransaction ищет заказ по payment.transaction.
+func (s *Server) handleGetOrderByTransaction(w http.ResponseWriter, r *http.Request) {
+	s.serveOrder(w, r, chi.URLParam(r, "tx"), orderLookup{
+		kind:      "tx",
+		fromCache: s.cache.GetByTransaction,
+		fromRepo:  s.repo.GetOrderByTransaction,
+	})
+}
+
+// serveOrder ищет заказ сначала в кэше, затем в БД, и отдаёт его в JSON.
+func (s *Server) serveOrder(w http.ResponseWriter, r *http.Request, key string, l orderLookup) {
+	if key == "" {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "empty "+l.kind, nil)
 		return
 	}
 
 	// сначала ищем в кэше
-	if o, ok := s.cache.Get(id); ok {
+	if o, ok := l.fromCache(key); ok {
 		writeJSON(w, o)
 		return
 	}
 
 	// если в кэше нет, то идём в БД
-	o, err := s.repo.GetOrder(r.Context(), id)
+	o, err := s.loadOrder(r.Context(), key, l)
 	if err != nil {
-		log.Printf("get order %s error: %v", id, err)
-		http.Error(w, "order not found", http.StatusNotFound)
+		s.writeStoreError(w, r, "get order by "+l.kind, err, "key", key)
 		return
 	}
 
 	writeJSON(w, o)
 }
 
+// loadOrder достаёт заказ из БД при промахе кэша и кладёт его в кэш, если там
+// всё ещё пусто: пока шёл запрос, консьюмер мог положить более свежую копию.
+// Параллельные запросы одного ключа делят один поход в БД, а ключи, которых нет в БД,
+// на короткое время запоминаются, чтобы повторные 404 не нагружали базу.
+func (s *Server) loadOrder(ctx context.Context, key string, l orderLookup) (models.Order, error) {
+	flightKey := l.kind + ":" + key
+	if s.notFound != nil && s.notFound.Has(flightKey) {
+		return models.Order{}, errOrderNotFound
+	}
+
+	ch := s.lookups.DoChan(flightKey, func() (any, error) {
+		// запрос общий для всех ждущих, поэтому отмена одного клиента не должна его прерывать
+		dbCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), dbLookupTimeout)
+		defer cancel()
+
+		o, err := l.fromRepo(dbCtx, key)
+		if err != nil {
+			if errors.Is(err, repo.ErrNotFound) {
+				if s.notFound != nil {
+					s.notFound.Add(flightKey)
+				}
+				return nil, errOrderNotFound
+			}
+			return nil, err
+		}
+
+		s.cache.SetIfAbsent(o)
+		return o, nil
+	})
+
+	select {
+	case <-ctx.Done():
+		return models.Order{}, ctx.Err()
+	case res := <-ch:
+		if res.Err != nil {
+			return models.Order{}, res.Err
+		}
+		return res.Val.(models.Order), nil
+	}
+}
+
 // writeJSON возвращает объект в JSON с отступами.
 func writeJSON(w http.ResponseWriter, v any) {
+	writeJSONStatus(w, http.StatusOK, v)
+}
+
+// writeJSONStatus возвращает объект в JSON с заданным кодом ответа.
+func writeJSONStatus(w http.ResponseWriter, status int, v any) {
+	writeRawJSON(w, status, encodeJSON(v))
+}
+
+// writeRawJSON отдаёт уже сериализованный JSON.
+func writeRawJSON(w http.ResponseWriter, status int, b []byte) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	enc := json.NewEncoder(w)
-	enc.SetIndent("", "  ")
-	_ = enc.Encode(v)
+	w.WriteHeader(status)
+	_, _ = w.Write(b)
 }
 
 // Handler возвращает объект http.Handler для запуска сервера.
@@ -1,14 +1,33 @@
 package httpserver
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/fstest"
 	"time"
 
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/health"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/ingest"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/logging"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/metrics"
 	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/models"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/repo"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/validation"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 //МОКИ
@@ -21,30 +40,219 @@ func (f *fakeCache) Get(id string) (models.Order, bool) {
 	o, ok := f.m[id]
 	return o, ok
 }
+func (f *fakeCache) GetByTrack(track string) (models.Order, bool) {
+	for _, o := range f.m {
+		if o.TrackNumber == track {
+			return o, true
+		}
+	}
+	return models.Order{}, false
+}
+func (f *fakeCache) GetByTransaction(tx string) (models.Order, bool) {
+	for _, o := range f.m {
+		if o.Payment.Transaction == tx {
+			return o, true
+		}
+	}
+	return models.Order{}, false
+}
 func (f *fakeCache) Set(o models.Order)     { f.m[o.OrderUID] = o }
 func (f *fakeCache) Load(os []models.Order) {}
 func (f *fakeCache) Size() int              { return len(f.m) }
+func (f *fakeCache) SetIfAbsent(o models.Order) bool {
+	if _, ok := f.m[o.OrderUID]; ok {
+		return false
+	}
+	f.m[o.OrderUID] = o
+	return true
+}
+
+type safeCache struct {
+	fakeCache
+	mu sync.Mutex
+}
+
+func (s *safeCache) Get(id string) (models.Order, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fakeCache.Get(id)
+}
+func (s *safeCache) Set(o models.Order) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fakeCache.Set(o)
+}
+func (s *safeCache) SetIfAbsent(o models.Order) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fakeCache.SetIfAbsent(o)
+}
 
 type fakeRepo struct {
-	data map[string]models.Order
+	data   map[string]models.Order
+	filter repo.OrderFilter
+	calls  atomic.Int32
+	writes atomic.Int32
+	srcs   []repo.Source
+	gate   chan struct{} // если задан, GetOrder ждёт, пока его закроют
+	err    error         // если задана, GetOrder возвращает её
+	// writeGate — если задан, InsertOrUpdateOrder ждёт, пока его закроют
+	writeGate chan struct{}
+	// failing — ошибки записи заказов по order_uid; пачка с таким заказом не пишется целиком
+	failing map[string]error
+	// storedAt — свежесть сохранённых копий: запись со свежестью старее считается устаревшей
+	storedAt map[string]time.Time
 }
 
-func (f *fakeRepo) InsertOrUpdateOrder(ctx context.Context, o models.Order) error {
+// isStale повторяет проверку свежести repo: нулевая свежесть применяется всегда.
+func (f *fakeRepo) isStale(o models.Order, src repo.Source) bool {
+	return !src.At.IsZero() && src.At.Before(f.storedAt[o.OrderUID])
+}
+
+func (f *fakeRepo) InsertOrUpdateOrder(ctx context.Context, o models.Order, src repo.Source) error {
+	f.writes.Add(1)
+	if f.writeGate != nil {
+		<-f.writeGate
+	}
+	if err := f.failing[o.OrderUID]; err != nil {
+		return err
+	}
+	if f.isStale(o, src) {
+		return fmt.Errorf("order %s: %w", o.OrderUID, repo.ErrStale)
+	}
 	f.data[o.OrderUID] = o
+	f.srcs = append(f.srcs, src)
 	return nil
 }
+func (f *fakeRepo) InsertOrUpdateOrders(ctx context.Context, writes []repo.OrderWrite) ([]int, error) {
+	f.writes.Add(1)
+	for _, w := range writes {
+		if err := f.failing[w.Order.OrderUID]; err != nil {
+			return nil, err
+		}
+	}
+	var stale []int
+	for i, w := range writes {
+		if f.isStale(w.Order, w.Source) {
+			stale = append(stale, i)
+			continue
+		}
+		f.data[w.Order.OrderUID] = w.Order
+		f.srcs = append(f.srcs, w.Source)
+	}
+	return stale, nil
+}
 func (f *fakeRepo) LoadAllOrders(ctx context.Context, limit int) ([]models.Order, error) {
 	return nil, nil
 }
+func (f *fakeRepo) StreamOrders(ctx context.Context, fn func(models.Order) error) error {
+	return nil
+}
 func (f *fakeRepo) GetOrder(ctx context.Context, id string) (models.Order, error) {
+	f.calls.Add(1)
+	if f.gate != nil {
+		<-f.gate
+	}
+	if f.err != nil {
+		return models.Order{}, f.err
+	}
 	o, ok := f.data[id]
 	if !ok {
-		return models.Order{}, errors.New("not found")
+		return models.Order{}, repo.ErrNotFound
 	}
 	return o, nil
 }
+func (f *fakeRepo) GetOrderByTrack(ctx context.Context, track string) (models.Order, error) {
+	f.calls.Add(1)
+	for _, o := range f.data {
+		if o.TrackNumber == track {
+			return o, nil
+		}
+	}
+	return models.Order{}, repo.ErrNotFound
+}
+func (f *fakeRepo) GetOrderByTransaction(ctx context.Context, tx string) (models.Order, error) {
+	f.calls.Add(1)
+	for _, o := range f.data {
+		if o.Payment.Transaction == tx {
+			return o, nil
+		}
+	}
+	return models.Order{}, repo.ErrNotFound
+}
+func (f *fakeRepo) SearchOrders(ctx context.Context, flt repo.OrderFilter) (repo.SearchPage, error) {
+	f.filter = flt
+	page := repo.SearchPage{}
+	for _, o := range f.data {
+		page.Orders = append(page.Orders, o)
+	}
+	if len(page.Orders) > 0 {
+		last := page.Orders[len(page.Orders)-1]
+		page.Next = &repo.Cursor{DateCreated: last.DateCreated, OrderUID: last.OrderUID}
+	}
+	return page, nil
+}
 func (f *fakeRepo) InsertTestOrder(ctx context.Context) error { return nil }
 
+// fakeHistory хранит версии заказов в памяти, версия 0 — последняя.
+type fakeHistory struct {
+	versions map[string][]models.OrderVersion
+}
+
+func (f *fakeHistory) ListOrderVersions(ctx context.Context, id string) ([]models.OrderVersion, error) {
+	vs := f.versions[id]
+	if len(vs) == 0 {
+		return nil, repo.ErrNotFound
+	}
+	out := make([]models.OrderVersion, len(vs))
+	for i, v := range vs {
+		v.Order = nil
+		out[i] = v
+	}
+	return out, nil
+}
+func (f *fakeHistory) GetOrderVersion(ctx context.Context, id string, version int) (models.OrderVersion, error) {
+	vs := f.versions[id]
+	if version == 0 && len(vs) > 0 {
+		return vs[len(vs)-1], nil
+	}
+	for _, v := range vs {
+		if v.Version == version {
+			return v, nil
+		}
+	}
+	return models.OrderVersion{}, repo.ErrNotFound
+}
+
+// fakeIdempotency занимает ключ атомарно, как INSERT ... ON CONFLICT в репозитории.
+type fakeIdempotency struct {
+	mu sync.Mutex
+	m  map[string]models.IdempotencyRecord
+}
+
+func (f *fakeIdempotency) ReserveIdempotency(ctx context.Context, key, hash string) (models.IdempotencyRecord, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if rec, ok := f.m[key]; ok {
+		return rec, false, nil
+	}
+	rec := models.IdempotencyRecord{Key: key, RequestHash: hash}
+	f.m[key] = rec
+	return rec, true, nil
+}
+func (f *fakeIdempotency) CompleteIdempotency(ctx context.Context, rec models.IdempotencyRecord) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.m[rec.Key] = rec
+	return nil
+}
+func (f *fakeIdempotency) ReleaseIdempotency(ctx context.Context, key, hash string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.m, key)
+	return nil
+}
+
 func TestGetOrderFromCache(t *testing.T) {
 	o := minimalOrder("id1")
 
@@ -99,6 +307,303 @@ func TestGetOrderNotFound(t *testing.T) {
 	}
 }
 
+func TestGetOrderPopulatesCache(t *testing.T) {
+	c := &fakeCache{m: map[string]models.Order{}}
+	r := &fakeRepo{data: map[string]models.Order{"id3": minimalOrder("id3")}}
+
+	s := New(c, r)
+
+	for i := 0; i < 3; i++ {
+		rr := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/order/id3", nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("unexpected status: %d", rr.Code)
+		}
+	}
+
+	if _, ok := c.m["id3"]; !ok {
+		t.Fatalf("order should be cached after db fallback")
+	}
+	if n := r.calls.Load(); n != 1 {
+		t.Fatalf("expected 1 db call got %d", n)
+	}
+}
+
+func TestGetOrderKeepsFresherCachedCopy(t *testing.T) {
+	c := &safeCache{fakeCache: fakeCache{m: map[string]models.Order{}}}
+	stale := minimalOrder("id5")
+	r := &fakeRepo{data: map[string]models.Order{"id5": stale}, gate: make(chan struct{})}
+
+	s := New(c, r)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.Handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/order/id5", nil))
+	}()
+
+	// пока чтение из БД висит, консьюмер кладёт в кэш более свежую копию
+	for r.calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	fresh := stale
+	fresh.TrackNumber = "FRESHTRACK"
+	c.Set(fresh)
+	close(r.gate)
+	<-done
+
+	if got, _ := c.Get("id5"); got.TrackNumber != "FRESHTRACK" {
+		t.Fatalf("db read must not overwrite fresher cached copy, got track %q", got.TrackNumber)
+	}
+}
+
+func TestGetOrderCoalescesConcurrentMisses(t *testing.T) {
+	c := &safeCache{fakeCache: fakeCache{m: map[string]models.Order{}}}
+	r := &fakeRepo{data: map[string]models.Order{"id4": minimalOrder("id4")}, gate: make(chan struct{})}
+
+	s := New(c, r)
+
+	var wg sync.WaitGroup
+	codes := make([]int, 10)
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rr := httptest.NewRecorder()
+			s.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/order/id4", nil))
+			codes[i] = rr.Code
+		}(i)
+	}
+
+	// даём всем запросам дойти до ожидания БД
+	time.Sleep(50 * time.Millisecond)
+	close(r.gate)
+	wg.Wait()
+
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Fatalf("request %d: unexpected status %d", i, code)
+		}
+	}
+	if n := r.calls.Load(); n != 1 {
+		t.Fatalf("expected 1 db call got %d", n)
+	}
+}
+
+func TestGetOrderNegativeCache(t *testing.T) {
+	c := &fakeCache{m: map[string]models.Order{}}
+	r := &fakeRepo{data: map[string]models.Order{}}
+
+	s := New(c, r)
+
+	for i := 0; i < 3; i++ {
+		rr := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/order/missing", nil))
+		if rr.Code != http.StatusNotFound {
+			t.Fatalf("expected 404 got %d", rr.Code)
+		}
+	}
+	if n := r.calls.Load(); n != 1 {
+		t.Fatalf("expected 1 db call got %d", n)
+	}
+
+	// без негативного кэша каждый запрос идёт в БД
+	s = New(c, r, WithNegativeTTL(0))
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/order/missing", nil))
+	if n := r.calls.Load(); n != 2 {
+		t.Fatalf("expected 2 db calls got %d", n)
+	}
+}
+
+func TestGetOrderErrorStatus(t *testing.T) {
+	cases := []struct {
+		err  error
+		code int
+		body string
+	}{
+		{repo.ErrNotFound, http.StatusNotFound, codeNotFound},
+		{fmt.Errorf("%w: %w", repo.ErrUnavailable, context.DeadlineExceeded), http.StatusServiceUnavailable, codeUnavailable},
+		{errors.New("boom"), http.StatusInternalServerError, codeInternal},
+	}
+
+	for _, tc := range cases {
+		fr := &fakeRepo{data: map[string]models.Order{}, err: tc.err}
+		s := New(&fakeCache{m: map[string]models.Order{}}, fr, WithNegativeTTL(0))
+
+		rr := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/order/x", nil))
+		if rr.Code != tc.code {
+			t.Fatalf("%v: expected %d got %d", tc.err, tc.code, rr.Code)
+		}
+
+		var resp apiError
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Code != tc.body || resp.Message == "" {
+			t.Fatalf("%v: unexpected error body %+v", tc.err, resp)
+		}
+		if resp.RequestID == "" || resp.RequestID != rr.Header().Get(headerRequestID) {
+			t.Fatalf("%v: request id %q does not match header %q", tc.err, resp.RequestID, rr.Header().Get(headerRequestID))
+		}
+	}
+}
+
+func TestRequestIDInLogsAndHeaders(t *testing.T) {
+	var buf bytes.Buffer
+	lg, err := logging.New(&buf, "info", "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fr := &fakeRepo{data: map[string]models.Order{}, err: errors.New("boom")}
+	s := New(&fakeCache{m: map[string]models.Order{}}, fr, WithNegativeTTL(0), WithLogger(lg))
+
+	req := httptest.NewRequest(http.MethodGet, "/order/x", nil)
+	req.Header.Set(headerRequestID, "lb-123")
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, req)
+
+	if got := rr.Header().Get(headerRequestID); got != "lb-123" {
+		t.Fatalf("expected incoming request id in response, got %q", got)
+	}
+
+	// ошибка хранилища и строка access-лога, обе с идентификатором запроса
+	var msgs []string
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var rec map[string]any
+		if err := dec.Decode(&rec); err != nil {
+			t.Fatal(err)
+		}
+		if rec["request_id"] != "lb-123" {
+			t.Fatalf("log record without request id: %v", rec)
+		}
+		msgs = append(msgs, rec["msg"].(string))
+	}
+	if len(msgs) != 2 || msgs[0] != "get order by id failed" || msgs[1] != "http request" {
+		t.Fatalf("unexpected log records: %v", msgs)
+	}
+
+	// заголовок с управляющими символами заменяется сгенерированным идентификатором
+	req = httptest.NewRequest(http.MethodGet, "/order/x", nil)
+	req.Header.Set(headerRequestID, "bad\nid")
+	rr = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, req)
+	if got := rr.Header().Get(headerRequestID); len(got) != 32 {
+		t.Fatalf("expected generated request id, got %q", got)
+	}
+}
+
+func TestRequestSpan(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	fr := &fakeRepo{data: map[string]models.Order{}, err: errors.New("boom")}
+	s := New(&fakeCache{m: map[string]models.Order{}}, fr, WithNegativeTTL(0))
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodGet, "/order/x", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	s.Handler().ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := sr.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(spans))
+	}
+	sp := spans[0]
+	if sp.Name() != "GET /order/{id}" || sp.SpanContext().TraceID().String() != traceID {
+		t.Fatalf("unexpected span %q in trace %s", sp.Name(), sp.SpanContext().TraceID())
+	}
+	if sp.Status().Code != codes.Error {
+		t.Fatalf("5xx response should mark span as error, got %v", sp.Status())
+	}
+}
+
+func TestSearchOrders(t *testing.T) {
+	c := &fakeCache{m: map[string]models.Order{}}
+	r := &fakeRepo{data: map[string]models.Order{"id5": minimalOrder("id5")}}
+
+	s := New(c, r)
+
+	cur := repo.Cursor{DateCreated: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), OrderUID: "prev"}
+	url := "/orders?customer_id=c&provider=wbpay&brand=b&nm_id=7&created_from=2024-01-01&sort=date_asc&limit=5&cursor=" + cur.Encode()
+
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, url, nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d %s", rr.Code, rr.Body.String())
+	}
+
+	f := r.filter
+	if f.CustomerID != "c" || f.Provider != "wbpay" || f.Brand != "b" || f.NmID != 7 || f.Limit != 5 || f.Sort != repo.SortOldest {
+		t.Fatalf("filter parsed wrong: %+v", f)
+	}
+	if !f.CreatedFrom.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("created_from parsed wrong: %v", f.CreatedFrom)
+	}
+	if f.After == nil || f.After.OrderUID != "prev" || !f.After.DateCreated.Equal(cur.DateCreated) {
+		t.Fatalf("cursor parsed wrong: %+v", f.After)
+	}
+
+	var resp searchResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("bad json: %v", err)
+	}
+	if len(resp.Orders) != 1 || resp.NextCursor == "" {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+}
+
+func TestSearchOrdersBadParams(t *testing.T) {
+	s := New(&fakeCache{m: map[string]models.Order{}}, &fakeRepo{data: map[string]models.Order{}})
+
+	for _, q := range []string{"limit=0", "limit=1000", "sort=random", "created_to=yesterday", "nm_id=x", "cursor=bm90LWpzb24"} {
+		rr := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/orders?"+q, nil))
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400 got %d", q, rr.Code)
+		}
+	}
+}
+
+func TestGetOrderByTrackAndTransaction(t *testing.T) {
+	cached := minimalOrder("id6")
+	cached.TrackNumber = "TRACK6"
+	stored := minimalOrder("id7")
+	stored.Payment.Transaction = "tx7"
+
+	c := &fakeCache{m: map[string]models.Order{"id6": cached}}
+	r := &fakeRepo{data: map[string]models.Order{"id7": stored}}
+
+	s := New(c, r)
+
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/orders/by-track/TRACK6", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("by-track: unexpected status %d", rr.Code)
+	}
+	if n := r.calls.Load(); n != 0 {
+		t.Fatalf("by-track should be served from cache, got %d db calls", n)
+	}
+
+	rr = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/orders/by-transaction/tx7", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("by-transaction: unexpected status %d", rr.Code)
+	}
+	if _, ok := c.m["id7"]; !ok {
+		t.Fatalf("order found by transaction should be cached")
+	}
+
+	rr = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/orders/by-transaction/unknown", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 got %d", rr.Code)
+	}
+}
+
 func minimalOrder(id string) models.Order {
 	now := time.Now()
 	return models.Order{
@@ -117,3 +622,529 @@ func minimalOrder(id string) models.Order {
 		Items:           []models.Item{{ChrtID: 1, TrackNumber: "t", Price: 1, Rid: "r", Name: "i", Sale: 1, Size: "0", TotalPrice: 1, NmID: 1, Brand: "b", Status: 1}},
 	}
 }
+
+// validOrderJSON — заказ, который проходит валидацию ingest.
+func validOrderJSON(t *testing.T, id string) []byte {
+	t.Helper()
+	o := minimalOrder(id)
+	o.Delivery.Phone = "+79000000000"
+	o.Delivery.Email = "e@example.com"
+	o.Delivery.Zip = "12345"
+	o.TrackNumber = "WBILTRACK"
+	o.Items[0].TrackNumber = "WBILTRACK"
+	o.Items[0].Rid = "rid-1"
+	b, err := json.Marshal(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func postOrders(s *Server, path string, body []byte, key string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	if key != "" {
+		req.Header.Set(headerIdempotencyKey, key)
+	}
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, req)
+	return rr
+}
+
+func TestIngestOrder(t *testing.T) {
+	fc := &fakeCache{m: map[string]models.Order{}}
+	fr := &fakeRepo{data: map[string]models.Order{}}
+	s := New(fc, fr)
+
+	rr := postOrders(s, "/orders", validOrderJSON(t, "order-new"), "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 got %d: %s", rr.Code, rr.Body.String())
+	}
+	if _, ok := fr.data["order-new"]; !ok {
+		t.Fatalf("order not stored in repo")
+	}
+	if _, ok := fc.m["order-new"]; !ok {
+		t.Fatalf("order not stored in cache")
+	}
+	want := repo.Source{Kind: repo.SourceHTTP, Ref: rr.Header().Get(headerRequestID)}
+	if len(fr.srcs) != 1 || fr.srcs[0] != want {
+		t.Fatalf("expected source %+v, got %+v", want, fr.srcs)
+	}
+}
+
+func TestIngestOrderValidationErrors(t *testing.T) {
+	fr := &fakeRepo{data: map[string]models.Order{}}
+	s := New(&fakeCache{m: map[string]models.Order{}}, fr)
+
+	o := minimalOrder("order-bad") // телефон и email не проходят валидацию
+	b, _ := json.Marshal(o)
+
+	rr := postOrders(s, "/orders", b, "")
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 got %d", rr.Code)
+	}
+
+	var resp struct {
+		Code    string              `json:"code"`
+		Details []ingest.FieldError `json:"details"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	fields := map[string]string{}
+	for _, f := range resp.Details {
+		fields[f.Field] = f.Tag
+	}
+	if resp.Code != codeValidationFailed || fields["delivery.phone"] != "e164" || fields["delivery.email"] != "email" {
+		t.Fatalf("unexpected field errors: %+v", resp)
+	}
+	if fr.writes.Load() != 0 {
+		t.Fatalf("invalid order must not be stored")
+	}
+
+	rr = postOrders(s, "/orders", []byte(`{"order_uid":`), "")
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for bad json got %d", rr.Code)
+	}
+}
+
+func TestIngestBusinessRules(t *testing.T) {
+	// в validOrderJSON amount = 1, а goods_total + delivery_cost = 2
+	strict, err := validation.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fr := &fakeRepo{data: map[string]models.Order{}}
+	s := New(&fakeCache{m: map[string]models.Order{}}, fr, WithRules(strict))
+
+	rr := postOrders(s, "/orders", validOrderJSON(t, "order-rules"), "")
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		Code    string              `json:"code"`
+		Details []ingest.FieldError `json:"details"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Code != codeRuleViolation || len(resp.Details) != 1 ||
+		resp.Details[0].Field != "payment.amount" || resp.Details[0].Tag != validation.RuleAmount {
+		t.Fatalf("unexpected rule errors: %+v", resp)
+	}
+	if fr.writes.Load() != 0 {
+		t.Fatalf("order violating rules must not be stored")
+	}
+
+	lenient, err := validation.New(validation.Warn(validation.RuleAmount))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = New(&fakeCache{m: map[string]models.Order{}}, fr, WithRules(lenient))
+
+	rr = postOrders(s, "/orders", validOrderJSON(t, "order-rules"), "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 got %d: %s", rr.Code, rr.Body.String())
+	}
+	var ok ingestResponse
+	if err := json.NewDecoder(rr.Body).Decode(&ok); err != nil {
+		t.Fatal(err)
+	}
+	if len(ok.Warnings) != 1 || ok.Warnings[0].Rule != validation.RuleAmount || ok.Warnings[0].Severity != validation.SeverityWarn {
+		t.Fatalf("expected amount warning, got %+v", ok.Warnings)
+	}
+}
+
+func TestIngestIdempotencyKey(t *testing.T) {
+	fr := &fakeRepo{data: map[string]models.Order{}}
+	s := New(&fakeCache{m: map[string]models.Order{}}, fr,
+		WithIdempotency(&fakeIdempotency{m: map[string]models.IdempotencyRecord{}}))
+
+	body := validOrderJSON(t, "order-idem")
+	first := postOrders(s, "/orders", body, "key-1")
+	second := postOrders(s, "/orders", body, "key-1")
+
+	if first.Code != http.StatusOK || second.Code != http.StatusOK {
+		t.Fatalf("expected 200/200 got %d/%d", first.Code, second.Code)
+	}
+	if fr.writes.Load() != 1 {
+		t.Fatalf("expected 1 write, got %d", fr.writes.Load())
+	}
+	if second.Header().Get(headerReplayed) != "true" || second.Body.String() != first.Body.String() {
+		t.Fatalf("expected replayed response, got %q", second.Body.String())
+	}
+
+	// тот же ключ с другим телом — конфликт
+	rr := postOrders(s, "/orders", validOrderJSON(t, "order-other"), "key-1")
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("expected 409 got %d", rr.Code)
+	}
+}
+
+func TestIngestIdempotencyConcurrent(t *testing.T) {
+	fr := &fakeRepo{data: map[string]models.Order{}, writeGate: make(chan struct{})}
+	s := New(&fakeCache{m: map[string]models.Order{}}, fr,
+		WithIdempotency(&fakeIdempotency{m: map[string]models.IdempotencyRecord{}}))
+	body := validOrderJSON(t, "order-idem")
+
+	first := make(chan *httptest.ResponseRecorder, 1)
+	go func() { first <- postOrders(s, "/orders", body, "key-1") }()
+
+	// первый запрос занял ключ и стоит на записи
+	deadline := time.Now().Add(2 * time.Second)
+	for fr.writes.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("first request did not reach the write")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// повтор, пока первый запрос выполняется, не пишет второй раз
+	rr := postOrders(s, "/orders", body, "key-1")
+	if rr.Code != http.StatusConflict || rr.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected 409 with Retry-After while in flight, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var apiErr apiError
+	if err := json.NewDecoder(rr.Body).Decode(&apiErr); err != nil || apiErr.Code != codeInProgress {
+		t.Fatalf("expected in_progress, got %+v %v", apiErr, err)
+	}
+
+	close(fr.writeGate)
+	if rr := <-first; rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 for the first request, got %d", rr.Code)
+	}
+
+	// после завершения повтор получает сохранённый ответ
+	rr = postOrders(s, "/orders", body, "key-1")
+	if rr.Code != http.StatusOK || rr.Header().Get(headerReplayed) != "true" {
+		t.Fatalf("expected replayed 200, got %d", rr.Code)
+	}
+	if fr.writes.Load() != 1 {
+		t.Fatalf("expected 1 write, got %d", fr.writes.Load())
+	}
+}
+
+func TestIngestBatch(t *testing.T) {
+	fc := &fakeCache{m: map[string]models.Order{}}
+	fr := &fakeRepo{data: map[string]models.Order{}}
+	s := New(fc, fr)
+
+	var body bytes.Buffer
+	body.Write(validOrderJSON(t, "order-a1"))
+	body.WriteString("\n\n{broken\n")
+	body.Write(validOrderJSON(t, "order-b1"))
+	body.WriteString("\n")
+
+	rr := postOrders(s, "/orders:batch", body.Bytes(), "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var resp batchResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Stored != 2 || resp.Rejected != 1 || len(resp.Results) != 3 {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+	if r := resp.Results[1]; r.Line != 3 || r.Status != statusRejected || r.Reason != "bad_json" {
+		t.Fatalf("unexpected rejected line: %+v", r)
+	}
+	if fr.writes.Load() != 1 || len(fr.data) != 2 || len(fc.m) != 2 {
+		t.Fatalf("expected one batch write of 2 orders")
+	}
+
+	rr = postOrders(s, "/orders:batch", []byte("{broken\n"), "")
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 when nothing is valid, got %d", rr.Code)
+	}
+}
+
+func TestIngestBatchFallsBackToSingleWrites(t *testing.T) {
+	fc := &fakeCache{m: map[string]models.Order{}}
+	fr := &fakeRepo{
+		data:    map[string]models.Order{},
+		failing: map[string]error{"order-bad": fmt.Errorf("insert: %w", repo.ErrConflict)},
+	}
+	s := New(fc, fr)
+
+	var body bytes.Buffer
+	for _, id := range []string{"order-a1", "order-bad", "order-b1"} {
+		body.Write(validOrderJSON(t, id))
+		body.WriteString("\n")
+	}
+
+	// постоянная ошибка одного заказа отклоняет только его строку
+	rr := postOrders(s, "/orders:batch", body.Bytes(), "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp batchResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Stored != 2 || resp.Rejected != 1 {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+	if r := resp.Results[1]; r.Line != 2 || r.Status != statusRejected || r.Reason != codeConflict {
+		t.Fatalf("unexpected failed line: %+v", r)
+	}
+	if len(fr.data) != 2 || len(fc.m) != 2 || fc.m["order-bad"].OrderUID != "" {
+		t.Fatalf("expected 2 stored and cached orders, got %d/%d", len(fr.data), len(fc.m))
+	}
+
+	// БД недоступна — по одному не пишется, пачка целиком получает 503
+	fr.failing["order-bad"] = repo.ErrUnavailable
+	writes := fr.writes.Load()
+	rr = postOrders(s, "/orders:batch", body.Bytes(), "")
+	if rr.Code != http.StatusServiceUnavailable || fr.writes.Load() != writes+1 {
+		t.Fatalf("expected 503 without single writes, got %d, %d writes", rr.Code, fr.writes.Load()-writes)
+	}
+}
+
+func TestIngestFreshness(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	var o models.Order
+	if err := json.Unmarshal(validOrderJSON(t, "order-f1"), &o); err != nil {
+		t.Fatal(err)
+	}
+	paymentDt := time.Unix(o.Payment.PaymentDt, 0)
+
+	tests := []struct {
+		name   string
+		opts   []Option
+		header string
+		check  func(got time.Time) bool
+	}{
+		{"default", nil, "", time.Time.IsZero},
+		{"none", []Option{WithFreshness(ingest.FreshnessNone, "")}, "", time.Time.IsZero},
+		{"kafka_time", []Option{WithFreshness(ingest.FreshnessKafkaTime, "")}, "", func(got time.Time) bool {
+			return time.Since(got) >= 0 && time.Since(got) < time.Minute
+		}},
+		{"payment_dt", []Option{WithFreshness(ingest.FreshnessPaymentDt, "")}, "", paymentDt.Equal},
+		{"header", []Option{WithFreshness(ingest.FreshnessHeader, "")}, at.Format(time.RFC3339), at.Equal},
+		{"header missing", []Option{WithFreshness(ingest.FreshnessHeader, "")}, "", time.Time.IsZero},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fr := &fakeRepo{data: map[string]models.Order{}}
+			s := New(&fakeCache{m: map[string]models.Order{}}, fr, tc.opts...)
+
+			req := httptest.NewRequest(http.MethodPost, "/orders", bytes.NewReader(validOrderJSON(t, "order-f1")))
+			if tc.header != "" {
+				req.Header.Set("X-Updated-At", tc.header)
+			}
+			rr := httptest.NewRecorder()
+			s.Handler().ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusOK || len(fr.srcs) != 1 {
+				t.Fatalf("expected stored order, got %d: %s", rr.Code, rr.Body.String())
+			}
+			if got := fr.srcs[0].At; !tc.check(got) {
+				t.Fatalf("unexpected source time %v", got)
+			}
+		})
+	}
+}
+
+func TestIngestStale(t *testing.T) {
+	// в БД копия из Kafka новее, чем payment_dt принимаемых заказов
+	newer := time.Now().Add(time.Hour)
+	fc := &fakeCache{m: map[string]models.Order{}}
+	fr := &fakeRepo{data: map[string]models.Order{}, storedAt: map[string]time.Time{"order-s1": newer}}
+	s := New(fc, fr, WithFreshness(ingest.FreshnessPaymentDt, ""))
+
+	rr := postOrders(s, "/orders", validOrderJSON(t, "order-s1"), "")
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("expected 409 got %d: %s", rr.Code, rr.Body.String())
+	}
+	var apiErr apiError
+	if err := json.NewDecoder(rr.Body).Decode(&apiErr); err != nil {
+		t.Fatal(err)
+	}
+	if apiErr.Code != codeStale {
+		t.Fatalf("expected code %q, got %+v", codeStale, apiErr)
+	}
+	if len(fr.data) != 0 || len(fc.m) != 0 {
+		t.Fatalf("stale order must not be stored or cached")
+	}
+
+	var body bytes.Buffer
+	body.Write(validOrderJSON(t, "order-s1"))
+	body.WriteString("\n")
+	body.Write(validOrderJSON(t, "order-s2"))
+	rr = postOrders(s, "/orders:batch", body.Bytes(), "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp batchResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Stored != 1 || resp.Stale != 1 || resp.Results[0].Status != statusStale || resp.Results[1].Status != statusStored {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+	if _, ok := fc.m["order-s1"]; ok {
+		t.Fatalf("stale order must not be cached")
+	}
+	if _, ok := fc.m["order-s2"]; !ok {
+		t.Fatalf("fresh order must be cached")
+	}
+}
+
+func TestIndexPage(t *testing.T) {
+	get := func(s *Server) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rr
+	}
+
+	// по умолчанию страница встроена в бинарник и не зависит от рабочей директории
+	rr := get(New(&fakeCache{m: map[string]models.Order{}}, &fakeRepo{}))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("expected embedded index, got %d %q", rr.Code, rr.Header().Get("Content-Type"))
+	}
+
+	dev := fstest.MapFS{"index.html": {Data: []byte("<html>dev</html>")}}
+	rr = get(New(&fakeCache{m: map[string]models.Order{}}, &fakeRepo{}, WithWebFS(dev)))
+	if rr.Code != http.StatusOK || rr.Body.String() != "<html>dev</html>" {
+		t.Fatalf("expected index from override, got %d %q", rr.Code, rr.Body.String())
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	s := New(&fakeCache{m: map[string]models.Order{}}, &fakeRepo{data: map[string]models.Order{}},
+		WithMetrics(metrics.New()))
+
+	s.Handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/order/x", nil))
+
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 got %d", rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), `route="/order/{id}"`) {
+		t.Fatalf("no http metrics for /order/{id}")
+	}
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	hc := health.New()
+	hc.Add("postgres", func(ctx context.Context) error { return errors.New("down") }, 0)
+	s := New(&fakeCache{m: map[string]models.Order{}}, &fakeRepo{data: map[string]models.Order{}}, WithHealth(hc))
+
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 from /healthz got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rr.Code != http.StatusServiceUnavailable || !strings.Contains(rr.Body.String(), `"down"`) {
+		t.Fatalf("expected 503 with component error, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestOrderHistory(t *testing.T) {
+	v1 := minimalOrder("h1")
+	v2, v3 := v1, v1
+	v2.Delivery.City = "Kazan"
+	v3.Delivery.City = "Kazan"
+	v3.Payment.Amount = 5
+	h := &fakeHistory{versions: map[string][]models.OrderVersion{"h1": {
+		{OrderUID: "h1", Version: 1, Source: repo.SourceKafka, SourceRef: "orders/0/1", Order: &v1},
+		{OrderUID: "h1", Version: 2, Source: repo.SourceHTTP, SourceRef: "req", Order: &v2},
+		{OrderUID: "h1", Version: 3, Source: repo.SourceAdmin, Order: &v3},
+	}}}
+	s := New(&fakeCache{m: map[string]models.Order{}}, &fakeRepo{data: map[string]models.Order{}}, WithHistory(h))
+
+	get := func(url string, status int, v any) {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, url, nil))
+		if rr.Code != status {
+			t.Fatalf("%s: expected %d got %d: %s", url, status, rr.Code, rr.Body.String())
+		}
+		if v != nil {
+			if err := json.Unmarshal(rr.Body.Bytes(), v); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	var hist historyResponse
+	get("/order/h1/history", http.StatusOK, &hist)
+	if len(hist.Versions) != 3 || hist.Versions[1].Source != repo.SourceHTTP || hist.Versions[0].Order != nil {
+		t.Fatalf("unexpected history %+v", hist)
+	}
+
+	var ver models.OrderVersion
+	get("/order/h1/history/2", http.StatusOK, &ver)
+	if ver.Version != 2 || ver.Order == nil || ver.Order.Delivery.City != "Kazan" {
+		t.Fatalf("unexpected version %+v", ver)
+	}
+
+	var diff diffResponse
+	get("/order/h1/history/diff", http.StatusOK, &diff)
+	if diff.From != 2 || diff.To != 3 || len(diff.Changes) != 1 || diff.Changes[0].Path != "/payment/amount" {
+		t.Fatalf("unexpected default diff %+v", diff)
+	}
+	get("/order/h1/history/diff?from=1&to=3", http.StatusOK, &diff)
+	if len(diff.Changes) != 2 || diff.Changes[0].Path != "/delivery/city" || diff.Changes[0].Old != "c" {
+		t.Fatalf("unexpected diff %+v", diff)
+	}
+
+	get("/order/h1/history/diff?to=1", http.StatusBadRequest, nil)
+	get("/order/h1/history/diff?from=x", http.StatusBadRequest, nil)
+	get("/order/h1/history/0", http.StatusBadRequest, nil)
+	get("/order/h1/history/9", http.StatusNotFound, nil)
+	get("/order/missing/history", http.StatusNotFound, nil)
+}
+
+type fakeStatuses struct {
+	m map[string]models.OrderStatus
+}
+
+func (f *fakeStatuses) ApplyStatus(ctx context.Context, ev models.StatusEvent, src repo.Source) (models.StatusChange, error) {
+	return models.StatusChange{}, nil
+}
+func (f *fakeStatuses) GetStatus(ctx context.Context, id string) (models.OrderStatus, error) {
+	st, ok := f.m[id]
+	if !ok {
+		return models.OrderStatus{}, repo.ErrNotFound
+	}
+	return st, nil
+}
+
+func TestOrderStatus(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	st := &fakeStatuses{m: map[string]models.OrderStatus{"s1": {
+		OrderUID: "s1",
+		Status:   "paid",
+		Timeline: []models.StatusChange{
+			{To: "created", At: at.Add(-time.Hour)},
+			{From: "created", To: "paid", Source: repo.SourceKafka, SourceRef: "orders/0/5", At: at},
+		},
+	}}}
+	s := New(&fakeCache{m: map[string]models.Order{}}, &fakeRepo{data: map[string]models.Order{}}, WithStatus(st))
+
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/order/s1/status", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 got %d: %s", rr.Code, rr.Body.String())
+	}
+	var got models.OrderStatus
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != "paid" || len(got.Timeline) != 2 || got.Timeline[1].From != "created" {
+		t.Fatalf("unexpected status %+v", got)
+	}
+
+	rr = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/order/missing/status", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 got %d", rr.Code)
+	}
+}
@@ -0,0 +1,18 @@
+package httpserver
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// handleStatus отдаёт текущий статус заказа, статусы позиций и историю переходов.
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	st, err := s.statuses.GetStatus(r.Context(), id)
+	if err != nil {
+		s.writeStoreError(w, r, "get order status", err, "order_uid", id)
+		return
+	}
+	writeJSON(w, st)
+}
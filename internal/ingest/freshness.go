@@ -0,0 +1,41 @@
+package ingest
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Freshness определяет, по чему судить, что копия заказа новее сохранённой.
+// Копия старее сохранённой не перезаписывает её (повторная доставка, перемешанные
+// партиции, запись через HTTP поверх более новой копии из Kafka).
+type Freshness string
+
+const (
+	// FreshnessKafkaTime — время сообщения Kafka (его ставит продюсер),
+	// для записи через HTTP — время приёма запроса.
+	FreshnessKafkaTime Freshness = "kafka_time"
+	// FreshnessPaymentDt — payment.payment_dt заказа.
+	FreshnessPaymentDt Freshness = "payment_dt"
+	// FreshnessHeader — заголовок сообщения или HTTP-запроса
+	// с RFC 3339 или unix-временем в миллисекундах, см. ParseTime.
+	FreshnessHeader Freshness = "header"
+	// FreshnessNone — проверка выключена, каждая копия перезаписывает заказ.
+	FreshnessNone Freshness = "none"
+)
+
+// DefaultFreshnessHeader — заголовок со временем изменения заказа по умолчанию.
+const DefaultFreshnessHeader = "x-updated-at"
+
+// ParseTime разбирает время изменения из заголовка: RFC 3339 или unix-время
+// в миллисекундах. Если значение не разбирается, возвращается нулевое время.
+func ParseTime(v string) time.Time {
+	v = strings.TrimSpace(v)
+	if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+		return t
+	}
+	if ms, err := strconv.ParseInt(v, 10, 64); err == nil && ms > 0 {
+		return time.UnixMilli(ms)
+	}
+	return time.Time{}
+}
@@ -0,0 +1,228 @@
+// Package ingest содержит общий для Kafka и HTTP разбор входящего заказа:
+// снятие BOM, парсинг JSON, валидацию по тегам модели и проверку бизнес-правил.
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"strings"
+
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/lifecycle"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/metrics"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/models"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/validation"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// Причины отклонения заказа.
+const (
+	ReasonBadJSON    = "bad_json"
+	ReasonValidation = "validation"
+	ReasonRules      = "rules" // нарушены бизнес-правила, см. пакет validation
+)
+
+// глобальный валидатор, чтобы не создавать его на каждое сообщение
+var validateStruct = newValidator()
+
+// newValidator создаёт валидатор, который называет поля так же, как в JSON.
+func newValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	// статус из lifecycle (для событий смены статуса)
+	_ = v.RegisterValidation("order_status", func(fl validator.FieldLevel) bool {
+		_, err := lifecycle.Parse(fl.Field().String())
+		return err == nil
+	})
+	return v
+}
+
+// RejectError — заказ не подходит по содержимому,
+// повторная обработка ничего не изменит.
+type RejectError struct {
+	Reason string
+	Err    error
+}
+
+func (e *RejectError) Error() string { return e.Reason + ": " + e.Err.Error() }
+
+func (e *RejectError) Unwrap() error { return e.Err }
+
+// FieldError — ошибка валидации одного поля.
+type FieldError struct {
+	Field   string `json:"field"` // путь в JSON, например delivery.phone или items[0].price
+	Tag     string `json:"tag"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+// Fields раскладывает ошибку валидации или нарушения бизнес-правил по полям.
+// Для нарушений правил Tag — имя правила. Для остальных ошибок возвращает nil.
+func (e *RejectError) Fields() []FieldError {
+	var rerr *validation.Error
+	if errors.As(e.Err, &rerr) {
+		out := make([]FieldError, 0, len(rerr.Violations))
+		for _, v := range rerr.Violations {
+			out = append(out, FieldError{Field: v.Field, Tag: v.Rule, Message: v.Message})
+		}
+		return out
+	}
+
+	var verrs validator.ValidationErrors
+	if !errors.As(e.Err, &verrs) {
+		return nil
+	}
+
+	out := make([]FieldError, 0, len(verrs))
+	for _, fe := range verrs {
+		// Namespace выглядит как Order.delivery.phone, корневой тип не нужен
+		_, field, _ := strings.Cut(fe.Namespace(), ".")
+		out = append(out, FieldError{
+			Field:   field,
+			Tag:     fe.Tag(),
+			Param:   fe.Param(),
+			Message: fieldMessage(fe),
+		})
+	}
+	return out
+}
+
+// fieldMessage формирует понятное описание ошибки поля.
+func fieldMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "min":
+		return "must be at least " + fe.Param()
+	case "max":
+		return "must be at most " + fe.Param()
+	case "len":
+		return "must have length " + fe.Param()
+	case "gt":
+		return "must be greater than " + fe.Param()
+	case "gte":
+		return "must be greater than or equal to " + fe.Param()
+	case "lte":
+		return "must be less than or equal to " + fe.Param()
+	case "e164":
+		return "must be a phone number in E.164 format"
+	case "email":
+		return "must be a valid email"
+	case "order_status":
+		return "must be one of " + statusList()
+	}
+	return fmt.Sprintf("failed on %q", fe.Tag())
+}
+
+// Decode убирает BOM, парсит и валидирует заказ.
+// Ошибки всегда *RejectError.
+func Decode(payload []byte) (models.Order, error) {
+	// убирается BOM, если есть
+	payload = bytes.TrimPrefix(payload, []byte{0xEF, 0xBB, 0xBF})
+
+	var o models.Order
+	if err := json.Unmarshal(payload, &o); err != nil {
+		return models.Order{}, &RejectError{Reason: ReasonBadJSON, Err: err}
+	}
+
+	if err := validate(&o); err != nil {
+		return models.Order{}, &RejectError{Reason: ReasonValidation, Err: err}
+	}
+
+	return o, nil
+}
+
+// validate проверяет обязательные поля заказа с помощью тегов в models
+// и пакета validator.v10.
+func validate(o *models.Order) error {
+	return validateStruct.Struct(o)
+}
+
+// CheckRules проверяет заказ бизнес-правилами. Нарушения с серьёзностью warn
+// возвращаются списком, с серьёзностью reject — ошибкой *RejectError.
+// Nil-движок ничего не проверяет.
+func CheckRules(rules *validation.Engine, o models.Order) ([]validation.Violation, error) {
+	warnings, err := rules.Check(o)
+	if err != nil {
+		return warnings, &RejectError{Reason: ReasonRules, Err: err}
+	}
+	return warnings, nil
+}
+
+// Checker разбирает заказы, проверяет их бизнес-правилами и пишет нарушения
+// в лог и метрики. Общий для консьюмера и HTTP-приёма. Nil Rules ничего не проверяет,
+// nil Metrics метрики не пишет, nil Log — slog.Default().
+type Checker struct {
+	Rules   *validation.Engine
+	Log     *slog.Logger
+	Metrics *metrics.Metrics
+}
+
+// Decode разбирает заказ и проверяет его бизнес-правилами. Нарушения reject сразу
+// попадают в лог и метрики, нарушения warn возвращаются: когда их учесть, решает
+// вызывающий (консьюмер — только после записи, чтобы повторы не считались дважды).
+func (c Checker) Decode(ctx context.Context, payload []byte) (models.Order, []validation.Violation, error) {
+	o, err := Decode(payload)
+	if err != nil {
+		return models.Order{}, nil, err
+	}
+	warnings, err := CheckRules(c.Rules, o)
+	if err != nil {
+		var rerr *validation.Error
+		if errors.As(err, &rerr) {
+			c.Report(ctx, o.OrderUID, rerr.Violations)
+		}
+		return models.Order{}, nil, err
+	}
+	return o, warnings, nil
+}
+
+// Report пишет нарушения бизнес-правил в лог и метрики.
+func (c Checker) Report(ctx context.Context, uid string, vs []validation.Violation) {
+	log := c.Log
+	if log == nil {
+		log = slog.Default()
+	}
+	for _, v := range vs {
+		c.Metrics.RuleViolation(v.Rule, string(v.Severity))
+		log.WarnContext(ctx, "business rule violated",
+			"order_uid", uid, "rule", v.Rule, "severity", v.Severity,
+			"field", v.Field, "violation", v.Message)
+	}
+}
+
+// DecodeStatus убирает BOM, парсит и валидирует событие смены статуса.
+// Ошибки всегда *RejectError.
+func DecodeStatus(payload []byte) (models.StatusEvent, error) {
+	payload = bytes.TrimPrefix(payload, []byte{0xEF, 0xBB, 0xBF})
+
+	var ev models.StatusEvent
+	if err := json.Unmarshal(payload, &ev); err != nil {
+		return models.StatusEvent{}, &RejectError{Reason: ReasonBadJSON, Err: err}
+	}
+	if err := validateStruct.Struct(&ev); err != nil {
+		return models.StatusEvent{}, &RejectError{Reason: ReasonValidation, Err: err}
+	}
+	return ev, nil
+}
+
+// statusList перечисляет статусы через запятую для сообщений об ошибке.
+func statusList() string {
+	all := lifecycle.All()
+	names := make([]string, len(all))
+	for i, st := range all {
+		names[i] = string(st)
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,161 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/logging"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/validation"
+)
+
+func TestDecodeStripsBOM(t *testing.T) {
+	payload := append([]byte{0xEF, 0xBB, 0xBF}, []byte(`{
+		"order_uid":"order123",
+		"track_number":"WBILMTESTTRACK",
+		"entry":"WBIL",
+		"delivery":{"name":"n","phone":"+79000000000","zip":"12345","city":"c","address":"a","region":"r","email":"e@e.com"},
+		"payment":{"transaction":"order123","request_id":"","currency":"USD","provider":"wbpay","amount":1,"payment_dt":1,"bank":"alpha","delivery_cost":1,"goods_total":1,"custom_fee":0},
+		"items":[{"chrt_id":1,"track_number":"WBILMTESTTRACK","price":1,"rid":"rid1","name":"i","sale":1,"size":"0","total_price":1,"nm_id":1,"brand":"b","status":1}],
+		"locale":"en",
+		"customer_id":"c",
+		"delivery_service":"d",
+		"shardkey":"s",
+		"sm_id":1,
+		"date_created":"2021-11-26T06:22:19Z",
+		"oof_shard":"o"
+	}`)...)
+
+	o, err := Decode(payload)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if o.OrderUID != "order123" {
+		t.Fatalf("wrong order: %+v", o)
+	}
+}
+
+func TestDecodeFieldErrors(t *testing.T) {
+	_, err := Decode([]byte(`{
+		"order_uid":"order123",
+		"track_number":"WBILMTESTTRACK",
+		"entry":"WBIL",
+		"delivery":{"name":"n","phone":"not-a-phone","zip":"12345","city":"c","address":"a","region":"r","email":"e@e.com"},
+		"payment":{"transaction":"order123","currency":"USDT","provider":"wbpay","amount":1,"payment_dt":1,"bank":"alpha","delivery_cost":1,"goods_total":1},
+		"items":[{"chrt_id":1,"track_number":"WBILMTESTTRACK","price":1,"rid":"rid1","name":"i","sale":1,"size":"0","total_price":1,"nm_id":1,"brand":"b","status":1}],
+		"locale":"en",
+		"customer_id":"c",
+		"delivery_service":"d",
+		"shardkey":"s",
+		"sm_id":1,
+		"date_created":"2021-11-26T06:22:19Z",
+		"oof_shard":"o"
+	}`))
+
+	var rej *RejectError
+	if !errors.As(err, &rej) || rej.Reason != ReasonValidation {
+		t.Fatalf("expected validation RejectError, got %v", err)
+	}
+
+	fields := map[string]string{}
+	for _, f := range rej.Fields() {
+		fields[f.Field] = f.Tag
+	}
+	if fields["delivery.phone"] != "e164" || fields["payment.currency"] != "len" || len(fields) != 2 {
+		t.Fatalf("unexpected field errors: %v", rej.Fields())
+	}
+}
+
+func TestDecodeBadJSON(t *testing.T) {
+	_, err := Decode([]byte(`{"order_uid": 123`))
+
+	var rej *RejectError
+	if !errors.As(err, &rej) || rej.Reason != ReasonBadJSON {
+		t.Fatalf("expected bad_json RejectError, got %v", err)
+	}
+	if rej.Fields() != nil {
+		t.Fatalf("bad json has no field errors")
+	}
+}
+
+func TestDecodeStatus(t *testing.T) {
+	ev, err := DecodeStatus([]byte(`{"order_uid":"order123","status":"paid","at":"2024-05-01T12:00:00Z"}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ev.OrderUID != "order123" || ev.Status != "paid" || ev.At.IsZero() {
+		t.Fatalf("wrong event: %+v", ev)
+	}
+
+	_, err = DecodeStatus([]byte(`{"order_uid":"order123","status":"lost"}`))
+	var rej *RejectError
+	if !errors.As(err, &rej) || rej.Reason != ReasonValidation {
+		t.Fatalf("expected validation error, got %v", err)
+	}
+	fields := rej.Fields()
+	if len(fields) != 1 || fields[0].Field != "status" || fields[0].Tag != "order_status" {
+		t.Fatalf("unexpected fields: %+v", fields)
+	}
+
+	if _, err := DecodeStatus([]byte(`{"order_uid":`)); !errors.As(err, &rej) || rej.Reason != ReasonBadJSON {
+		t.Fatalf("expected bad json, got %v", err)
+	}
+}
+
+func TestCheckerReportsRejectsOnly(t *testing.T) {
+	// amount = 1, а goods_total + delivery_cost = 2
+	payload := []byte(`{
+		"order_uid":"order123",
+		"track_number":"WBILMTESTTRACK",
+		"entry":"WBIL",
+		"delivery":{"name":"n","phone":"+79000000000","zip":"12345","city":"c","address":"a","region":"r","email":"e@e.com"},
+		"payment":{"transaction":"order123","currency":"USD","provider":"wbpay","amount":1,"payment_dt":1,"bank":"alpha","delivery_cost":1,"goods_total":1},
+		"items":[{"chrt_id":1,"track_number":"WBILMTESTTRACK","price":1,"rid":"rid1","name":"i","sale":1,"size":"0","total_price":1,"nm_id":1,"brand":"b","status":1}],
+		"locale":"en",
+		"customer_id":"c",
+		"delivery_service":"d",
+		"shardkey":"s",
+		"sm_id":1,
+		"date_created":"2021-11-26T06:22:19Z",
+		"oof_shard":"o"
+	}`)
+	violations := func(buf *bytes.Buffer) int {
+		return strings.Count(buf.String(), `"msg":"business rule violated"`)
+	}
+
+	var buf bytes.Buffer
+	lg, err := logging.New(&buf, "info", "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	strict, err := validation.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = Checker{Rules: strict, Log: lg}.Decode(context.Background(), payload)
+	var rej *RejectError
+	if !errors.As(err, &rej) || rej.Reason != ReasonRules {
+		t.Fatalf("expected rules rejection, got %v", err)
+	}
+	if got := violations(&buf); got != 1 {
+		t.Fatalf("reject should be reported once, got %d", got)
+	}
+
+	// warn возвращается и пишется только по Report
+	buf.Reset()
+	lenient, err := validation.New(validation.Warn(validation.RuleAmount))
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := Checker{Rules: lenient, Log: lg}
+	o, warnings, err := check.Decode(context.Background(), payload)
+	if err != nil || len(warnings) != 1 || violations(&buf) != 0 {
+		t.Fatalf("expected one unreported warning, got %v, %v, %d logs", warnings, err, violations(&buf))
+	}
+	check.Report(context.Background(), o.OrderUID, warnings)
+	if got := violations(&buf); got != 1 {
+		t.Fatalf("warning should be reported by Report, got %d", got)
+	}
+}
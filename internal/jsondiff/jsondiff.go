@@ -0,0 +1,139 @@
+// Package jsondiff сравнивает два JSON-документа и перечисляет отличия
+// в виде списка изменений с путями в формате JSON Pointer (RFC 6901).
+//
+// Результат предназначен для чтения человеком (история версий заказа),
+// а не для применения как JSON Patch: элементы массивов сравниваются по индексу,
+// перемещения не распознаются.
+package jsondiff
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Виды изменений.
+const (
+	OpAdd     = "add"
+	OpRemove  = "remove"
+	OpReplace = "replace"
+)
+
+// Change — одно отличие между документами.
+type Change struct {
+	Op   string `json:"op"`
+	Path string `json:"path"`
+	Old  any    `json:"old,omitempty"`
+	New  any    `json:"new,omitempty"`
+}
+
+// Diff возвращает отличия документа b от документа a.
+// Ключи объектов обходятся по алфавиту, поэтому порядок изменений стабилен.
+// Числа сравниваются по записи, без перевода во float64.
+func Diff(a, b []byte) ([]Change, error) {
+	va, err := decode(a)
+	if err != nil {
+		return nil, fmt.Errorf("jsondiff: left document: %w", err)
+	}
+	vb, err := decode(b)
+	if err != nil {
+		return nil, fmt.Errorf("jsondiff: right document: %w", err)
+	}
+
+	var out []Change
+	walk(&out, "", va, vb)
+	return out, nil
+}
+
+func decode(b []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// walk дописывает в out отличия b от a по пути path.
+func walk(out *[]Change, path string, a, b any) {
+	switch av := a.(type) {
+	case map[string]any:
+		if bv, ok := b.(map[string]any); ok {
+			walkObject(out, path, av, bv)
+			return
+		}
+	case []any:
+		if bv, ok := b.([]any); ok {
+			walkArray(out, path, av, bv)
+			return
+		}
+	default:
+		if equalScalar(a, b) {
+			return
+		}
+	}
+	*out = append(*out, Change{Op: OpReplace, Path: path, Old: a, New: b})
+}
+
+func walkObject(out *[]Change, path string, a, b map[string]any) {
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		p := path + "/" + escape(k)
+		av, inA := a[k]
+		bv, inB := b[k]
+		switch {
+		case !inB:
+			*out = append(*out, Change{Op: OpRemove, Path: p, Old: av})
+		case !inA:
+			*out = append(*out, Change{Op: OpAdd, Path: p, New: bv})
+		default:
+			walk(out, p, av, bv)
+		}
+	}
+}
+
+func walkArray(out *[]Change, path string, a, b []any) {
+	n := min(len(a), len(b))
+	for i := 0; i < n; i++ {
+		walk(out, path+"/"+strconv.Itoa(i), a[i], b[i])
+	}
+	for i := n; i < len(a); i++ {
+		*out = append(*out, Change{Op: OpRemove, Path: path + "/" + strconv.Itoa(i), Old: a[i]})
+	}
+	for i := n; i < len(b); i++ {
+		*out = append(*out, Change{Op: OpAdd, Path: path + "/" + strconv.Itoa(i), New: b[i]})
+	}
+}
+
+// equalScalar сравнивает строки, числа, bool и null.
+// Объект или массив против скаляра считаются разными.
+func equalScalar(a, b any) bool {
+	switch a.(type) {
+	case map[string]any, []any:
+		return false
+	}
+	switch b.(type) {
+	case map[string]any, []any:
+		return false
+	}
+	return a == b
+}
+
+// escape экранирует ключ объекта для JSON Pointer.
+func escape(k string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(k)
+}
@@ -0,0 +1,65 @@
+package jsondiff
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	a := []byte(`{
+		"order_uid": "o1",
+		"sm_id": 99,
+		"delivery": {"city": "Moscow", "zip": "101000"},
+		"payment": {"amount": 1817},
+		"items": [{"chrt_id": 1, "price": 10}, {"chrt_id": 2, "price": 20}],
+		"a/b": "x"
+	}`)
+	b := []byte(`{
+		"order_uid": "o1",
+		"sm_id": 99.0,
+		"delivery": {"city": "Kazan", "zip": "101000", "email": "a@b.c"},
+		"payment": null,
+		"items": [{"chrt_id": 1, "price": 15}],
+		"a/b": "x"
+	}`)
+
+	got, err := Diff(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Change{
+		{Op: OpReplace, Path: "/delivery/city", Old: "Moscow", New: "Kazan"},
+		{Op: OpAdd, Path: "/delivery/email", New: "a@b.c"},
+		{Op: OpReplace, Path: "/items/0/price", Old: json.Number("10"), New: json.Number("15")},
+		{Op: OpRemove, Path: "/items/1", Old: map[string]any{"chrt_id": json.Number("2"), "price": json.Number("20")}},
+		{Op: OpReplace, Path: "/payment", Old: map[string]any{"amount": json.Number("1817")}, New: nil},
+		{Op: OpReplace, Path: "/sm_id", Old: json.Number("99"), New: json.Number("99.0")},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("diff mismatch\n got: %#v\nwant: %#v", got, want)
+	}
+}
+
+func TestDiffEqualAndErrors(t *testing.T) {
+	got, err := Diff([]byte(`{"a":[1,{"b":true}],"c":"~/"}`), []byte(`{"c":"~/","a":[1,{"b":true}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Fatalf("expected no changes, got %v", got)
+	}
+
+	got, err = Diff([]byte(`{"x~y":1}`), []byte(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Path != "/x~0y" {
+		t.Fatalf("key should be escaped, got %v", got)
+	}
+
+	if _, err := Diff([]byte(`{`), []byte(`{}`)); err == nil {
+		t.Fatal("expected error for broken json")
+	}
+}
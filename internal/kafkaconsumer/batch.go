@@ -0,0 +1,156 @@
+package kafkaconsumer
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/logging"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/repo"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/tracing"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/validation"
+
+	kafka "github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// defaultBatchTimeout — сколько по умолчанию ждать добора пачки.
+const defaultBatchTimeout = 100 * time.Millisecond
+
+// handleBatch обрабатывает пачку. Битые сообщения уходят в DLQ, валидные заказы
+// пишутся одним InsertOrUpdateOrders с повторами при временных ошибках.
+// Если пачка не записалась из-за постоянной ошибки, сообщения обрабатываются
+// по одному, чтобы в DLQ попал только виноватый заказ.
+// События смены статуса применяются по одному после заказов пачки,
+// чтобы событие не обогнало свой заказ. Сообщения неизвестного типа
+// идут тем же путём и отклоняются.
+// Ошибка означает, что пачку коммитить нельзя.
+func (c *Consumer) handleBatch(ctx context.Context, msgs []kafka.Message) (err error) {
+	ctx, span := batchSpan(ctx, msgs)
+	defer func() { tracing.End(span, err) }()
+
+	writes := make([]repo.OrderWrite, 0, len(msgs))
+	valid := make([]kafka.Message, 0, len(msgs))
+	warnings := make([][]validation.Violation, 0, len(msgs))
+	var events []kafka.Message
+
+	for _, m := range msgs {
+		if messageType(m) != messageTypeOrder {
+			events = append(events, m)
+			continue
+		}
+		o, warns, err := c.checker().Decode(messageContext(ctx, m), m.Value)
+		if err != nil {
+			// сообщение уже разобрано и нарушения учтены: в DLQ сразу, без второго разбора
+			if err := c.rejectInvalid(ctx, m, err); err != nil {
+				return err
+			}
+			continue
+		}
+		writes = append(writes, repo.OrderWrite{Order: o, Source: c.source(m, o)})
+		valid = append(valid, m)
+		warnings = append(warnings, warns)
+	}
+
+	if err := c.writeBatch(ctx, writes, valid, warnings); err != nil {
+		return err
+	}
+	for _, m := range events {
+		if err := c.handleMessage(ctx, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rejectInvalid отправляет в DLQ сообщение пачки, которое не прошло разбор или проверки.
+func (c *Consumer) rejectInvalid(ctx context.Context, m kafka.Message, cause error) (err error) {
+	ctx, span := messageSpan(messageContext(ctx, m), m)
+	defer func() { tracing.End(span, err) }()
+
+	c.logger().WarnContext(ctx, "payload rejected", "error", cause)
+	return c.deadLetter(ctx, m, cause)
+}
+
+// writeBatch пишет заказы пачки, а при постоянной ошибке — по одному.
+// warnings — нарушения правил с серьёзностью warn для каждого заказа.
+func (c *Consumer) writeBatch(ctx context.Context, writes []repo.OrderWrite, valid []kafka.Message, warnings [][]validation.Violation) error {
+	if len(writes) == 0 {
+		return nil
+	}
+
+	bctx := logging.WithAttrs(ctx,
+		slog.Int("batch_size", len(writes)),
+		slog.Int64("first_offset", valid[0].Offset),
+		slog.Int64("last_offset", valid[len(valid)-1].Offset),
+	)
+	var stale []int
+	err := c.withRetry(bctx, func() (err error) {
+		stale, err = c.repo.InsertOrUpdateOrders(ctx, writes)
+		return err
+	})
+	if err == nil {
+		c.storeBatch(bctx, writes, valid, stale, warnings)
+		return nil
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	var giveUp *giveUpError
+	if errors.As(err, &giveUp) && c.cfg.GiveUp != GiveUpDeadLetter {
+		return err
+	}
+
+	c.logger().WarnContext(bctx, "batch write failed, falling back to single writes", "error", err)
+	for _, m := range valid {
+		if err := c.handleMessage(ctx, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storeBatch обновляет кэш записанной пачкой. Устаревшие копии в кэш не попадают:
+// там может лежать более свежая. Нарушения правил учитываются только для записанных заказов,
+// как и в processPayload.
+func (c *Consumer) storeBatch(ctx context.Context, writes []repo.OrderWrite, msgs []kafka.Message, stale []int, warnings [][]validation.Violation) {
+	skip := make(map[int]bool, len(stale))
+	for _, i := range stale {
+		skip[i] = true
+		c.logger().InfoContext(ctx, "stale update skipped",
+			"order_uid", writes[i].Order.OrderUID, "offset", msgs[i].Offset)
+	}
+	for i, w := range writes {
+		if !skip[i] {
+			c.cache.Set(w.Order)
+			c.checker().Report(ctx, w.Order.OrderUID, warnings[i])
+		}
+	}
+	c.metrics.MessagesStored(len(writes) - len(stale))
+	c.metrics.StaleSkipped(len(stale))
+	c.logger().InfoContext(ctx, "batch stored", "stale", len(stale))
+}
+
+// batchSpan начинает спан записи пачки. У сообщений пачки свои трассы,
+// поэтому они не родители, а ссылки (links) спана.
+func batchSpan(ctx context.Context, msgs []kafka.Message) (context.Context, trace.Span) {
+	links := make([]trace.Link, 0, len(msgs))
+	for _, m := range msgs {
+		if sc := trace.SpanContextFromContext(tracing.Extract(context.Background(), m)); sc.IsValid() {
+			links = append(links, trace.Link{SpanContext: sc})
+		}
+	}
+	return tracer.Start(ctx, msgs[0].Topic+" process batch",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithLinks(links...),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "kafka"),
+			attribute.String("messaging.operation.type", "process"),
+			attribute.String("messaging.destination.name", msgs[0].Topic),
+			attribute.Int("messaging.batch.message_count", len(msgs)),
+		),
+	)
+}
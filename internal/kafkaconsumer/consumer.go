@@ -2,27 +2,56 @@
 package kafkaconsumer
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
 
 	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/cache"
-	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/models"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/ingest"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/logging"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/metrics"
 	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/repo"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/tracing"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/validation"
 
-	"github.com/go-playground/validator/v10"
 	kafka "github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// глобальный валидатор, чтобы не создавать его на каждое сообщение
-var validateStruct = validator.New()
+// tracer пишет спаны обработки сообщений.
+var tracer = otel.Tracer("github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/kafkaconsumer")
 
 // Consumer обрабатывает сообщения.
 type Consumer struct {
-	reader *kafka.Reader
-	repo   repo.OrdersStorage
-	cache  cache.OrderCache
+	cfg      Config
+	reader   messageReader
+	dlq      messageWriter
+	rejected repo.RejectedStorage
+	repo     repo.OrdersStorage
+	statuses repo.StatusStorage // nil — события статусов отклоняются
+	cache    cache.OrderCache
+	rules    *validation.Engine // nil — бизнес-правила не проверяются
+	metrics  *metrics.Metrics   // nil — метрики не пишутся
+	log      *slog.Logger       // nil — slog.Default()
+
+	// commitMu упорядочивает коммиты из разных воркеров,
+	// чтобы оффсет партиции не откатился назад.
+	commitMu sync.Mutex
+	offsets  *offsetTracker
+
+	state runState
+}
+
+// messageReader — то, что нужно от kafka.Reader.
+type messageReader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
 }
 
 // Config задаёт параметры подключения к Kafka.
@@ -30,79 +59,278 @@ type Config struct {
 	Brokers []string
 	Topic   string
 	GroupID string
+
+	// DeadLetterTopic — топик для отклонённых сообщений.
+	// Если пустой, сообщения в DLQ не пересылаются.
+	DeadLetterTopic string
+
+	// MaxRetries — сколько раз повторять обработку при временной ошибке БД.
+	// 0 — повторять, пока не получится.
+	MaxRetries int
+	// RetryBackoff — задержка перед первым повтором, дальше она удваивается.
+	RetryBackoff time.Duration
+	// RetryMaxBackoff — верхняя граница задержки между повторами.
+	RetryMaxBackoff time.Duration
+	// GiveUp — что делать, когда MaxRetries исчерпаны. По умолчанию GiveUpStop.
+	GiveUp GiveUpPolicy
+
+	// BatchSize включает пакетный режим, если больше 1: сообщения копятся
+	// до BatchSize штук или BatchTimeout и пишутся в БД одним запросом.
+	BatchSize int
+	// BatchTimeout — сколько ждать добора пачки после первого сообщения.
+	BatchTimeout time.Duration
+
+	// Workers — сколько сообщений обрабатывать параллельно. По умолчанию 1.
+	Workers int
+	// DispatchBy — как раскладывать сообщения по воркерам. По умолчанию DispatchByPartition.
+	DispatchBy DispatchMode
+
+	// Freshness — по чему сравнивать копию заказа с сохранённой. По умолчанию FreshnessKafkaTime.
+	Freshness FreshnessMode
+	// FreshnessHeader — заголовок со временем изменения для FreshnessHeader.
+	FreshnessHeader string
+
+	// OrderWait — сколько событие смены статуса ждёт свой заказ, если пришло раньше него.
+	// Потом событие уходит в DLQ с причиной unknown_order. 0 — не ждать.
+	OrderWait time.Duration
+
+	// StallTimeout — сколько коммит может стоять при необработанных сообщениях,
+	// прежде чем Check сочтёт консьюмера застрявшим.
+	StallTimeout time.Duration
+
+	// Параметры kafka.Reader. Нулевые значения — умолчания kafka-go.
+
+	// StartOffset — с чего читать новой группе: kafka.FirstOffset или kafka.LastOffset.
+	// По умолчанию kafka.LastOffset.
+	StartOffset       int64
+	MinBytes          int
+	MaxBytes          int
+	MaxWait           time.Duration
+	QueueCapacity     int
+	SessionTimeout    time.Duration
+	HeartbeatInterval time.Duration
+	RebalanceTimeout  time.Duration
+}
+
+// Option настраивает консьюмера.
+type Option func(*Consumer)
+
+// WithRejectedStore включает сохранение отклонённых сообщений в БД.
+func WithRejectedStore(s repo.RejectedStorage) Option {
+	return func(c *Consumer) { c.rejected = s }
+}
+
+// WithStatusStore включает обработку событий смены статуса (message-type: status).
+func WithStatusStore(s repo.StatusStorage) Option {
+	return func(c *Consumer) { c.statuses = s }
+}
+
+// WithRules включает проверку заказов бизнес-правилами.
+func WithRules(e *validation.Engine) Option {
+	return func(c *Consumer) { c.rules = e }
+}
+
+// WithMetrics включает запись метрик консьюмера.
+func WithMetrics(m *metrics.Metrics) Option {
+	return func(c *Consumer) { c.metrics = m }
+}
+
+// WithLogger задаёт логгер консьюмера.
+func WithLogger(l *slog.Logger) Option {
+	return func(c *Consumer) { c.log = l }
 }
 
 // New создаёт консьюмера с ручным коммитом оффсетов.
-func New(cfg Config, r repo.OrdersStorage, c cache.OrderCache) *Consumer {
+func New(cfg Config, r repo.OrdersStorage, c cache.OrderCache, opts ...Option) *Consumer {
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = defaultRetryBackoff
+	}
+	if cfg.RetryMaxBackoff <= 0 {
+		cfg.RetryMaxBackoff = defaultRetryMaxBackoff
+	}
+	if cfg.GiveUp == "" {
+		cfg.GiveUp = GiveUpStop
+	}
+	if cfg.BatchTimeout <= 0 {
+		cfg.BatchTimeout = defaultBatchTimeout
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = 1
+	}
+	if cfg.DispatchBy == "" {
+		cfg.DispatchBy = DispatchByPartition
+	}
+	if cfg.Freshness == "" {
+		cfg.Freshness = FreshnessKafkaTime
+	}
+	if cfg.FreshnessHeader == "" {
+		cfg.FreshnessHeader = defaultFreshnessHeader
+	}
+	if cfg.StallTimeout <= 0 {
+		cfg.StallTimeout = defaultStallTimeout
+	}
+	if cfg.StartOffset == 0 {
+		cfg.StartOffset = kafka.LastOffset
+	}
+
 	rd := kafka.NewReader(kafka.ReaderConfig{
-		Brokers:        cfg.Brokers,
-		Topic:          cfg.Topic,
-		GroupID:        cfg.GroupID,
-		StartOffset:    kafka.LastOffset,
-		CommitInterval: 0,
+		Brokers:           cfg.Brokers,
+		Topic:             cfg.Topic,
+		GroupID:           cfg.GroupID,
+		StartOffset:       cfg.StartOffset,
+		CommitInterval:    0,
+		MinBytes:          cfg.MinBytes,
+		MaxBytes:          cfg.MaxBytes,
+		MaxWait:           cfg.MaxWait,
+		QueueCapacity:     cfg.QueueCapacity,
+		SessionTimeout:    cfg.SessionTimeout,
+		HeartbeatInterval: cfg.HeartbeatInterval,
+		RebalanceTimeout:  cfg.RebalanceTimeout,
 	})
-	return &Consumer{reader: rd, repo: r, cache: c}
-}
 
-func (c *Consumer) Close() error { return c.reader.Close() }
+	cons := &Consumer{cfg: cfg, reader: rd, repo: r, cache: c}
+
+	if cfg.DeadLetterTopic != "" {
+		cons.dlq = &kafka.Writer{
+			Addr:         kafka.TCP(cfg.Brokers...),
+			Topic:        cfg.DeadLetterTopic,
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+		}
+	}
 
-// validate проверяет обязательные поля заказа с помощью тегов в models
-// и пакета validator.v10.
-func validate(o *models.Order) error {
-	return validateStruct.Struct(o)
+	for _, opt := range opts {
+		opt(cons)
+	}
+	return cons
 }
 
-// processPayload парсит, валидирует и сохраняет заказ.
-// Вынесено отдельно, чтобы можно было нормально тестить без Kafka.
-func (c *Consumer) processPayload(ctx context.Context, payload []byte, offset int64) error {
-	// убирается BOM, если есть
-	payload = bytes.TrimPrefix(payload, []byte{0xEF, 0xBB, 0xBF})
+// Close закрывает reader и writer DLQ.
+func (c *Consumer) Close() error {
+	err := c.reader.Close()
+	if c.dlq != nil {
+		err = errors.Join(err, c.dlq.Close())
+	}
+	return err
+}
 
-	var o models.Order
-	if err := json.Unmarshal(payload, &o); err != nil {
-		log.Printf("[kafka] bad json (offset %d): %v", offset, err)
-		return err
+// logger возвращает логгер консьюмера.
+func (c *Consumer) logger() *slog.Logger {
+	if c.log != nil {
+		return c.log
 	}
+	return slog.Default()
+}
+
+// messageContext кладёт координаты сообщения в контекст,
+// чтобы они попали во все логи его обработки.
+func messageContext(ctx context.Context, m kafka.Message) context.Context {
+	return logging.WithAttrs(ctx,
+		slog.String("topic", m.Topic),
+		slog.Int("partition", m.Partition),
+		slog.Int64("offset", m.Offset),
+	)
+}
 
-	if err := validate(&o); err != nil {
-		log.Printf("[kafka] invalid order (offset %d): %v", offset, err)
+// messageSpan начинает спан обработки сообщения. Родитель берётся
+// из заголовков traceparent/tracestate, которые записал продюсер.
+func messageSpan(ctx context.Context, m kafka.Message) (context.Context, trace.Span) {
+	return tracer.Start(tracing.Extract(ctx, m), m.Topic+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "kafka"),
+			attribute.String("messaging.operation.type", "process"),
+			attribute.String("messaging.destination.name", m.Topic),
+			attribute.Int("messaging.destination.partition.id", m.Partition),
+			attribute.Int64("messaging.kafka.offset", m.Offset),
+			attribute.String("messaging.kafka.message.key", string(m.Key)),
+		),
+	)
+}
+
+// processPayload парсит, валидирует, проверяет бизнес-правилами и сохраняет заказ из сообщения.
+// Вынесено отдельно, чтобы можно было нормально тестить без Kafka.
+// Каждая попытка — отдельный спан, поэтому повторы видны в трассе.
+// Копия старее сохранённой пропускается без ошибки.
+func (c *Consumer) processPayload(ctx context.Context, m kafka.Message) (err error) {
+	ctx, span := tracer.Start(ctx, "process order")
+	defer func() { tracing.End(span, err) }()
+
+	o, warnings, err := c.checker().Decode(ctx, m.Value)
+	if err != nil {
+		c.logger().WarnContext(ctx, "payload rejected", "error", err)
 		return err
 	}
+	span.SetAttributes(attribute.String("order.uid", o.OrderUID))
 
 	// запись в БД
-	if err := c.repo.InsertOrUpdateOrder(ctx, o); err != nil {
-		log.Printf("[kafka] db error (offset %d): %v", offset, err)
+	err = c.repo.InsertOrUpdateOrder(ctx, o, c.source(m, o))
+	if errors.Is(err, repo.ErrStale) {
+		span.SetAttributes(attribute.Bool("order.stale", true))
+		c.metrics.StaleSkipped(1)
+		c.logger().InfoContext(ctx, "stale update skipped", "order_uid", o.OrderUID)
+		return nil
+	}
+	if err != nil {
+		c.logger().ErrorContext(ctx, "store order failed", "order_uid", o.OrderUID, "error", err)
 		return err
 	}
 
 	// обновление кэша
 	c.cache.Set(o)
+	c.metrics.MessagesStored(1)
+	c.checker().Report(ctx, o.OrderUID, warnings)
 
-	log.Printf("[kafka] stored order %s (offset %d)", o.OrderUID, offset)
+	c.logger().InfoContext(ctx, "order stored", "order_uid", o.OrderUID)
 	return nil
 }
 
-// Run запускает бесконечный цикл чтения и обработки сообщений Kafka.
-func (c *Consumer) Run(ctx context.Context) {
-	log.Println("[kafka] consumer started")
-	for {
-		m, err := c.reader.ReadMessage(ctx)
-		if err != nil {
-			if ctx.Err() != nil {
-				log.Println("[kafka] stopped:", ctx.Err())
-				return
-			}
-			log.Println("[kafka] read error:", err)
-			continue
-		}
+// handleMessage доводит сообщение до конца: сохраняет заказ или применяет событие
+// смены статуса, повторяя запись при временных ошибках, либо отправляет сообщение в DLQ.
+// Ошибка означает, что сообщение не обработано и коммитить его нельзя.
+func (c *Consumer) handleMessage(ctx context.Context, m kafka.Message) (err error) {
+	ctx, span := messageSpan(messageContext(ctx, m), m)
+	defer func() { tracing.End(span, err) }()
 
-		if err := c.processPayload(ctx, m.Value, m.Offset); err != nil {
-			continue
-		}
+	process := c.processor(m)
+	err = c.withRetry(ctx, func() error {
+		return process(ctx, m)
+	})
+	if err == nil {
+		return nil
+	}
+	return c.deadLetter(ctx, m, err)
+}
+
+// deadLetter отправляет в DLQ сообщение, которое не удалось обработать из-за err.
+// Ошибка означает, что сообщение не отклонено и коммитить его нельзя.
+func (c *Consumer) deadLetter(ctx context.Context, m kafka.Message, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
 
-		// ручной коммит оффсета
-		if err := c.reader.CommitMessages(ctx, m); err != nil {
-			log.Printf("[kafka] commit error (offset %d): %v", m.Offset, err)
+	var (
+		rej    *ingest.RejectError
+		giveUp *giveUpError
+	)
+	switch {
+	case errors.As(err, &rej):
+	case errors.As(err, &giveUp):
+		if c.cfg.GiveUp != GiveUpDeadLetter {
+			return err
 		}
+		rej = &ingest.RejectError{Reason: reasonRetriesExhausted, Err: giveUp.err}
+	default:
+		// постоянная ошибка БД (например, нарушение ограничения): повтор не поможет
+		rej = &ingest.RejectError{Reason: reasonStorage, Err: err}
 	}
+
+	// DLQ и таблица тоже могут быть временно недоступны, поэтому тоже с повторами
+	if err := c.withRetry(ctx, func() error { return c.reject(ctx, m, rej) }); err != nil {
+		return fmt.Errorf("reject: %w", err)
+	}
+	c.metrics.MessageRejected(rej.Reason)
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("order.reject_reason", rej.Reason))
+	c.logger().WarnContext(ctx, "message rejected", "reason", rej.Reason)
+	return nil
 }
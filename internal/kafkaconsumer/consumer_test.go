@@ -1,35 +1,96 @@
 package kafkaconsumer
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/ingest"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/lifecycle"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/logging"
 	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/models"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/repo"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/validation"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	kafka "github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 // МОКИ ПОД ИНТЕРФЕЙСЫ
 
 type fakeRepo struct {
-	last  models.Order
-	calls int
-	fail  bool
+	last      models.Order
+	lastSrc   repo.Source
+	calls     int
+	fail      bool
+	transient int // сколько первых вызовов вернут временную ошибку
+	attempts  int
+	batches   [][]repo.OrderWrite
+	failBatch bool
+	// staleBefore — копии со свежестью раньше этого времени считаются устаревшими
+	staleBefore time.Time
 }
 
-func (f *fakeRepo) InsertOrUpdateOrder(ctx context.Context, o models.Order) error {
+func (f *fakeRepo) InsertOrUpdateOrder(ctx context.Context, o models.Order, src repo.Source) error {
+	f.attempts++
 	if f.fail {
 		return context.Canceled
 	}
+	if f.attempts <= f.transient {
+		return fmt.Errorf("%w: %w", repo.ErrUnavailable, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")})
+	}
+	if src.At.Before(f.staleBefore) && !src.At.IsZero() {
+		return fmt.Errorf("order %s: %w", o.OrderUID, repo.ErrStale)
+	}
 	f.last = o
+	f.lastSrc = src
 	f.calls++
 	return nil
 }
+func (f *fakeRepo) InsertOrUpdateOrders(ctx context.Context, writes []repo.OrderWrite) ([]int, error) {
+	if f.failBatch {
+		return nil, errors.New("value too long")
+	}
+	f.batches = append(f.batches, writes)
+	var stale []int
+	for i, w := range writes {
+		if w.Source.At.Before(f.staleBefore) && !w.Source.At.IsZero() {
+			stale = append(stale, i)
+		}
+	}
+	return stale, nil
+}
 func (f *fakeRepo) LoadAllOrders(ctx context.Context, limit int) ([]models.Order, error) {
 	return nil, nil
 }
+func (f *fakeRepo) StreamOrders(ctx context.Context, fn func(models.Order) error) error {
+	return nil
+}
 func (f *fakeRepo) GetOrder(ctx context.Context, id string) (models.Order, error) {
 	return models.Order{}, nil
 }
+func (f *fakeRepo) GetOrderByTrack(ctx context.Context, track string) (models.Order, error) {
+	return models.Order{}, nil
+}
+func (f *fakeRepo) GetOrderByTransaction(ctx context.Context, tx string) (models.Order, error) {
+	return models.Order{}, nil
+}
+func (f *fakeRepo) SearchOrders(ctx context.Context, flt repo.OrderFilter) (repo.SearchPage, error) {
+	return repo.SearchPage{}, nil
+}
 func (f *fakeRepo) InsertTestOrder(ctx context.Context) error { return nil }
 
 type fakeCache struct {
@@ -38,13 +99,48 @@ type fakeCache struct {
 }
 
 func (f *fakeCache) Get(id string) (models.Order, bool) { return models.Order{}, false }
+func (f *fakeCache) GetByTrack(track string) (models.Order, bool) {
+	return models.Order{}, false
+}
+func (f *fakeCache) GetByTransaction(tx string) (models.Order, bool) {
+	return models.Order{}, false
+}
 func (f *fakeCache) Set(o models.Order) {
 	f.last = o
 	f.sets++
 }
+func (f *fakeCache) SetIfAbsent(o models.Order) bool {
+	f.Set(o)
+	return true
+}
 func (f *fakeCache) Load(os []models.Order) {}
 func (f *fakeCache) Size() int              { return f.sets }
 
+type fakeWriter struct {
+	msgs []kafka.Message
+}
+
+func (f *fakeWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	f.msgs = append(f.msgs, msgs...)
+	return nil
+}
+func (f *fakeWriter) Close() error { return nil }
+
+type fakeRejected struct {
+	saved []models.RejectedMessage
+}
+
+func (f *fakeRejected) SaveRejected(ctx context.Context, m models.RejectedMessage) error {
+	f.saved = append(f.saved, m)
+	return nil
+}
+func (f *fakeRejected) ListRejected(ctx context.Context, limit, offset int) ([]models.RejectedMessage, error) {
+	return f.saved, nil
+}
+func (f *fakeRejected) GetRejected(ctx context.Context, id int64) (models.RejectedMessage, error) {
+	return models.RejectedMessage{}, nil
+}
+
 func TestProcessPayloadValid(t *testing.T) {
 	r := &fakeRepo{}
 	c := &fakeCache{}
@@ -69,7 +165,7 @@ func TestProcessPayloadValid(t *testing.T) {
 		"oof_shard":"o"
 	}`)
 
-	if err := cons.processPayload(context.Background(), payload, 1); err != nil {
+	if err := cons.processPayload(context.Background(), kafka.Message{Value: payload}); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
@@ -92,7 +188,7 @@ func TestProcessPayloadInvalidJSON(t *testing.T) {
 
 	payload := []byte(`{bad json`)
 
-	if err := cons.processPayload(context.Background(), payload, 1); err == nil {
+	if err := cons.processPayload(context.Background(), kafka.Message{Value: payload}); err == nil {
 		t.Fatalf("expected error")
 	}
 
@@ -129,7 +225,7 @@ func TestProcessPayloadInvalidModel(t *testing.T) {
 		"oof_shard":"o"
 	}`)
 
-	if err := cons.processPayload(context.Background(), payload, 2); err == nil {
+	if err := cons.processPayload(context.Background(), kafka.Message{Value: payload}); err == nil {
 		t.Fatalf("expected validation error")
 	}
 
@@ -164,7 +260,7 @@ func TestProcessPayloadRepoFail(t *testing.T) {
 		"oof_shard":"o"
 	}`)
 
-	if err := cons.processPayload(context.Background(), payload, 3); err == nil {
+	if err := cons.processPayload(context.Background(), kafka.Message{Value: payload}); err == nil {
 		t.Fatalf("expected error")
 	}
 
@@ -176,4 +272,807 @@ func TestProcessPayloadRepoFail(t *testing.T) {
 	}
 }
 
-var _ = time.Now
+func TestRejectSendsToDLQAndStore(t *testing.T) {
+	w := &fakeWriter{}
+	rs := &fakeRejected{}
+
+	cons := &Consumer{repo: &fakeRepo{}, cache: &fakeCache{}, dlq: w, rejected: rs}
+
+	m := kafka.Message{
+		Topic:     "orders",
+		Partition: 2,
+		Offset:    42,
+		Key:       []byte("broken.json"),
+		Value:     []byte(`{"order_uid": 123`),
+		Time:      time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+
+	err := cons.processPayload(context.Background(), m)
+	var rej *ingest.RejectError
+	if !errors.As(err, &rej) {
+		t.Fatalf("expected RejectError, got %v", err)
+	}
+	if rej.Reason != ingest.ReasonBadJSON {
+		t.Fatalf("expected reason %s got %s", ingest.ReasonBadJSON, rej.Reason)
+	}
+
+	if err := cons.reject(context.Background(), m, rej); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(w.msgs) != 1 {
+		t.Fatalf("expected 1 dlq message got %d", len(w.msgs))
+	}
+	headers := map[string]string{}
+	for _, h := range w.msgs[0].Headers {
+		headers[h.Key] = string(h.Value)
+	}
+	if headers[headerRejectReason] != ingest.ReasonBadJSON {
+		t.Fatalf("wrong reason header: %q", headers[headerRejectReason])
+	}
+	if headers[headerOriginalTopic] != "orders" || headers[headerOriginalPartition] != "2" || headers[headerOriginalOffset] != "42" {
+		t.Fatalf("wrong origin headers: %v", headers)
+	}
+	if headers[headerOriginalTimestamp] != "2024-01-02T03:04:05Z" {
+		t.Fatalf("wrong timestamp header: %q", headers[headerOriginalTimestamp])
+	}
+
+	if len(rs.saved) != 1 {
+		t.Fatalf("expected 1 saved message got %d", len(rs.saved))
+	}
+	if rs.saved[0].Offset != 42 || rs.saved[0].Reason != ingest.ReasonBadJSON || rs.saved[0].Payload != string(m.Value) {
+		t.Fatalf("wrong saved message: %+v", rs.saved[0])
+	}
+}
+
+// validPayload — заказ, который проходит валидацию.
+func validPayload(id string) []byte {
+	return []byte(`{
+		"order_uid":"` + id + `",
+		"track_number":"WBILMTESTTRACK",
+		"entry":"WBIL",
+		"delivery":{"name":"n","phone":"+79000000000","zip":"12345","city":"c","address":"a","region":"r","email":"e@e.com"},
+		"payment":{"transaction":"` + id + `","request_id":"","currency":"USD","provider":"wbpay","amount":1,"payment_dt":1,"bank":"alpha","delivery_cost":1,"goods_total":1,"custom_fee":0},
+		"items":[{"chrt_id":1,"track_number":"WBILMTESTTRACK","price":1,"rid":"rid1","name":"i","sale":1,"size":"0","total_price":1,"nm_id":1,"brand":"b","status":1}],
+		"locale":"en",
+		"internal_signature":"",
+		"customer_id":"c",
+		"delivery_service":"d",
+		"shardkey":"s",
+		"sm_id":1,
+		"date_created":"2021-11-26T06:22:19Z",
+		"oof_shard":"o"
+	}`)
+}
+
+func TestHandleMessageRetriesTransient(t *testing.T) {
+	r := &fakeRepo{transient: 2}
+	c := &fakeCache{}
+
+	cons := &Consumer{repo: r, cache: c, cfg: Config{RetryBackoff: time.Millisecond, RetryMaxBackoff: time.Millisecond}}
+
+	m := kafka.Message{Topic: "orders", Partition: 1, Offset: 1, Value: validPayload("order126")}
+	if err := cons.handleMessage(context.Background(), m); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r.attempts != 3 || r.calls != 1 {
+		t.Fatalf("expected 3 attempts and 1 stored, got %d/%d", r.attempts, r.calls)
+	}
+	if r.lastSrc != (repo.Source{Kind: repo.SourceKafka, Ref: "orders/1/1"}) {
+		t.Fatalf("unexpected source %+v", r.lastSrc)
+	}
+	if c.sets != 1 {
+		t.Fatalf("cache should be updated after retry")
+	}
+}
+
+func TestHandleMessageLogsMessageFields(t *testing.T) {
+	var buf bytes.Buffer
+	lg, err := logging.New(&buf, "info", "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cons := &Consumer{repo: &fakeRepo{}, cache: &fakeCache{}, log: lg}
+
+	m := kafka.Message{Topic: "orders", Partition: 3, Offset: 17, Value: validPayload("order127")}
+	if err := cons.handleMessage(context.Background(), m); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var rec map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatalf("log is not json: %v (%s)", err, buf.String())
+	}
+	if rec["msg"] != "order stored" || rec["topic"] != "orders" || rec["partition"] != float64(3) ||
+		rec["offset"] != float64(17) || rec["order_uid"] != "order127" {
+		t.Fatalf("unexpected log record: %v", rec)
+	}
+}
+
+func TestHandleMessageContinuesProducerTrace(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	m := kafka.Message{
+		Topic:   "orders",
+		Offset:  5,
+		Value:   validPayload("order128"),
+		Headers: []kafka.Header{{Key: "traceparent", Value: []byte("00-" + traceID + "-00f067aa0ba902b7-01")}},
+	}
+	cons := &Consumer{repo: &fakeRepo{}, cache: &fakeCache{}}
+	if err := cons.handleMessage(context.Background(), m); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	spans := sr.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	process, msg := spans[0], spans[1]
+	if msg.Name() != "orders process" || process.Name() != "process order" {
+		t.Fatalf("unexpected spans %q, %q", msg.Name(), process.Name())
+	}
+	if msg.SpanContext().TraceID().String() != traceID || msg.Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Fatalf("message span not continuing producer trace: %v", msg.Parent())
+	}
+	if process.Parent().SpanID() != msg.SpanContext().SpanID() {
+		t.Fatal("process span is not a child of message span")
+	}
+}
+
+func TestHandleMessageGiveUp(t *testing.T) {
+	cfg := Config{MaxRetries: 2, RetryBackoff: time.Millisecond, RetryMaxBackoff: time.Millisecond}
+
+	// stop: сообщение не обработано, коммитить нельзя
+	r := &fakeRepo{transient: 100}
+	cons := &Consumer{repo: r, cache: &fakeCache{}, cfg: cfg}
+	err := cons.handleMessage(context.Background(), kafka.Message{Offset: 1, Value: validPayload("order127")})
+	var giveUp *giveUpError
+	if !errors.As(err, &giveUp) {
+		t.Fatalf("expected giveUpError, got %v", err)
+	}
+	if r.attempts != 3 {
+		t.Fatalf("expected 3 attempts got %d", r.attempts)
+	}
+
+	// dlq: сообщение уходит в DLQ и считается обработанным
+	cfg.GiveUp = GiveUpDeadLetter
+	w := &fakeWriter{}
+	cons = &Consumer{repo: &fakeRepo{transient: 100}, cache: &fakeCache{}, cfg: cfg, dlq: w}
+	if err := cons.handleMessage(context.Background(), kafka.Message{Offset: 2, Value: validPayload("order128")}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(w.msgs) != 1 {
+		t.Fatalf("expected 1 dlq message got %d", len(w.msgs))
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{errors.New("boom"), false},
+		{context.Canceled, false},
+		{context.DeadlineExceeded, true},
+		{fmt.Errorf("insert: %w", &net.OpError{Op: "dial", Err: errors.New("refused")}), true},
+		{fmt.Errorf("%w: %w", repo.ErrUnavailable, &pgconn.PgError{Code: "08006"}), true},
+		{fmt.Errorf("%w: %w", repo.ErrConflict, &pgconn.PgError{Code: "23505"}), false},
+		{fmt.Errorf("%w: %w", repo.ErrConflict, context.DeadlineExceeded), false},
+		{kafka.LeaderNotAvailable, true},
+		{&ingest.RejectError{Reason: ingest.ReasonBadJSON, Err: context.DeadlineExceeded}, false},
+	}
+	for _, tc := range cases {
+		if got := isTransient(tc.err); got != tc.want {
+			t.Errorf("isTransient(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestBackoffCapped(t *testing.T) {
+	base, max := 100*time.Millisecond, time.Second
+	for attempt := 0; attempt < 50; attempt++ {
+		d := backoff(attempt, base, max)
+		if d <= 0 || d > max {
+			t.Fatalf("attempt %d: backoff %s out of range", attempt, d)
+		}
+	}
+	if d := backoff(0, base, max); d < base/2 || d > base {
+		t.Fatalf("first backoff %s should be in [%s, %s]", d, base/2, base)
+	}
+}
+
+func TestHandleBatch(t *testing.T) {
+	r := &fakeRepo{}
+	c := &fakeCache{}
+	w := &fakeWriter{}
+
+	cons := &Consumer{repo: r, cache: c, dlq: w}
+
+	msgs := []kafka.Message{
+		{Topic: "orders", Offset: 10, Value: validPayload("order130")},
+		{Topic: "orders", Offset: 11, Value: []byte(`{bad json`)},
+		{Topic: "orders", Offset: 12, Value: validPayload("order131")},
+	}
+
+	if err := cons.handleBatch(context.Background(), msgs); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(r.batches) != 1 || len(r.batches[0]) != 2 {
+		t.Fatalf("expected one batch of 2 orders, got %v", r.batches)
+	}
+	if src := r.batches[0][1].Source; src.Kind != repo.SourceKafka || src.Ref != "orders/0/12" {
+		t.Fatalf("unexpected source %+v", src)
+	}
+	if r.calls != 0 {
+		t.Fatalf("single writes should not be used, got %d", r.calls)
+	}
+	if c.sets != 2 {
+		t.Fatalf("expected 2 cache sets got %d", c.sets)
+	}
+	if len(w.msgs) != 1 || w.msgs[0].Headers[0].Key != headerRejectReason {
+		t.Fatalf("bad message should go to dlq, got %v", w.msgs)
+	}
+}
+
+func TestHandleBatchFallsBackToSingleWrites(t *testing.T) {
+	r := &fakeRepo{failBatch: true}
+	c := &fakeCache{}
+
+	cons := &Consumer{repo: r, cache: c}
+
+	msgs := []kafka.Message{
+		{Offset: 20, Value: validPayload("order132")},
+		{Offset: 21, Value: validPayload("order133")},
+	}
+
+	if err := cons.handleBatch(context.Background(), msgs); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r.calls != 2 {
+		t.Fatalf("expected 2 single writes got %d", r.calls)
+	}
+	if c.sets != 2 {
+		t.Fatalf("expected 2 cache sets got %d", c.sets)
+	}
+}
+
+type fakeReader struct {
+	mu        sync.Mutex
+	msgs      []kafka.Message
+	committed map[int]int64
+	commits   chan struct{}
+}
+
+func (f *fakeReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	f.mu.Lock()
+	if len(f.msgs) > 0 {
+		m := f.msgs[0]
+		f.msgs = f.msgs[1:]
+		f.mu.Unlock()
+		return m, nil
+	}
+	f.mu.Unlock()
+
+	<-ctx.Done()
+	return kafka.Message{}, ctx.Err()
+}
+func (f *fakeReader) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, m := range msgs {
+		if m.Offset < f.committed[m.Partition] {
+			panic("offset moved backwards")
+		}
+		f.committed[m.Partition] = m.Offset
+	}
+	f.commits <- struct{}{}
+	return nil
+}
+func (f *fakeReader) Close() error { return nil }
+
+// safeRepo — потокобезопасный репозиторий для тестов с воркерами.
+type safeRepo struct {
+	fakeRepo
+	mu sync.Mutex
+}
+
+func (s *safeRepo) InsertOrUpdateOrder(ctx context.Context, o models.Order, src repo.Source) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fakeRepo.InsertOrUpdateOrder(ctx, o, src)
+}
+
+type safeCache struct {
+	fakeCache
+	mu sync.Mutex
+}
+
+func (s *safeCache) Set(o models.Order) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fakeCache.Set(o)
+}
+
+func TestOffsetTrackerCommitsContiguousPrefix(t *testing.T) {
+	tr := newOffsetTracker()
+	for off := int64(0); off < 4; off++ {
+		tr.track(kafka.Message{Partition: 0, Offset: off})
+	}
+	tr.track(kafka.Message{Partition: 1, Offset: 7})
+
+	// 1 и 2 готовы, но 0 ещё в работе — коммитить нечего
+	if got := tr.complete(kafka.Message{Partition: 0, Offset: 2}, kafka.Message{Partition: 0, Offset: 1}); len(got) != 0 {
+		t.Fatalf("expected nothing to commit, got %v", got)
+	}
+
+	got := tr.complete(kafka.Message{Partition: 0, Offset: 0}, kafka.Message{Partition: 1, Offset: 7})
+	want := map[int]int64{0: 2, 1: 7}
+	if len(got) != 2 {
+		t.Fatalf("expected 2 commits got %v", got)
+	}
+	for _, m := range got {
+		if want[m.Partition] != m.Offset {
+			t.Fatalf("partition %d: expected %d got %d", m.Partition, want[m.Partition], m.Offset)
+		}
+	}
+
+	got = tr.complete(kafka.Message{Partition: 0, Offset: 3})
+	if len(got) != 1 || got[0].Offset != 3 {
+		t.Fatalf("expected commit of offset 3, got %v", got)
+	}
+}
+
+func TestRunWorkerPool(t *testing.T) {
+	rd := &fakeReader{committed: map[int]int64{}, commits: make(chan struct{}, 100)}
+	for i := 0; i < 6; i++ {
+		id := fmt.Sprintf("order14%d", i)
+		rd.msgs = append(rd.msgs, kafka.Message{Partition: i % 2, Offset: int64(i / 2), Key: []byte(id), Value: validPayload(id)})
+	}
+
+	r := &safeRepo{}
+	c := &safeCache{}
+	cons := &Consumer{reader: rd, repo: r, cache: c, cfg: Config{Workers: 3, DispatchBy: DispatchByKey}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- cons.Run(ctx) }()
+
+	deadline := time.After(2 * time.Second)
+	for {
+		rd.mu.Lock()
+		finished := rd.committed[0] == 2 && rd.committed[1] == 2
+		rd.mu.Unlock()
+		if finished {
+			break
+		}
+		select {
+		case <-rd.commits:
+		case <-deadline:
+			t.Fatalf("offsets not committed: %v", rd.committed)
+		}
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("cancelled Run must not report an error: %v", err)
+	}
+
+	if r.calls != 6 {
+		t.Fatalf("expected 6 stored orders got %d", r.calls)
+	}
+}
+
+// brokenReader — брокер недоступен: каждое чтение падает.
+type brokenReader struct {
+	fetches atomic.Int64
+}
+
+func (f *brokenReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	f.fetches.Add(1)
+	return kafka.Message{}, errors.New("broker unavailable")
+}
+func (f *brokenReader) CommitMessages(ctx context.Context, msgs ...kafka.Message) error { return nil }
+func (f *brokenReader) Close() error                                                    { return nil }
+
+func TestRunBacksOffOnReadErrors(t *testing.T) {
+	rd := &brokenReader{}
+	cfg := Config{Workers: 1, RetryBackoff: 20 * time.Millisecond, RetryMaxBackoff: 20 * time.Millisecond}
+	cons := &Consumer{reader: rd, repo: &safeRepo{}, cache: &safeCache{}, cfg: cfg}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := cons.Run(ctx); err != nil {
+		t.Fatalf("cancelled Run must not report an error: %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("Run did not stop on ctx while backing off: %s", d)
+	}
+	// без задержки за 100ms были бы тысячи попыток
+	if n := rd.fetches.Load(); n < 2 || n > 15 {
+		t.Fatalf("expected a handful of fetch attempts, got %d", n)
+	}
+}
+
+func TestRunReturnsStopError(t *testing.T) {
+	rd := &fakeReader{committed: map[int]int64{}, commits: make(chan struct{}, 100)}
+	rd.msgs = []kafka.Message{{Offset: 1, Value: validPayload("order150")}}
+
+	// БД недоступна дольше, чем разрешено повторов, политика — остановиться
+	r := &safeRepo{fakeRepo: fakeRepo{transient: 1 << 30}}
+	cfg := Config{Workers: 1, MaxRetries: 1, GiveUp: GiveUpStop, RetryBackoff: time.Millisecond, RetryMaxBackoff: time.Millisecond}
+	cons := &Consumer{reader: rd, repo: r, cache: &safeCache{}, cfg: cfg}
+
+	done := make(chan error, 1)
+	go func() { done <- cons.Run(context.Background()) }()
+
+	select {
+	case err := <-done:
+		var giveUp *giveUpError
+		if !errors.As(err, &giveUp) {
+			t.Fatalf("expected retries exhausted error, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Run did not return after the consumer stopped")
+	}
+	if err := cons.Check(context.Background()); err == nil || !strings.Contains(err.Error(), "consumer stopped") {
+		t.Fatalf("stopped consumer must not be ready: %v", err)
+	}
+}
+
+func TestCheckReportsStalledConsumer(t *testing.T) {
+	rd := &fakeReader{committed: map[int]int64{}, commits: make(chan struct{}, 100)}
+	rd.msgs = []kafka.Message{{Offset: 1, Value: validPayload("order150")}}
+
+	// БД всё время недоступна, сообщение повторяется бесконечно
+	r := &safeRepo{fakeRepo: fakeRepo{transient: 1 << 30}}
+	cfg := Config{Workers: 1, RetryBackoff: time.Millisecond, RetryMaxBackoff: time.Millisecond, StallTimeout: 20 * time.Millisecond}
+	cons := &Consumer{reader: rd, repo: r, cache: &safeCache{}, cfg: cfg}
+
+	if err := cons.Check(context.Background()); err == nil {
+		t.Fatalf("consumer that is not running must not be ready")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		cons.Run(ctx)
+		close(done)
+	}()
+
+	deadline := time.After(2 * time.Second)
+	for {
+		err := cons.Check(context.Background())
+		if err != nil && strings.Contains(err.Error(), "no commit progress") {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatalf("stall not detected, last check: %v", err)
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+
+	cancel()
+	<-done
+
+	if err := cons.Check(context.Background()); err == nil || !strings.Contains(err.Error(), "stopped") {
+		t.Fatalf("expected stopped consumer, got %v", err)
+	}
+}
+
+func TestStaleUpdateSkipped(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	r := &fakeRepo{staleBefore: now}
+	c := &fakeCache{}
+	cons := &Consumer{repo: r, cache: c, cfg: Config{Freshness: FreshnessKafkaTime}}
+
+	old := kafka.Message{Topic: "orders", Offset: 1, Time: now.Add(-time.Minute), Value: validPayload("order140")}
+	if err := cons.handleMessage(context.Background(), old); err != nil {
+		t.Fatalf("stale copy should not fail: %v", err)
+	}
+	if r.calls != 0 || c.sets != 0 {
+		t.Fatalf("stale copy should not be stored or cached, got %d/%d", r.calls, c.sets)
+	}
+
+	msgs := []kafka.Message{
+		{Topic: "orders", Offset: 2, Time: now.Add(-time.Second), Value: validPayload("order141")},
+		{Topic: "orders", Offset: 3, Time: now.Add(time.Second), Value: validPayload("order142")},
+	}
+	if err := cons.handleBatch(context.Background(), msgs); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.sets != 1 || c.last.OrderUID != "order142" {
+		t.Fatalf("only the fresh copy should be cached, got %d sets, last %s", c.sets, c.last.OrderUID)
+	}
+}
+
+func TestSourceFreshness(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	m := kafka.Message{
+		Topic: "orders", Partition: 3, Offset: 7, Time: at,
+		Headers: []kafka.Header{{Key: "X-Updated-At", Value: []byte("2024-05-01T13:00:00Z")}},
+	}
+	o := models.Order{Payment: models.Payment{PaymentDt: at.Add(-time.Hour).Unix()}}
+
+	cases := []struct {
+		mode   FreshnessMode
+		header string
+		want   time.Time
+	}{
+		{FreshnessKafkaTime, "", at},
+		{FreshnessPaymentDt, "", at.Add(-time.Hour)},
+		{FreshnessHeader, "x-updated-at", at.Add(time.Hour)},
+		{FreshnessHeader, "x-missing", time.Time{}},
+		{FreshnessNone, "", time.Time{}},
+	}
+	for _, tc := range cases {
+		cons := &Consumer{cfg: Config{Freshness: tc.mode, FreshnessHeader: tc.header}}
+		src := cons.source(m, o)
+		if !src.At.Equal(tc.want) {
+			t.Errorf("%s: At = %v, want %v", tc.mode, src.At, tc.want)
+		}
+		if src.Kind != repo.SourceKafka || src.Ref != "orders/3/7" {
+			t.Errorf("%s: unexpected source %+v", tc.mode, src)
+		}
+	}
+
+	if got := headerTime([]kafka.Header{{Key: "t", Value: []byte("1714564800000")}}, "t"); !got.Equal(at) {
+		t.Errorf("unix millis header = %v, want %v", got, at)
+	}
+}
+
+// fakeStatuses применяет переходы по таблице lifecycle к статусам в памяти.
+type fakeStatuses struct {
+	current map[string]lifecycle.Status
+	known   func(uid string) bool
+	applied []models.StatusChange
+}
+
+func (f *fakeStatuses) ApplyStatus(ctx context.Context, ev models.StatusEvent, src repo.Source) (models.StatusChange, error) {
+	if !f.known(ev.OrderUID) {
+		return models.StatusChange{}, fmt.Errorf("order %s %w", ev.OrderUID, repo.ErrNotFound)
+	}
+	from, ok := f.current[ev.OrderUID]
+	if !ok {
+		from = lifecycle.Created
+	}
+	to := lifecycle.Status(ev.Status)
+	ch := models.StatusChange{From: string(from), To: ev.Status, Source: src.Kind, SourceRef: src.Ref}
+	if from == to {
+		return ch, nil
+	}
+	if err := lifecycle.Check(from, to); err != nil {
+		return models.StatusChange{}, err
+	}
+	f.current[ev.OrderUID] = to
+	f.applied = append(f.applied, ch)
+	return ch, nil
+}
+func (f *fakeStatuses) GetStatus(ctx context.Context, id string) (models.OrderStatus, error) {
+	return models.OrderStatus{}, nil
+}
+
+func statusMessage(offset int64, uid, status string) kafka.Message {
+	return kafka.Message{
+		Topic:   "orders",
+		Offset:  offset,
+		Headers: []kafka.Header{{Key: "Message-Type", Value: []byte("status")}},
+		Value:   []byte(`{"order_uid":"` + uid + `","status":"` + status + `"}`),
+	}
+}
+
+func TestHandleStatusMessages(t *testing.T) {
+	w := &fakeWriter{}
+	st := &fakeStatuses{
+		current: map[string]lifecycle.Status{},
+		known:   func(uid string) bool { return uid != "order-missing" },
+	}
+	cons := &Consumer{repo: &fakeRepo{}, cache: &fakeCache{}, dlq: w, statuses: st}
+
+	msgs := []kafka.Message{
+		statusMessage(1, "order150", "paid"),
+		statusMessage(2, "order150", "paid"),      // повтор — без ошибки и без перехода
+		statusMessage(3, "order150", "delivered"), // paid → delivered нельзя
+		statusMessage(4, "order-missing", "paid"),
+	}
+	for _, m := range msgs {
+		if err := cons.handleMessage(context.Background(), m); err != nil {
+			t.Fatalf("offset %d: unexpected error: %v", m.Offset, err)
+		}
+	}
+
+	if len(st.applied) != 1 || st.applied[0].To != "paid" || st.applied[0].SourceRef != "orders/0/1" {
+		t.Fatalf("unexpected transitions %+v", st.applied)
+	}
+	var reasons []string
+	for _, m := range w.msgs {
+		for _, h := range m.Headers {
+			if h.Key == headerRejectReason {
+				reasons = append(reasons, string(h.Value))
+			}
+		}
+	}
+	if want := []string{reasonIllegalTransition, reasonUnknownOrder}; !reflect.DeepEqual(reasons, want) {
+		t.Fatalf("dlq reasons = %v, want %v", reasons, want)
+	}
+}
+
+func TestStatusWaitsForOrder(t *testing.T) {
+	// заказ появляется, пока событие ждёт
+	calls := 0
+	st := &fakeStatuses{
+		current: map[string]lifecycle.Status{},
+		known:   func(string) bool { calls++; return calls > 2 },
+	}
+	rs := &fakeRejected{}
+	cons := &Consumer{
+		cfg:  Config{OrderWait: time.Second, RetryBackoff: time.Millisecond, RetryMaxBackoff: time.Millisecond},
+		repo: &fakeRepo{}, cache: &fakeCache{}, rejected: rs, statuses: st,
+	}
+
+	if err := cons.handleMessage(context.Background(), statusMessage(1, "order150", "paid")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls != 3 || len(st.applied) != 1 || len(rs.saved) != 0 {
+		t.Fatalf("event should be applied after the order appears: calls %d, applied %+v, rejected %+v", calls, st.applied, rs.saved)
+	}
+
+	// заказ так и не появился — событие уходит в DLQ, когда ожидание вышло
+	st.known = func(string) bool { return false }
+	cons.cfg.OrderWait = 20 * time.Millisecond
+	if err := cons.handleMessage(context.Background(), statusMessage(2, "order-missing", "paid")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rs.saved) != 1 || rs.saved[0].Reason != reasonUnknownOrder {
+		t.Fatalf("expected unknown_order rejection, got %+v", rs.saved)
+	}
+}
+
+func TestUnsupportedMessageType(t *testing.T) {
+	r := &fakeRepo{}
+	rs := &fakeRejected{}
+	cons := &Consumer{repo: r, cache: &fakeCache{}, rejected: rs, statuses: &fakeStatuses{}}
+
+	m := kafka.Message{
+		Topic:   "orders",
+		Offset:  1,
+		Headers: []kafka.Header{{Key: "message-type", Value: []byte("refund")}},
+		Value:   validPayload("order150"),
+	}
+	if err := cons.handleMessage(context.Background(), m); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := cons.handleBatch(context.Background(), []kafka.Message{m}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r.calls != 0 || len(r.batches) != 0 {
+		t.Fatalf("message of unknown type must not be stored as an order")
+	}
+	if len(rs.saved) != 2 || rs.saved[0].Reason != reasonUnsupportedType || rs.saved[1].Reason != reasonUnsupportedType {
+		t.Fatalf("expected unsupported_type rejections, got %+v", rs.saved)
+	}
+}
+
+func TestHandleBatchAppliesStatusAfterOrders(t *testing.T) {
+	r := &fakeRepo{}
+	st := &fakeStatuses{current: map[string]lifecycle.Status{}}
+	st.known = func(uid string) bool {
+		for _, b := range r.batches {
+			for _, w := range b {
+				if w.Order.OrderUID == uid {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	cons := &Consumer{repo: r, cache: &fakeCache{}, dlq: &fakeWriter{}, statuses: st}
+
+	msgs := []kafka.Message{
+		{Topic: "orders", Offset: 1, Value: validPayload("order151")},
+		statusMessage(2, "order151", "paid"),
+		{Topic: "orders", Offset: 3, Value: validPayload("order152")},
+		statusMessage(4, "order152", "cancelled"),
+	}
+	if err := cons.handleBatch(context.Background(), msgs); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(r.batches) != 1 || len(r.batches[0]) != 2 {
+		t.Fatalf("expected one batch of 2 orders, got %v", r.batches)
+	}
+	if st.current["order151"] != lifecycle.Paid || st.current["order152"] != lifecycle.Cancelled {
+		t.Fatalf("statuses not applied after orders: %v", st.current)
+	}
+}
+
+func TestBusinessRules(t *testing.T) {
+	// в validPayload amount = 1, а goods_total + delivery_cost = 2
+	strict, err := validation.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &fakeRepo{}
+	rs := &fakeRejected{}
+	cons := &Consumer{repo: r, cache: &fakeCache{}, rejected: rs, rules: strict}
+
+	m := kafka.Message{Topic: "orders", Offset: 1, Value: validPayload("order150")}
+	if err := cons.handleMessage(context.Background(), m); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r.calls != 0 || len(rs.saved) != 1 || rs.saved[0].Reason != ingest.ReasonRules {
+		t.Fatalf("order should be rejected by rules, calls %d, saved %+v", r.calls, rs.saved)
+	}
+	if !strings.Contains(rs.saved[0].Error, validation.RuleAmount) {
+		t.Fatalf("rejection should name the rule: %q", rs.saved[0].Error)
+	}
+
+	// правило, пониженное до warn, только пишет предупреждение — и один раз на заказ
+	lenient, err := validation.New(validation.Warn(validation.RuleAmount))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	lg, err := logging.New(&buf, "info", "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = &fakeRepo{}
+	c := &fakeCache{}
+	cons = &Consumer{repo: r, cache: c, rules: lenient, log: lg}
+
+	msgs := []kafka.Message{
+		{Topic: "orders", Offset: 2, Value: validPayload("order151")},
+		{Topic: "orders", Offset: 3, Value: validPayload("order152")},
+	}
+	if err := cons.handleBatch(context.Background(), msgs); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(r.batches) != 1 || len(r.batches[0]) != 2 || c.sets != 2 {
+		t.Fatalf("orders with warnings should be stored, batches %v", r.batches)
+	}
+	if got := strings.Count(buf.String(), `"msg":"business rule violated"`); got != 2 {
+		t.Fatalf("expected 2 violation logs, got %d: %s", got, buf.String())
+	}
+}
+
+func TestBatchRuleRejectReportedOnce(t *testing.T) {
+	strict, err := validation.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	lg, err := logging.New(&buf, "info", "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &fakeRepo{}
+	rs := &fakeRejected{}
+	cons := &Consumer{repo: r, cache: &fakeCache{}, rejected: rs, rules: strict, log: lg}
+
+	msgs := []kafka.Message{
+		{Topic: "orders", Offset: 1, Value: validPayload("order150")},
+		{Topic: "orders", Offset: 2, Value: []byte("{")},
+	}
+	if err := cons.handleBatch(context.Background(), msgs); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(r.batches) != 0 || r.calls != 0 {
+		t.Fatalf("rejected orders must not be stored, batches %v, calls %d", r.batches, r.calls)
+	}
+	if len(rs.saved) != 2 || rs.saved[0].Reason != ingest.ReasonRules || rs.saved[1].Reason != ingest.ReasonBadJSON {
+		t.Fatalf("unexpected rejections %+v", rs.saved)
+	}
+	// нарушение учитывается один раз: при разборе пачки, а не ещё и при отправке в DLQ
+	if got := strings.Count(buf.String(), `"msg":"business rule violated"`); got != 1 {
+		t.Fatalf("expected 1 violation log, got %d: %s", got, buf.String())
+	}
+	if got := strings.Count(buf.String(), `"msg":"message rejected"`); got != 2 {
+		t.Fatalf("expected 2 rejection logs, got %d: %s", got, buf.String())
+	}
+}
@@ -0,0 +1,85 @@
+package kafkaconsumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/ingest"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/models"
+
+	kafka "github.com/segmentio/kafka-go"
+)
+
+// Заголовки, которые добавляются к сообщению при пересылке в DLQ.
+const (
+	headerRejectReason      = "x-reject-reason"
+	headerRejectError       = "x-reject-error"
+	headerRejectedAt        = "x-rejected-at"
+	headerOriginalTopic     = "x-original-topic"
+	headerOriginalPartition = "x-original-partition"
+	headerOriginalOffset    = "x-original-offset"
+	headerOriginalTimestamp = "x-original-timestamp"
+)
+
+// messageWriter — то, что нужно от kafka.Writer для отправки в DLQ.
+type messageWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// reject пересылает отклонённое сообщение в DLQ и сохраняет его в rejected_messages.
+// Если хотя бы одно из сохранений не удалось, возвращается ошибка и оффсет не коммитится.
+func (c *Consumer) reject(ctx context.Context, m kafka.Message, rej *ingest.RejectError) error {
+	now := time.Now().UTC()
+
+	var errs []error
+	if c.dlq != nil {
+		if err := c.dlq.WriteMessages(ctx, deadLetter(m, rej, now)); err != nil {
+			errs = append(errs, fmt.Errorf("dlq: %w", err))
+		}
+	}
+
+	if c.rejected != nil {
+		rm := models.RejectedMessage{
+			Topic:       m.Topic,
+			Partition:   m.Partition,
+			Offset:      m.Offset,
+			Key:         string(m.Key),
+			Payload:     string(m.Value),
+			Reason:      rej.Reason,
+			Error:       rej.Err.Error(),
+			MessageTime: m.Time,
+			RejectedAt:  now,
+		}
+		if err := c.rejected.SaveRejected(ctx, rm); err != nil {
+			errs = append(errs, fmt.Errorf("rejected store: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// deadLetter собирает сообщение для DLQ: исходные ключ, тело и заголовки
+// плюс причина отклонения и координаты исходного сообщения.
+func deadLetter(m kafka.Message, rej *ingest.RejectError, at time.Time) kafka.Message {
+	headers := make([]kafka.Header, 0, len(m.Headers)+7)
+	headers = append(headers, m.Headers...)
+	headers = append(headers,
+		kafka.Header{Key: headerRejectReason, Value: []byte(rej.Reason)},
+		kafka.Header{Key: headerRejectError, Value: []byte(rej.Err.Error())},
+		kafka.Header{Key: headerRejectedAt, Value: []byte(at.Format(time.RFC3339Nano))},
+		kafka.Header{Key: headerOriginalTopic, Value: []byte(m.Topic)},
+		kafka.Header{Key: headerOriginalPartition, Value: []byte(strconv.Itoa(m.Partition))},
+		kafka.Header{Key: headerOriginalOffset, Value: []byte(strconv.FormatInt(m.Offset, 10))},
+		kafka.Header{Key: headerOriginalTimestamp, Value: []byte(m.Time.UTC().Format(time.RFC3339Nano))},
+	)
+
+	return kafka.Message{
+		Key:     m.Key,
+		Value:   m.Value,
+		Headers: headers,
+	}
+}
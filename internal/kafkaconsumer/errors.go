@@ -0,0 +1,75 @@
+package kafkaconsumer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/ingest"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/repo"
+)
+
+// Причины отклонения сообщения, которые добавляет сам консьюмер. Попадают
+// в заголовки DLQ и в rejected_messages вместе с ingest.ReasonBadJSON, ingest.ReasonValidation
+// и ingest.ReasonRules.
+const (
+	reasonStorage          = "storage"
+	reasonRetriesExhausted = "retries_exhausted"
+
+	// причины для событий смены статуса
+	reasonIllegalTransition = "illegal_transition"
+	reasonUnknownOrder      = "unknown_order"
+	reasonUnsupportedType   = "unsupported_type"
+)
+
+// giveUpError — временная ошибка, которая не прошла за отведённое число повторов.
+type giveUpError struct {
+	attempts int
+	err      error
+}
+
+func (e *giveUpError) Error() string { return "retries exhausted: " + e.err.Error() }
+
+func (e *giveUpError) Unwrap() error { return e.err }
+
+// isTransient сообщает, есть ли смысл повторять операцию. Ошибки БД репозиторий
+// уже классифицировал (repo.ErrUnavailable), остальное — сетевые сбои и недоступность
+// брокера при записи в DLQ.
+// Всё остальное (битые данные, нарушение ограничений) считается постоянной ошибкой.
+func isTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var rej *ingest.RejectError
+	if errors.As(err, &rej) || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	if errors.Is(err, repo.ErrUnavailable) {
+		return true
+	}
+	if errors.Is(err, repo.ErrNotFound) || errors.Is(err, repo.ErrConflict) {
+		return false
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	// ошибки kafka-go (например, LeaderNotAvailable) сами говорят, временные ли они
+	var tmp interface{ Temporary() bool }
+	if errors.As(err, &tmp) {
+		return tmp.Temporary()
+	}
+
+	return false
+}
@@ -0,0 +1,54 @@
+package kafkaconsumer
+
+import (
+	"strings"
+	"time"
+
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/ingest"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/models"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/repo"
+
+	kafka "github.com/segmentio/kafka-go"
+)
+
+// FreshnessMode определяет, по чему судить, что копия заказа новее сохранённой, см. ingest.Freshness.
+type FreshnessMode = ingest.Freshness
+
+const (
+	FreshnessKafkaTime = ingest.FreshnessKafkaTime
+	FreshnessPaymentDt = ingest.FreshnessPaymentDt
+	FreshnessHeader    = ingest.FreshnessHeader
+	FreshnessNone      = ingest.FreshnessNone
+)
+
+// defaultFreshnessHeader — заголовок со временем изменения заказа по умолчанию.
+const defaultFreshnessHeader = ingest.DefaultFreshnessHeader
+
+// source описывает сообщение как источник записи вместе со свежестью копии.
+// Нулевая свежесть (нет заголовка, нет payment_dt) — запись применяется безусловно.
+func (c *Consumer) source(m kafka.Message, o models.Order) repo.Source {
+	src := repo.KafkaSource(m.Topic, m.Partition, m.Offset)
+	switch c.cfg.Freshness {
+	case FreshnessKafkaTime:
+		src.At = m.Time
+	case FreshnessPaymentDt:
+		if o.Payment.PaymentDt > 0 {
+			src.At = time.Unix(o.Payment.PaymentDt, 0)
+		}
+	case FreshnessHeader:
+		src.At = headerTime(m.Headers, c.cfg.FreshnessHeader)
+	}
+	return src
+}
+
+// headerTime читает время из заголовка. Если заголовка нет или он не разбирается,
+// возвращается нулевое время.
+func headerTime(headers []kafka.Header, key string) time.Time {
+	for _, h := range headers {
+		if !strings.EqualFold(h.Key, key) {
+			continue
+		}
+		return ingest.ParseTime(string(h.Value))
+	}
+	return time.Time{}
+}
@@ -0,0 +1,104 @@
+package kafkaconsumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	kafka "github.com/segmentio/kafka-go"
+)
+
+// defaultStallTimeout — сколько коммит может не продвигаться при наличии
+// необработанных сообщений, прежде чем консьюмер считается застрявшим.
+const defaultStallTimeout = 2 * time.Minute
+
+// runState — состояние Run для проверки готовности.
+type runState struct {
+	mu      sync.Mutex
+	running bool
+	stopErr error // почему Run завершился
+	readErr error // последняя ошибка чтения, сбрасывается удачным чтением
+}
+
+func (s *runState) start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running, s.stopErr, s.readErr = true, nil, nil
+}
+
+// stop запоминает первую причину остановки.
+func (s *runState) stop(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running = false
+	if s.stopErr == nil {
+		s.stopErr = err
+	}
+}
+
+func (s *runState) setStopErr(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopErr == nil {
+		s.stopErr = err
+	}
+}
+
+// stopError возвращает причину остановки Run.
+func (s *runState) stopError() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stopErr
+}
+
+func (s *runState) setReadErr(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.readErr = err
+}
+
+// Check проверяет, что консьюмер работает: Run запущен и не остановился
+// из-за ошибки, чтение проходит, коммит не стоит дольше Config.StallTimeout
+// (например, из-за бесконечных повторов при лежащей БД) и брокер доступен.
+// Подходит для health.CheckFunc.
+func (c *Consumer) Check(ctx context.Context) error {
+	c.state.mu.Lock()
+	running, stopErr, readErr := c.state.running, c.state.stopErr, c.state.readErr
+	offsets := c.offsets
+	c.state.mu.Unlock()
+
+	switch {
+	case !running && stopErr != nil:
+		return fmt.Errorf("consumer stopped: %w", stopErr)
+	case !running:
+		return errors.New("consumer is not running")
+	case readErr != nil:
+		return fmt.Errorf("read: %w", readErr)
+	}
+
+	if n, d := offsets.stalled(); c.cfg.StallTimeout > 0 && d > c.cfg.StallTimeout {
+		return fmt.Errorf("no commit progress for %s with %d messages in flight", d.Round(time.Second), n)
+	}
+
+	return c.pingBrokers(ctx)
+}
+
+// pingBrokers проверяет, что доступен хотя бы один брокер.
+func (c *Consumer) pingBrokers(ctx context.Context) error {
+	if len(c.cfg.Brokers) == 0 {
+		return nil
+	}
+
+	var errs error
+	for _, b := range c.cfg.Brokers {
+		conn, err := kafka.DialContext(ctx, "tcp", b)
+		if err == nil {
+			_ = conn.Close()
+			return nil
+		}
+		errs = errors.Join(errs, err)
+	}
+	return fmt.Errorf("no broker reachable: %w", errs)
+}
@@ -0,0 +1,106 @@
+package kafkaconsumer
+
+import (
+	"sync"
+	"time"
+
+	kafka "github.com/segmentio/kafka-go"
+)
+
+// offsetTracker следит за сообщениями, которые обрабатываются параллельно,
+// и отдаёт на коммит только непрерывный обработанный префикс каждой партиции.
+// Так оффсет не перескочит через сообщение, которое ещё в работе или упало.
+type offsetTracker struct {
+	mu         sync.Mutex
+	partitions map[int]*partitionOffsets
+
+	// inFlight — сколько сообщений прочитано, но ещё не готово к коммиту;
+	// progress — когда коммит последний раз продвинулся или появилась новая работа
+	inFlight int
+	progress time.Time
+}
+
+// partitionOffsets — очередь оффсетов одной партиции в порядке чтения.
+type partitionOffsets struct {
+	pending []int64
+	head    int
+	done    map[int64]bool
+}
+
+func newOffsetTracker() *offsetTracker {
+	return &offsetTracker{partitions: make(map[int]*partitionOffsets)}
+}
+
+// track регистрирует прочитанное сообщение. Вызывается в порядке чтения.
+func (t *offsetTracker) track(m kafka.Message) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.partitions[m.Partition]
+	if !ok {
+		p = &partitionOffsets{done: make(map[int64]bool)}
+		t.partitions[m.Partition] = p
+	}
+	p.pending = append(p.pending, m.Offset)
+
+	if t.inFlight == 0 {
+		t.progress = time.Now()
+	}
+	t.inFlight++
+}
+
+// complete отмечает сообщения обработанными и возвращает по одному сообщению
+// на каждую партицию, где продвинулся непрерывный префикс, — их и надо коммитить.
+func (t *offsetTracker) complete(msgs ...kafka.Message) []kafka.Message {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	touched := make(map[int]kafka.Message)
+	for _, m := range msgs {
+		p, ok := t.partitions[m.Partition]
+		if !ok {
+			continue
+		}
+		p.done[m.Offset] = true
+		touched[m.Partition] = m
+	}
+
+	var out []kafka.Message
+	for partition, m := range touched {
+		p := t.partitions[partition]
+
+		last := int64(-1)
+		for p.head < len(p.pending) && p.done[p.pending[p.head]] {
+			last = p.pending[p.head]
+			delete(p.done, last)
+			p.head++
+			t.inFlight--
+		}
+		if last < 0 {
+			continue
+		}
+		t.progress = time.Now()
+
+		// сдвигаем очередь, чтобы не держать уже закоммиченные оффсеты
+		if p.head > 64 && p.head*2 > len(p.pending) {
+			n := copy(p.pending, p.pending[p.head:])
+			p.pending = p.pending[:n]
+			p.head = 0
+		}
+
+		out = append(out, kafka.Message{Topic: m.Topic, Partition: partition, Offset: last})
+	}
+	return out
+}
+
+// stalled возвращает, сколько сообщений ждут коммита и как давно коммит не продвигался.
+// Если ждать нечего, длительность нулевая.
+func (t *offsetTracker) stalled() (int, time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.inFlight == 0 {
+		return 0, 0
+	}
+	return t.inFlight, time.Since(t.progress)
+}
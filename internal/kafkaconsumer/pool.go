@@ -0,0 +1,197 @@
+package kafkaconsumer
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	kafka "github.com/segmentio/kafka-go"
+)
+
+// DispatchMode определяет, по какому признаку сообщения раскладываются по воркерам.
+type DispatchMode string
+
+const (
+	// DispatchByPartition — вся партиция обрабатывается одним воркером.
+	DispatchByPartition DispatchMode = "partition"
+	// DispatchByKey — по хэшу ключа сообщения (продюсер кладёт туда order_uid),
+	// поэтому разные заказы из одной партиции идут параллельно.
+	// Сообщения без ключа раскладываются по партиции.
+	DispatchByKey DispatchMode = "key"
+)
+
+// minQueueSize — минимальный размер очереди одного воркера.
+const minQueueSize = 64
+
+// Run читает сообщения в одной горутине и раздаёт их воркерам (Config.Workers).
+// Сообщения одной партиции или одного ключа всегда попадают к одному воркеру,
+// поэтому порядок внутри заказа сохраняется, а разные заказы пишутся параллельно.
+//
+// Сообщения читаются через FetchMessage, оффсет коммитится вручную и только
+// для непрерывного префикса обработанных сообщений партиции. Если обработать
+// сообщение не удалось, консьюмер останавливается, а не перескакивает через него.
+//
+// Run возвращает nil, если его остановили отменой ctx, и причину остановки,
+// если консьюмер остановился сам. Сам он уже не запустится: решать, что делать
+// дальше (например, завершить процесс), должен вызывающий.
+func (c *Consumer) Run(ctx context.Context) (err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	workers := max(c.cfg.Workers, 1)
+
+	// трекер подменяется под мьютексом состояния, чтобы Check его не читал на ходу
+	c.state.mu.Lock()
+	c.offsets = newOffsetTracker()
+	c.state.mu.Unlock()
+	c.state.start()
+
+	c.logger().Info("consumer started", "topic", c.cfg.Topic, "group", c.cfg.GroupID,
+		"workers", workers, "dispatch_by", c.cfg.DispatchBy, "batch_size", c.cfg.BatchSize)
+
+	queues := make([]chan kafka.Message, workers)
+	var wg sync.WaitGroup
+	for i := range queues {
+		queues[i] = make(chan kafka.Message, max(minQueueSize, c.cfg.BatchSize))
+		wg.Add(1)
+		go func(q <-chan kafka.Message) {
+			defer wg.Done()
+			if err := c.work(ctx, q); err != nil && ctx.Err() == nil {
+				c.logger().Error("consumer stopped", "error", err)
+				c.state.setStopErr(err)
+				cancel()
+			}
+		}(queues[i])
+	}
+
+	defer func() {
+		for _, q := range queues {
+			close(q)
+		}
+		wg.Wait()
+		c.state.stop(ctx.Err())
+		// остановка отменой ctx — штатная, ошибкой она не считается
+		if err = c.state.stopError(); err == ctx.Err() {
+			err = nil
+		}
+		c.logger().Info("consumer finished", "reason", ctx.Err())
+	}()
+
+	// failures — сколько чтений подряд не удалось. Пока брокер лежит, чтение
+	// повторяется с той же задержкой, что и запись в БД, а не в холостом цикле.
+	failures := 0
+	for {
+		m, err := c.reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil // причину выставит defer
+			}
+			d := backoff(failures, c.cfg.RetryBackoff, c.cfg.RetryMaxBackoff)
+			failures++
+			c.logger().Error("read error", "error", err, "attempt", failures, "retry_in", d.String())
+			c.state.setReadErr(err)
+
+			t := time.NewTimer(d)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return nil
+			case <-t.C:
+			}
+			continue
+		}
+		failures = 0
+		c.state.setReadErr(nil)
+
+		c.offsets.track(m)
+		c.metrics.MessageConsumed(m.Partition, m.Offset, m.HighWaterMark)
+
+		select {
+		case queues[c.route(m, workers)] <- m:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// route выбирает воркера для сообщения.
+func (c *Consumer) route(m kafka.Message, workers int) int {
+	if c.cfg.DispatchBy == DispatchByKey && len(m.Key) > 0 {
+		h := fnv.New32a()
+		_, _ = h.Write(m.Key)
+		return int(h.Sum32() % uint32(workers))
+	}
+	return m.Partition % workers
+}
+
+// work обрабатывает сообщения из очереди воркера: по одному или пачками,
+// если включён пакетный режим. Ошибка означает, что консьюмер надо остановить.
+func (c *Consumer) work(ctx context.Context, q <-chan kafka.Message) error {
+	for m := range q {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if c.cfg.BatchSize <= 1 {
+			if err := c.handleMessage(ctx, m); err != nil {
+				return err
+			}
+			c.commit(ctx, m)
+			continue
+		}
+
+		msgs := collectBatch(q, m, c.cfg.BatchSize, c.cfg.BatchTimeout)
+		if err := c.handleBatch(ctx, msgs); err != nil {
+			return err
+		}
+		c.commit(ctx, msgs...)
+	}
+	return nil
+}
+
+// collectBatch добирает пачку из очереди, пока не истечёт timeout или пачка не заполнится.
+func collectBatch(q <-chan kafka.Message, first kafka.Message, size int, timeout time.Duration) []kafka.Message {
+	msgs := make([]kafka.Message, 1, size)
+	msgs[0] = first
+
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+
+	for len(msgs) < size {
+		select {
+		case m, ok := <-q:
+			if !ok {
+				return msgs
+			}
+			msgs = append(msgs, m)
+		case <-t.C:
+			return msgs
+		}
+	}
+	return msgs
+}
+
+// commit отмечает сообщения обработанными и коммитит то, что стало непрерывным.
+func (c *Consumer) commit(ctx context.Context, msgs ...kafka.Message) {
+	c.commitMu.Lock()
+	defer c.commitMu.Unlock()
+
+	ready := c.offsets.complete(msgs...)
+	if len(ready) == 0 {
+		return
+	}
+
+	if err := c.reader.CommitMessages(ctx, ready...); err != nil {
+		c.logger().Error("commit error", "offsets", commitOffsets(ready), "error", err)
+	}
+}
+
+// commitOffsets нужен только для лога: partition -> offset.
+func commitOffsets(msgs []kafka.Message) map[int]int64 {
+	out := make(map[int]int64, len(msgs))
+	for _, m := range msgs {
+		out[m.Partition] = m.Offset
+	}
+	return out
+}
@@ -0,0 +1,75 @@
+package kafkaconsumer
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+)
+
+// GiveUpPolicy определяет, что делать с сообщением, когда повторы исчерпаны.
+type GiveUpPolicy string
+
+const (
+	// GiveUpStop останавливает консьюмера без коммита оффсета.
+	// После перезапуска сообщение будет прочитано заново.
+	GiveUpStop GiveUpPolicy = "stop"
+	// GiveUpDeadLetter отправляет сообщение в DLQ с причиной retries_exhausted
+	// и коммитит оффсет, чтобы партиция пошла дальше.
+	GiveUpDeadLetter GiveUpPolicy = "dlq"
+)
+
+// значения по умолчанию для повторов
+const (
+	defaultRetryBackoff    = 200 * time.Millisecond
+	defaultRetryMaxBackoff = 30 * time.Second
+)
+
+// withRetry выполняет fn и повторяет её при временных ошибках
+// с экспоненциальной задержкой и джиттером. Постоянные ошибки возвращаются сразу.
+// Пока идут повторы, следующие сообщения партиции не читаются.
+func (c *Consumer) withRetry(ctx context.Context, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || !isTransient(err) {
+			return err
+		}
+
+		if c.cfg.MaxRetries > 0 && attempt >= c.cfg.MaxRetries {
+			return &giveUpError{attempts: attempt + 1, err: err}
+		}
+
+		d := backoff(attempt, c.cfg.RetryBackoff, c.cfg.RetryMaxBackoff)
+		c.metrics.Retry()
+		c.logger().WarnContext(ctx, "transient error, retrying",
+			"attempt", attempt+1, "retry_in", d.String(), "error", err)
+
+		t := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// backoff считает задержку перед повтором: base*2^attempt, но не больше max,
+// со случайным разбросом в пределах [d/2, d], чтобы реплики не ломились в БД одновременно.
+func backoff(attempt int, base, max time.Duration) time.Duration {
+	if base <= 0 {
+		base = defaultRetryBackoff
+	}
+	if max <= 0 {
+		max = defaultRetryMaxBackoff
+	}
+
+	d := base
+	for i := 0; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+
+	return d/2 + rand.N(d/2+1)
+}
@@ -0,0 +1,10 @@
+package kafkaconsumer
+
+import (
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/ingest"
+)
+
+// checker разбирает заказы и учитывает нарушения бизнес-правил в логе и метриках консьюмера.
+func (c *Consumer) checker() ingest.Checker {
+	return ingest.Checker{Rules: c.rules, Log: c.logger(), Metrics: c.metrics}
+}
@@ -0,0 +1,117 @@
+package kafkaconsumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/ingest"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/lifecycle"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/models"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/repo"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/tracing"
+
+	kafka "github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Тип сообщения задаётся заголовком message-type. Без заголовка сообщение — заказ целиком,
+// сообщения неизвестного типа отклоняются с причиной unsupported_type.
+const (
+	headerMessageType = "message-type"
+	messageTypeOrder  = "order"
+	messageTypeStatus = "status"
+)
+
+// messageType возвращает тип сообщения из заголовка.
+func messageType(m kafka.Message) string {
+	for _, h := range m.Headers {
+		if strings.EqualFold(h.Key, headerMessageType) {
+			return strings.ToLower(strings.TrimSpace(string(h.Value)))
+		}
+	}
+	return messageTypeOrder
+}
+
+// processor выбирает обработчик сообщения по его типу.
+func (c *Consumer) processor(m kafka.Message) func(context.Context, kafka.Message) error {
+	switch t := messageType(m); t {
+	case messageTypeOrder:
+		return c.processPayload
+	case messageTypeStatus:
+		return c.processStatus
+	default:
+		return func(context.Context, kafka.Message) error {
+			return &ingest.RejectError{Reason: reasonUnsupportedType, Err: fmt.Errorf("unsupported message type %q", t)}
+		}
+	}
+}
+
+// processStatus разбирает событие смены статуса и применяет его.
+// Недопустимый переход отклоняется, событие для неизвестного заказа отклоняется,
+// если заказ не появился за cfg.OrderWait. Повтор уже применённого события
+// пропускается без ошибки.
+func (c *Consumer) processStatus(ctx context.Context, m kafka.Message) (err error) {
+	ctx, span := tracer.Start(ctx, "process status")
+	defer func() { tracing.End(span, err) }()
+
+	ev, err := ingest.DecodeStatus(m.Value)
+	if err != nil {
+		c.logger().WarnContext(ctx, "payload rejected", "error", err)
+		return err
+	}
+	span.SetAttributes(attribute.String("order.uid", ev.OrderUID), attribute.String("order.status", ev.Status))
+	if c.statuses == nil {
+		return &ingest.RejectError{Reason: reasonUnsupportedType, Err: errors.New("status events are not enabled")}
+	}
+
+	ch, err := c.applyStatus(ctx, ev, repo.KafkaSource(m.Topic, m.Partition, m.Offset))
+	switch {
+	case errors.Is(err, lifecycle.ErrIllegalTransition):
+		return &ingest.RejectError{Reason: reasonIllegalTransition, Err: err}
+	case errors.Is(err, repo.ErrNotFound):
+		return &ingest.RejectError{Reason: reasonUnknownOrder, Err: err}
+	case err != nil:
+		c.logger().ErrorContext(ctx, "apply status failed", "order_uid", ev.OrderUID, "error", err)
+		return err
+	}
+
+	if ch.From == ch.To {
+		c.logger().DebugContext(ctx, "status unchanged", "order_uid", ev.OrderUID, "rid", ev.Rid, "status", ch.To)
+		return nil
+	}
+	c.logger().InfoContext(ctx, "status changed", "order_uid", ev.OrderUID, "rid", ev.Rid, "from", ch.From, "to", ch.To)
+	return nil
+}
+
+// applyStatus применяет событие, дожидаясь его заказа. Событие может обогнать
+// свой заказ: заказ лежит в другой партиции, его пишет другой воркер или пачка
+// ещё не сохранена. Поэтому ErrNotFound повторяется с нарастающей задержкой,
+// пока не пройдёт cfg.OrderWait. Пока событие ждёт, партиция стоит.
+func (c *Consumer) applyStatus(ctx context.Context, ev models.StatusEvent, src repo.Source) (models.StatusChange, error) {
+	deadline := time.Now().Add(c.cfg.OrderWait)
+	for attempt := 0; ; attempt++ {
+		ch, err := c.statuses.ApplyStatus(ctx, ev, src)
+		if !errors.Is(err, repo.ErrNotFound) {
+			return ch, err
+		}
+
+		d := backoff(attempt, c.cfg.RetryBackoff, c.cfg.RetryMaxBackoff)
+		if time.Now().Add(d).After(deadline) {
+			return ch, err
+		}
+		c.metrics.Retry()
+		c.logger().InfoContext(ctx, "order not stored yet, waiting",
+			"order_uid", ev.OrderUID, "attempt", attempt+1, "retry_in", d.String())
+
+		t := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return models.StatusChange{}, ctx.Err()
+		case <-t.C:
+		}
+	}
+}
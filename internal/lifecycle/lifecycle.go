@@ -0,0 +1,77 @@
+// Package lifecycle описывает статусы заказа и его позиций
+// и допустимые переходы между ними.
+//
+//	created → paid → assembled → shipped → delivered → returned
+//	   ↓        ↓         ↓          ↓
+//	cancelled cancelled cancelled  returned
+//
+// cancelled и returned — конечные статусы. Новый заказ находится в статусе created.
+package lifecycle
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Status — статус заказа или позиции.
+type Status string
+
+// Статусы.
+const (
+	Created   Status = "created"
+	Paid      Status = "paid"
+	Assembled Status = "assembled"
+	Shipped   Status = "shipped"
+	Delivered Status = "delivered"
+	Cancelled Status = "cancelled"
+	Returned  Status = "returned"
+)
+
+var (
+	// ErrUnknownStatus — статуса нет в списке.
+	ErrUnknownStatus = errors.New("unknown status")
+	// ErrIllegalTransition — переход не разрешён таблицей переходов.
+	ErrIllegalTransition = errors.New("illegal status transition")
+)
+
+// transitions — из какого статуса в какие можно перейти.
+var transitions = map[Status][]Status{
+	Created:   {Paid, Cancelled},
+	Paid:      {Assembled, Cancelled},
+	Assembled: {Shipped, Cancelled},
+	Shipped:   {Delivered, Returned},
+	Delivered: {Returned},
+	Cancelled: nil,
+	Returned:  nil,
+}
+
+// All возвращает все статусы в порядке жизненного цикла.
+func All() []Status {
+	return []Status{Created, Paid, Assembled, Shipped, Delivered, Cancelled, Returned}
+}
+
+// Parse разбирает статус без учёта регистра.
+func Parse(s string) (Status, error) {
+	st := Status(strings.ToLower(strings.TrimSpace(s)))
+	if _, ok := transitions[st]; !ok {
+		return "", fmt.Errorf("%w %q", ErrUnknownStatus, s)
+	}
+	return st, nil
+}
+
+// Terminal сообщает, что из статуса никуда нельзя перейти.
+func (s Status) Terminal() bool {
+	return len(transitions[s]) == 0
+}
+
+// Check проверяет переход from → to. Переход в тот же статус не разрешён:
+// повтор события вызывающая сторона обрабатывает сама.
+func Check(from, to Status) error {
+	for _, next := range transitions[from] {
+		if next == to {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s → %s", ErrIllegalTransition, from, to)
+}
@@ -0,0 +1,48 @@
+package lifecycle
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	allowed := [][2]Status{
+		{Created, Paid}, {Created, Cancelled},
+		{Paid, Assembled}, {Paid, Cancelled},
+		{Assembled, Shipped}, {Assembled, Cancelled},
+		{Shipped, Delivered}, {Shipped, Returned},
+		{Delivered, Returned},
+	}
+	ok := make(map[[2]Status]bool, len(allowed))
+	for _, tr := range allowed {
+		ok[tr] = true
+	}
+
+	for _, from := range All() {
+		for _, to := range All() {
+			err := Check(from, to)
+			if ok[[2]Status{from, to}] {
+				if err != nil {
+					t.Errorf("%s → %s: unexpected error %v", from, to, err)
+				}
+				continue
+			}
+			if !errors.Is(err, ErrIllegalTransition) {
+				t.Errorf("%s → %s: expected ErrIllegalTransition, got %v", from, to, err)
+			}
+		}
+	}
+
+	if !Cancelled.Terminal() || !Returned.Terminal() || Shipped.Terminal() {
+		t.Fatal("only cancelled and returned should be terminal")
+	}
+}
+
+func TestParse(t *testing.T) {
+	if st, err := Parse(" Paid "); err != nil || st != Paid {
+		t.Fatalf("Parse = %q, %v", st, err)
+	}
+	if _, err := Parse("lost"); !errors.Is(err, ErrUnknownStatus) {
+		t.Fatalf("expected ErrUnknownStatus, got %v", err)
+	}
+}
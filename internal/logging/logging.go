@@ -0,0 +1,138 @@
+// Package logging настраивает slog для сервиса и переносит поля для логов через контекст.
+//
+// Идентификатор HTTP-запроса или координаты Kafka-сообщения кладутся в контекст
+// один раз, а дальше попадают в каждую запись, сделанную через *Context-методы
+// логгера (InfoContext, ErrorContext и т.д.), в каком бы пакете она ни была.
+// Туда же добавляются trace_id и span_id, если в контексте есть спан.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Форматы вывода.
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// Поля, которые Handler добавляет из контекста.
+const (
+	KeyRequestID = "request_id"
+	KeyTraceID   = "trace_id"
+	KeySpanID    = "span_id"
+)
+
+// New создаёт логгер с заданным уровнем (debug, info, warn, error) и форматом (json, text).
+func New(w io.Writer, level, format string) (*slog.Logger, error) {
+	lvl, err := ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	var h slog.Handler
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", FormatJSON:
+		h = slog.NewJSONHandler(w, opts)
+	case FormatText:
+		h = slog.NewTextHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("unknown log format %q, expected json or text", format)
+	}
+	return slog.New(NewHandler(h)), nil
+}
+
+// ParseLevel разбирает уровень логирования. Пустая строка — info.
+func ParseLevel(s string) (slog.Level, error) {
+	var lvl slog.Level
+	if strings.TrimSpace(s) == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := lvl.UnmarshalText([]byte(strings.TrimSpace(s))); err != nil {
+		return 0, fmt.Errorf("unknown log level %q, expected debug, info, warn or error", s)
+	}
+	return lvl, nil
+}
+
+// ctxKey — ключ полей в контексте.
+type ctxKey struct{}
+
+// WithAttrs добавляет поля, которые попадут во все записи с этим контекстом.
+func WithAttrs(ctx context.Context, attrs ...slog.Attr) context.Context {
+	if len(attrs) == 0 {
+		return ctx
+	}
+	prev := attrsFrom(ctx)
+	merged := make([]slog.Attr, 0, len(prev)+len(attrs))
+	merged = append(merged, prev...)
+	merged = append(merged, attrs...)
+	return context.WithValue(ctx, ctxKey{}, merged)
+}
+
+// WithRequestID кладёт в контекст идентификатор HTTP-запроса.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return WithAttrs(ctx, slog.String(KeyRequestID, id))
+}
+
+// RequestID возвращает идентификатор запроса из контекста или пустую строку.
+func RequestID(ctx context.Context) string {
+	attrs := attrsFrom(ctx)
+	for i := len(attrs) - 1; i >= 0; i-- {
+		if attrs[i].Key == KeyRequestID {
+			return attrs[i].Value.String()
+		}
+	}
+	return ""
+}
+
+func attrsFrom(ctx context.Context) []slog.Attr {
+	if ctx == nil {
+		return nil
+	}
+	attrs, _ := ctx.Value(ctxKey{}).([]slog.Attr)
+	return attrs
+}
+
+// Handler дописывает к записи поля из контекста и идентификаторы
+// текущего спана OpenTelemetry, чтобы от строки лога можно было перейти к трассе.
+type Handler struct {
+	slog.Handler
+}
+
+// NewHandler оборачивает h так, чтобы поля из контекста попадали в записи.
+func NewHandler(h slog.Handler) *Handler {
+	return &Handler{Handler: h}
+}
+
+// Handle добавляет поля из контекста и передаёт запись дальше.
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	attrs := attrsFrom(ctx)
+	sc := trace.SpanContextFromContext(ctx)
+	if len(attrs) == 0 && !sc.IsValid() {
+		return h.Handler.Handle(ctx, r)
+	}
+
+	r = r.Clone()
+	r.AddAttrs(attrs...)
+	if sc.IsValid() {
+		r.AddAttrs(slog.String(KeyTraceID, sc.TraceID().String()), slog.String(KeySpanID, sc.SpanID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+// WithAttrs сохраняет обёртку у производного обработчика.
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &Handler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup сохраняет обёртку у производного обработчика.
+func (h *Handler) WithGroup(name string) slog.Handler {
+	return &Handler{Handler: h.Handler.WithGroup(name)}
+}
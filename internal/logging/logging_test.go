@@ -0,0 +1,58 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+
+func TestContextAttrs(t *testing.T) {
+	var buf bytes.Buffer
+	lg, err := New(&buf, "debug", "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := WithRequestID(context.Background(), "req-1")
+	ctx = WithAttrs(ctx, slog.Int("partition", 2), slog.Int64("offset", 42))
+	lg.With("component", "test").InfoContext(ctx, "hello")
+
+	var rec map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatalf("log is not json: %v (%s)", err, buf.String())
+	}
+	if rec["request_id"] != "req-1" || rec["partition"] != float64(2) || rec["offset"] != float64(42) || rec["component"] != "test" {
+		t.Fatalf("unexpected record: %v", rec)
+	}
+	if got := RequestID(ctx); got != "req-1" {
+		t.Fatalf("RequestID = %q", got)
+	}
+	if got := RequestID(context.Background()); got != "" {
+		t.Fatalf("RequestID on empty context = %q", got)
+	}
+}
+
+func TestLevel(t *testing.T) {
+	var buf bytes.Buffer
+	lg, err := New(&buf, "warn", "text")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lg.Info("skipped")
+	if buf.Len() != 0 {
+		t.Fatalf("info written at warn level: %s", buf.String())
+	}
+	lg.Warn("written")
+	if buf.Len() == 0 {
+		t.Fatal("warn not written")
+	}
+
+	if _, err := New(&buf, "verbose", "json"); err == nil {
+		t.Fatal("expected error for unknown level")
+	}
+	if _, err := New(&buf, "info", "xml"); err == nil {
+		t.Fatal("expected error for unknown format")
+	}
+}
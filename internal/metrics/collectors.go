@@ -0,0 +1,111 @@
+package metrics
+
+import (
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/cache"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// CacheStatser — кэш, который умеет отдавать свои счётчики.
+type CacheStatser interface {
+	Stats() cache.Stats
+}
+
+// cacheCollector снимает счётчики кэша в момент сбора метрик.
+type cacheCollector struct {
+	src CacheStatser
+
+	hits, misses, evictions, expirations, size, capacity, bytes, maxBytes *prometheus.Desc
+}
+
+// NewCacheCollector создаёт коллектор метрик кэша заказов.
+func NewCacheCollector(src CacheStatser) prometheus.Collector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "cache", name), help, nil, nil)
+	}
+	return &cacheCollector{
+		src:         src,
+		hits:        desc("hits_total", "Попаданий в кэш."),
+		misses:      desc("misses_total", "Промахов кэша."),
+		evictions:   desc("evictions_total", "Записей вытеснено из-за лимита."),
+		expirations: desc("expirations_total", "Записей удалено по TTL."),
+		size:        desc("size", "Записей в кэше."),
+		capacity:    desc("capacity", "Лимит записей в кэше."),
+		bytes:       desc("bytes", "Примерный объём заказов в кэше, байт."),
+		maxBytes:    desc("max_bytes", "Бюджет памяти кэша в байтах, 0 — без ограничения."),
+	}
+}
+
+func (c *cacheCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.hits
+	ch <- c.misses
+	ch <- c.evictions
+	ch <- c.expirations
+	ch <- c.size
+	ch <- c.capacity
+	ch <- c.bytes
+	ch <- c.maxBytes
+}
+
+func (c *cacheCollector) Collect(ch chan<- prometheus.Metric) {
+	st := c.src.Stats()
+	ch <- prometheus.MustNewConstMetric(c.hits, prometheus.CounterValue, float64(st.Hits))
+	ch <- prometheus.MustNewConstMetric(c.misses, prometheus.CounterValue, float64(st.Misses))
+	ch <- prometheus.MustNewConstMetric(c.evictions, prometheus.CounterValue, float64(st.Evictions))
+	ch <- prometheus.MustNewConstMetric(c.expirations, prometheus.CounterValue, float64(st.Expirations))
+	ch <- prometheus.MustNewConstMetric(c.size, prometheus.GaugeValue, float64(st.Size))
+	ch <- prometheus.MustNewConstMetric(c.capacity, prometheus.GaugeValue, float64(st.Capacity))
+	ch <- prometheus.MustNewConstMetric(c.bytes, prometheus.GaugeValue, float64(st.Bytes))
+	ch <- prometheus.MustNewConstMetric(c.maxBytes, prometheus.GaugeValue, float64(st.MaxBytes))
+}
+
+// poolCollector снимает статистику пула соединений pgxpool.
+type poolCollector struct {
+	pool *pgxpool.Pool
+
+	acquired, idle, total, max        *prometheus.Desc
+	acquires, emptyAcquires, canceled *prometheus.Desc
+	acquireDuration                   *prometheus.Desc
+}
+
+// NewPoolCollector создаёт коллектор метрик пула соединений с БД.
+func NewPoolCollector(pool *pgxpool.Pool) prometheus.Collector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "db_pool", name), help, nil, nil)
+	}
+	return &poolCollector{
+		pool:            pool,
+		acquired:        desc("acquired_conns", "Соединений сейчас занято."),
+		idle:            desc("idle_conns", "Свободных соединений."),
+		total:           desc("total_conns", "Всего открытых соединений."),
+		max:             desc("max_conns", "Максимум соединений в пуле."),
+		acquires:        desc("acquires_total", "Успешных получений соединения."),
+		emptyAcquires:   desc("empty_acquires_total", "Получений, которым пришлось ждать свободное соединение."),
+		canceled:        desc("canceled_acquires_total", "Получений, отменённых по контексту."),
+		acquireDuration: desc("acquire_duration_seconds_total", "Суммарное время ожидания соединения."),
+	}
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.acquired
+	ch <- c.idle
+	ch <- c.total
+	ch <- c.max
+	ch <- c.acquires
+	ch <- c.emptyAcquires
+	ch <- c.canceled
+	ch <- c.acquireDuration
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	st := c.pool.Stat()
+	ch <- prometheus.MustNewConstMetric(c.acquired, prometheus.GaugeValue, float64(st.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(c.idle, prometheus.GaugeValue, float64(st.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(c.total, prometheus.GaugeValue, float64(st.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(c.max, prometheus.GaugeValue, float64(st.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(c.acquires, prometheus.CounterValue, float64(st.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.emptyAcquires, prometheus.CounterValue, float64(st.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.canceled, prometheus.CounterValue, float64(st.CanceledAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.acquireDuration, prometheus.CounterValue, st.AcquireDuration().Seconds())
+}
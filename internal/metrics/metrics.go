@@ -0,0 +1,196 @@
+// Package metrics собирает метрики сервиса и отдаёт их в формате Prometheus.
+// Все методы Metrics можно вызывать на nil: тогда метрики просто не пишутся,
+// поэтому консьюмеру и серверу не нужно проверять, подключены ли они.
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/repo"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace — общий префикс всех метрик сервиса.
+const namespace = "order_service"
+
+// Metrics хранит собственный реестр и метрики сервиса.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	consumed    *prometheus.CounterVec
+	stored      prometheus.Counter
+	rejected    *prometheus.CounterVec
+	retries     prometheus.Counter
+	stale       prometheus.Counter
+	violations  *prometheus.CounterVec
+	lag         *prometheus.GaugeVec
+	dbDuration  *prometheus.HistogramVec
+	httpLatency *prometheus.HistogramVec
+}
+
+// New создаёт реестр с метриками сервиса, рантайма Go и процесса.
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		consumed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "kafka",
+			Name:      "messages_consumed_total",
+			Help:      "Сообщений прочитано из Kafka.",
+		}, []string{"partition"}),
+		stored: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "kafka",
+			Name:      "messages_stored_total",
+			Help:      "Заказов из Kafka сохранено в БД.",
+		}),
+		rejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "kafka",
+			Name:      "messages_rejected_total",
+			Help:      "Сообщений отклонено (DLQ / rejected_messages) по причинам.",
+		}, []string{"reason"}),
+		retries: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "kafka",
+			Name:      "retries_total",
+			Help:      "Повторов обработки после временных ошибок.",
+		}),
+		stale: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "kafka",
+			Name:      "stale_updates_skipped_total",
+			Help:      "Копий заказов пропущено, потому что в БД уже есть более свежая.",
+		}),
+		violations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "validation",
+			Name:      "rule_violations_total",
+			Help:      "Нарушений бизнес-правил заказа по правилам и серьёзности (reject / warn).",
+		}, []string{"rule", "severity"}),
+		lag: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "kafka",
+			Name:      "consumer_lag",
+			Help:      "Отставание консьюмера в сообщениях по партициям (по последнему прочитанному).",
+		}, []string{"partition"}),
+		dbDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "db",
+			Name:      "query_duration_seconds",
+			Help:      "Длительность операций репозитория заказов.",
+			Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		}, []string{"op", "result"}),
+		httpLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "Длительность HTTP-запросов по шаблону маршрута и коду ответа.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.consumed, m.stored, m.rejected, m.retries, m.stale, m.violations, m.lag,
+		m.dbDuration, m.httpLatency,
+	)
+	return m
+}
+
+// Register добавляет в реестр дополнительные коллекторы (кэш, пул соединений).
+func (m *Metrics) Register(cs ...prometheus.Collector) {
+	if m == nil {
+		return
+	}
+	m.registry.MustRegister(cs...)
+}
+
+// Handler отдаёт метрики в текстовом формате Prometheus.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// MessageConsumed учитывает прочитанное сообщение и отставание партиции.
+// highWaterMark — оффсет следующего сообщения, которое появится в партиции.
+func (m *Metrics) MessageConsumed(partition int, offset, highWaterMark int64) {
+	if m == nil {
+		return
+	}
+	p := strconv.Itoa(partition)
+	m.consumed.WithLabelValues(p).Inc()
+	m.lag.WithLabelValues(p).Set(float64(max(highWaterMark-offset-1, 0)))
+}
+
+// MessagesStored учитывает сохранённые заказы.
+func (m *Metrics) MessagesStored(n int) {
+	if m == nil {
+		return
+	}
+	m.stored.Add(float64(n))
+}
+
+// MessageRejected учитывает отклонённое сообщение.
+func (m *Metrics) MessageRejected(reason string) {
+	if m == nil {
+		return
+	}
+	m.rejected.WithLabelValues(reason).Inc()
+}
+
+// StaleSkipped учитывает копии заказов, пропущенные как устаревшие.
+func (m *Metrics) StaleSkipped(n int) {
+	if m == nil || n == 0 {
+		return
+	}
+	m.stale.Add(float64(n))
+}
+
+// RuleViolation учитывает нарушение бизнес-правила заказа.
+func (m *Metrics) RuleViolation(rule, severity string) {
+	if m == nil {
+		return
+	}
+	m.violations.WithLabelValues(rule, severity).Inc()
+}
+
+// Retry учитывает повтор после временной ошибки.
+func (m *Metrics) Retry() {
+	if m == nil {
+		return
+	}
+	m.retries.Inc()
+}
+
+// ObserveQuery записывает длительность операции репозитория.
+// Сигнатура совпадает с repo.QueryObserver.
+func (m *Metrics) ObserveQuery(op string, took time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.dbDuration.WithLabelValues(op, queryResult(err)).Observe(took.Seconds())
+}
+
+// queryResult сводит ошибку репозитория к небольшому набору значений метки.
+func queryResult(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, repo.ErrNotFound):
+		return "not_found"
+	case errors.Is(err, repo.ErrConflict):
+		return "conflict"
+	case errors.Is(err, repo.ErrUnavailable):
+		return "unavailable"
+	case errors.Is(err, repo.ErrStale):
+		return "stale"
+	}
+	return "error"
+}
@@ -0,0 +1,117 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/cache"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/repo"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestNilMetricsIsNoop(t *testing.T) {
+	var m *Metrics
+	m.MessageConsumed(0, 1, 2)
+	m.MessagesStored(1)
+	m.MessageRejected("bad_json")
+	m.Retry()
+	m.StaleSkipped(1)
+	m.RuleViolation("amount", "reject")
+	m.ObserveQuery(repo.OpGetOrder, time.Millisecond, nil)
+	m.Register()
+
+	h := m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
+
+func TestConsumerMetrics(t *testing.T) {
+	m := New()
+	m.MessageConsumed(1, 10, 15)
+	m.MessageConsumed(1, 14, 15)
+	m.MessageRejected("validation")
+	m.MessagesStored(3)
+	m.StaleSkipped(2)
+	m.RuleViolation("amount", "warn")
+
+	if got := testutil.ToFloat64(m.consumed.WithLabelValues("1")); got != 2 {
+		t.Fatalf("consumed = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(m.lag.WithLabelValues("1")); got != 0 {
+		t.Fatalf("lag after last message = %v, want 0", got)
+	}
+	if got := testutil.ToFloat64(m.rejected.WithLabelValues("validation")); got != 1 {
+		t.Fatalf("rejected = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(m.stored); got != 3 {
+		t.Fatalf("stored = %v, want 3", got)
+	}
+	if got := testutil.ToFloat64(m.stale); got != 2 {
+		t.Fatalf("stale = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(m.violations.WithLabelValues("amount", "warn")); got != 1 {
+		t.Fatalf("violations = %v, want 1", got)
+	}
+}
+
+func TestQueryResult(t *testing.T) {
+	cases := map[error]string{
+		nil:                                      "ok",
+		repo.ErrNotFound:                         "not_found",
+		fmt.Errorf("%w: x", repo.ErrUnavailable): "unavailable",
+		fmt.Errorf("%w: x", repo.ErrStale):       "stale",
+		errors.New("boom"):                       "error",
+	}
+	for err, want := range cases {
+		if got := queryResult(err); got != want {
+			t.Errorf("queryResult(%v) = %s, want %s", err, got, want)
+		}
+	}
+}
+
+func TestHTTPMiddlewareUsesRoutePattern(t *testing.T) {
+	m := New()
+	r := chi.NewRouter()
+	r.Use(m.Middleware)
+	r.Get("/order/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	for _, id := range []string{"a", "b", "c"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/order/"+id, nil))
+	}
+
+	if n := testutil.CollectAndCount(m.httpLatency); n != 1 {
+		t.Fatalf("expected one series for the route, got %d", n)
+	}
+
+	rr := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	want := `order_service_http_request_duration_seconds_count{method="GET",route="/order/{id}",status="404"} 3`
+	if !strings.Contains(rr.Body.String(), want) {
+		t.Fatalf("metrics output has no %q", want)
+	}
+}
+
+func TestHandlerExposesCacheStats(t *testing.T) {
+	m := New()
+	c := cache.NewWithLimit(10, cache.WithMaxBytes(1<<20))
+	m.Register(NewCacheCollector(c))
+	c.Get("missing")
+
+	rr := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	body := rr.Body.String()
+	for _, want := range []string{"order_service_cache_misses_total 1", "order_service_cache_capacity 10", "order_service_cache_max_bytes 1.048576e+06", "go_goroutines"} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("metrics output has no %q", want)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+// Package migrate накатывает и откатывает миграции схемы и помнит,
+// какие из них применены, в таблице schema_migrations.
+//
+// Миграция — пара файлов NNN_name.up.sql и NNN_name.down.sql, где NNN — номер версии.
+// Каждая миграция выполняется в своей транзакции вместе с записью в schema_migrations,
+// а вся команда — под advisory-локом, поэтому реплики, стартующие одновременно,
+// не накатят одну миграцию дважды.
+package migrate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Ошибки загрузки и применения миграций.
+var (
+	ErrBadName          = errors.New("bad migration file name")
+	ErrDuplicate        = errors.New("duplicate migration version")
+	ErrNoUp             = errors.New("migration has no up file")
+	ErrNoDown           = errors.New("migration has no down file")
+	ErrUnknownVersion   = errors.New("unknown migration version")
+	ErrChecksumMismatch = errors.New("migration checksum mismatch")
+)
+
+// fileName — NNN_name.up.sql или NNN_name.down.sql.
+var fileName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Migration — одна миграция схемы.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string // пусто — миграцию нельзя откатить
+	// Checksum — sha256 up-файла. Сохраняется при накате, чтобы заметить,
+	// что уже применённую миграцию отредактировали.
+	Checksum string
+}
+
+// String возвращает имя миграции как у файлов, без суффикса: 001_create_tables.
+func (m Migration) String() string {
+	return fmt.Sprintf("%03d_%s", m.Version, m.Name)
+}
+
+// Load читает миграции из корня fsys и сортирует их по версии.
+// Остальные файлы (не *.sql) пропускаются.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".sql") {
+			continue
+		}
+		parts := fileName.FindStringSubmatch(e.Name())
+		if parts == nil {
+			return nil, fmt.Errorf("%w: %s", ErrBadName, e.Name())
+		}
+		version, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrBadName, e.Name())
+		}
+		data, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: parts[2]}
+			byVersion[version] = m
+		}
+		if m.Name != parts[2] {
+			return nil, fmt.Errorf("%w %d: %s and %s", ErrDuplicate, version, m.Name, parts[2])
+		}
+		if parts[3] == "up" {
+			m.Up = string(data)
+			m.Checksum = checksum(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	out := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("%w: %s", ErrNoUp, m)
+		}
+		out = append(out, *m)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Create создаёт в dir пустую пару файлов следующей версии и возвращает их пути.
+func Create(dir, name string) (up, down string, err error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	name = strings.Join(strings.FieldsFunc(name, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9')
+	}), "_")
+	if name == "" {
+		return "", "", fmt.Errorf("%w: empty name", ErrBadName)
+	}
+
+	migrations, err := Load(os.DirFS(dir))
+	if err != nil {
+		return "", "", err
+	}
+	var next int64 = 1
+	if len(migrations) > 0 {
+		next = migrations[len(migrations)-1].Version + 1
+	}
+
+	base := filepath.Join(dir, fmt.Sprintf("%03d_%s", next, name))
+	up, down = base+".up.sql", base+".down.sql"
+	if err := writeNew(up, "-- Миграция вверх: \n"); err != nil {
+		return "", "", err
+	}
+	if err := writeNew(down, "-- Миграция вниз: \n"); err != nil {
+		return "", "", err
+	}
+	return up, down, nil
+}
+
+// writeNew создаёт файл, но не перезаписывает существующий.
+func writeNew(path, body string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(body); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,168 @@
+package migrate
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/migrations"
+)
+
+func testFS() fstest.MapFS {
+	return fstest.MapFS{
+		"001_create_tables.up.sql":   {Data: []byte("CREATE TABLE a (id INT);")},
+		"001_create_tables.down.sql": {Data: []byte("DROP TABLE a;")},
+		"002_add_index.up.sql":       {Data: []byte("CREATE INDEX a_id ON a(id);")},
+		"002_add_index.down.sql":     {Data: []byte("DROP INDEX a_id;")},
+		"003_no_down.up.sql":         {Data: []byte("ALTER TABLE a ADD COLUMN b INT;")},
+		"README.md":                  {Data: []byte("not a migration")},
+	}
+}
+
+func versions(ms []Migration) []int64 {
+	out := []int64{}
+	for _, m := range ms {
+		out = append(out, m.Version)
+	}
+	return out
+}
+
+func appliedUpTo(all []Migration, v int64) map[int64]Applied {
+	out := map[int64]Applied{}
+	for _, m := range all {
+		if m.Version <= v {
+			out[m.Version] = Applied{Version: m.Version, Name: m.Name, Checksum: m.Checksum}
+		}
+	}
+	return out
+}
+
+func TestLoad(t *testing.T) {
+	ms, err := Load(testFS())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(versions(ms), []int64{1, 2, 3}) {
+		t.Fatalf("versions = %v", versions(ms))
+	}
+	if ms[0].String() != "001_create_tables" || ms[0].Down != "DROP TABLE a;" || ms[0].Checksum == "" {
+		t.Fatalf("unexpected migration %+v", ms[0])
+	}
+	if ms[2].Down != "" {
+		t.Fatal("003 has no down file")
+	}
+
+	bad := testFS()
+	bad["002_other.up.sql"] = &fstest.MapFile{Data: []byte("SELECT 1;")}
+	if _, err := Load(bad); !errors.Is(err, ErrDuplicate) {
+		t.Fatalf("expected ErrDuplicate, got %v", err)
+	}
+
+	bad = testFS()
+	bad["004_only_down.down.sql"] = &fstest.MapFile{Data: []byte("SELECT 1;")}
+	if _, err := Load(bad); !errors.Is(err, ErrNoUp) {
+		t.Fatalf("expected ErrNoUp, got %v", err)
+	}
+
+	bad = testFS()
+	bad["fix.sql"] = &fstest.MapFile{Data: []byte("SELECT 1;")}
+	if _, err := Load(bad); !errors.Is(err, ErrBadName) {
+		t.Fatalf("expected ErrBadName, got %v", err)
+	}
+}
+
+func TestEmbeddedMigrationsLoad(t *testing.T) {
+	ms, err := Load(migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range ms {
+		if m.Version != int64(i+1) || m.Down == "" {
+			t.Fatalf("migration %s: versions must be contiguous and reversible", m)
+		}
+	}
+}
+
+func TestPlans(t *testing.T) {
+	all, err := Load(testFS())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := versions(planUp(all, appliedUpTo(all, 1), 0)); !reflect.DeepEqual(got, []int64{2, 3}) {
+		t.Fatalf("up all = %v", got)
+	}
+	if got := versions(planUp(all, nil, 1)); !reflect.DeepEqual(got, []int64{1}) {
+		t.Fatalf("up 1 = %v", got)
+	}
+
+	down, err := planDown(all, appliedUpTo(all, 2), 1)
+	if err != nil || !reflect.DeepEqual(versions(down), []int64{2}) {
+		t.Fatalf("down 1 = %v, %v", versions(down), err)
+	}
+	if _, err := planDown(all, appliedUpTo(all, 3), 1); !errors.Is(err, ErrNoDown) {
+		t.Fatalf("expected ErrNoDown, got %v", err)
+	}
+	applied := appliedUpTo(all, 2)
+	applied[9] = Applied{Version: 9, Name: "gone"}
+	if _, err := planDown(all, applied, 0); !errors.Is(err, ErrUnknownVersion) {
+		t.Fatalf("expected ErrUnknownVersion, got %v", err)
+	}
+
+	up, down, err := planGoto(all, appliedUpTo(all, 2), 1)
+	if err != nil || len(up) != 0 || !reflect.DeepEqual(versions(down), []int64{2}) {
+		t.Fatalf("goto 1 = %v / %v, %v", versions(up), versions(down), err)
+	}
+	up, down, err = planGoto(all, appliedUpTo(all, 0), 2)
+	if err != nil || !reflect.DeepEqual(versions(up), []int64{1, 2}) || len(down) != 0 {
+		t.Fatalf("goto 2 = %v / %v, %v", versions(up), versions(down), err)
+	}
+	if _, _, err := planGoto(all, nil, 7); !errors.Is(err, ErrUnknownVersion) {
+		t.Fatalf("expected ErrUnknownVersion, got %v", err)
+	}
+}
+
+func TestVerifyAndStatus(t *testing.T) {
+	all, err := Load(testFS())
+	if err != nil {
+		t.Fatal(err)
+	}
+	applied := appliedUpTo(all, 2)
+	if err := verify(all, applied); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	applied[2] = Applied{Version: 2, Name: "add_index", Checksum: "edited"}
+	applied[9] = Applied{Version: 9, Name: "gone"}
+	if err := verify(all, applied); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected ErrChecksumMismatch, got %v", err)
+	}
+
+	st := status(all, applied)
+	if len(st) != 4 || !st[0].Applied || !st[1].Modified || st[2].Applied || !st[3].Missing {
+		t.Fatalf("unexpected status %+v", st)
+	}
+}
+
+func TestCreate(t *testing.T) {
+	dir := t.TempDir()
+	for name, f := range testFS() {
+		if err := os.WriteFile(dir+"/"+name, f.Data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	up, down, err := Create(dir, "Add Orders Note")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if up != dir+"/004_add_orders_note.up.sql" || down != dir+"/004_add_orders_note.down.sql" {
+		t.Fatalf("unexpected files %s, %s", up, down)
+	}
+	// пустые миграции тоже загружаются
+	if _, err := Load(os.DirFS(dir)); err != nil {
+		t.Fatal(err)
+	}
+}
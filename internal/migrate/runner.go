@@ -0,0 +1,358 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// lockKey — ключ advisory-лока, под которым выполняются команды мигратора.
+const lockKey int64 = 0x77626f7264657273 // "wborders"
+
+const createTableSQL = `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    BIGINT PRIMARY KEY,
+		name       TEXT NOT NULL,
+		checksum   TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)
+`
+
+// Applied — запись о применённой миграции из schema_migrations.
+type Applied struct {
+	Version   int64
+	Name      string
+	Checksum  string
+	AppliedAt time.Time
+}
+
+// Status — состояние одной миграции для команды status.
+type Status struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+	// Modified — up-файл изменился после применения.
+	Modified bool
+	// Missing — миграция применена, но её файла больше нет.
+	Missing bool
+}
+
+// Migrator применяет миграции к базе через одно соединение:
+// session-level advisory-лок держится на нём до конца команды.
+type Migrator struct {
+	conn       *pgx.Conn
+	migrations []Migration
+	log        *slog.Logger
+}
+
+// Option настраивает мигратор.
+type Option func(*Migrator)
+
+// WithLogger задаёт логгер. По умолчанию slog.Default().
+func WithLogger(l *slog.Logger) Option {
+	return func(m *Migrator) { m.log = l }
+}
+
+// New создаёт мигратор для миграций, загруженных Load.
+func New(conn *pgx.Conn, migrations []Migration, opts ...Option) *Migrator {
+	m := &Migrator{conn: conn, migrations: migrations, log: slog.Default()}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Up накатывает n следующих неприменённых миграций, n <= 0 — все.
+// Если применённую миграцию отредактировали, ничего не накатывается
+// и возвращается ErrChecksumMismatch.
+func (m *Migrator) Up(ctx context.Context, n int) (done []Migration, err error) {
+	err = m.withLock(ctx, func(applied map[int64]Applied) error {
+		if err := verify(m.migrations, applied); err != nil {
+			return err
+		}
+		for _, mig := range planUp(m.migrations, applied, n) {
+			if err := m.apply(ctx, mig, true); err != nil {
+				return err
+			}
+			done = append(done, mig)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down откатывает n последних применённых миграций, n <= 0 — все.
+func (m *Migrator) Down(ctx context.Context, n int) (done []Migration, err error) {
+	err = m.withLock(ctx, func(applied map[int64]Applied) error {
+		plan, err := planDown(m.migrations, applied, n)
+		if err != nil {
+			return err
+		}
+		for _, mig := range plan {
+			if err := m.apply(ctx, mig, false); err != nil {
+				return err
+			}
+			done = append(done, mig)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Goto приводит базу к версии v: накатывает недостающие миграции до v включительно
+// и откатывает применённые выше v. Версия 0 — откатить всё.
+func (m *Migrator) Goto(ctx context.Context, v int64) (up, down []Migration, err error) {
+	err = m.withLock(ctx, func(applied map[int64]Applied) error {
+		if err := verify(m.migrations, applied); err != nil {
+			return err
+		}
+		toApply, toRevert, err := planGoto(m.migrations, applied, v)
+		if err != nil {
+			return err
+		}
+		for _, mig := range toRevert {
+			if err := m.apply(ctx, mig, false); err != nil {
+				return err
+			}
+			down = append(down, mig)
+		}
+		for _, mig := range toApply {
+			if err := m.apply(ctx, mig, true); err != nil {
+				return err
+			}
+			up = append(up, mig)
+		}
+		return nil
+	})
+	return up, down, err
+}
+
+// Force записывает в schema_migrations, что применены ровно миграции до v включительно,
+// не выполняя их SQL. Нужен, чтобы поправить учёт руками: после ручного исправления
+// схемы или чтобы принять отредактированную миграцию (checksum перезаписывается).
+func (m *Migrator) Force(ctx context.Context, v int64) error {
+	if err := checkVersion(m.migrations, v); err != nil {
+		return err
+	}
+	return m.withLock(ctx, func(map[int64]Applied) error {
+		return pgx.BeginFunc(ctx, m.conn, func(tx pgx.Tx) error {
+			if _, err := tx.Exec(ctx, `DELETE FROM schema_migrations WHERE version > $1`, v); err != nil {
+				return err
+			}
+			for _, mig := range m.migrations {
+				if mig.Version > v {
+					break
+				}
+				if _, err := tx.Exec(ctx, `
+					INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)
+					ON CONFLICT (version) DO UPDATE SET name = EXCLUDED.name, checksum = EXCLUDED.checksum
+				`, mig.Version, mig.Name, mig.Checksum); err != nil {
+					return err
+				}
+			}
+			m.log.InfoContext(ctx, "migration version forced", "version", v)
+			return nil
+		})
+	})
+}
+
+// Status возвращает состояние всех миграций: из файлов и из schema_migrations.
+func (m *Migrator) Status(ctx context.Context) (out []Status, err error) {
+	err = m.withLock(ctx, func(applied map[int64]Applied) error {
+		out = status(m.migrations, applied)
+		return nil
+	})
+	return out, err
+}
+
+// withLock берёт advisory-лок, создаёт schema_migrations, если её нет,
+// и передаёт в fn применённые миграции.
+func (m *Migrator) withLock(ctx context.Context, fn func(applied map[int64]Applied) error) (err error) {
+	if _, err := m.conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, lockKey); err != nil {
+		return fmt.Errorf("advisory lock: %w", err)
+	}
+	defer func() {
+		// лок снимется и при закрытии соединения, поэтому ошибка только логируется
+		if _, uerr := m.conn.Exec(context.WithoutCancel(ctx), `SELECT pg_advisory_unlock($1)`, lockKey); uerr != nil {
+			m.log.WarnContext(ctx, "advisory unlock failed", "error", uerr)
+		}
+	}()
+
+	if _, err := m.conn.Exec(ctx, createTableSQL); err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return err
+	}
+	return fn(applied)
+}
+
+func (m *Migrator) applied(ctx context.Context) (map[int64]Applied, error) {
+	rows, err := m.conn.Query(ctx, `SELECT version, name, checksum, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	list, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Applied, error) {
+		var a Applied
+		err := row.Scan(&a.Version, &a.Name, &a.Checksum, &a.AppliedAt)
+		return a, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[int64]Applied, len(list))
+	for _, a := range list {
+		out[a.Version] = a
+	}
+	return out, nil
+}
+
+// apply выполняет миграцию и меняет schema_migrations в одной транзакции:
+// упавшая миграция не оставляет ни половины схемы, ни записи о себе.
+func (m *Migrator) apply(ctx context.Context, mig Migration, up bool) error {
+	start := time.Now()
+	err := pgx.BeginFunc(ctx, m.conn, func(tx pgx.Tx) error {
+		if up {
+			if _, err := tx.Exec(ctx, mig.Up); err != nil {
+				return err
+			}
+			_, err := tx.Exec(ctx, `INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)`,
+				mig.Version, mig.Name, mig.Checksum)
+			return err
+		}
+		if _, err := tx.Exec(ctx, mig.Down); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, `DELETE FROM schema_migrations WHERE version = $1`, mig.Version)
+		return err
+	})
+	direction := "up"
+	if !up {
+		direction = "down"
+	}
+	if err != nil {
+		return fmt.Errorf("migration %s %s: %w", mig, direction, err)
+	}
+	m.log.InfoContext(ctx, "migration applied", "migration", mig.String(), "direction", direction,
+		"duration", time.Since(start))
+	return nil
+}
+
+// planUp — неприменённые миграции по возрастанию версии, не больше n (n <= 0 — все).
+func planUp(all []Migration, applied map[int64]Applied, n int) []Migration {
+	var out []Migration
+	for _, mig := range all {
+		if _, ok := applied[mig.Version]; ok {
+			continue
+		}
+		if n > 0 && len(out) == n {
+			break
+		}
+		out = append(out, mig)
+	}
+	return out
+}
+
+// planDown — применённые миграции по убыванию версии, не больше n (n <= 0 — все).
+func planDown(all []Migration, applied map[int64]Applied, n int) ([]Migration, error) {
+	versions := make([]int64, 0, len(applied))
+	for v := range applied {
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+	if n > 0 && len(versions) > n {
+		versions = versions[:n]
+	}
+
+	out := make([]Migration, 0, len(versions))
+	for _, v := range versions {
+		mig, ok := find(all, v)
+		if !ok {
+			return nil, fmt.Errorf("%w %d: applied, but its files are missing", ErrUnknownVersion, v)
+		}
+		if mig.Down == "" {
+			return nil, fmt.Errorf("%w: %s", ErrNoDown, mig)
+		}
+		out = append(out, mig)
+	}
+	return out, nil
+}
+
+// planGoto — что накатить и что откатить, чтобы прийти к версии v.
+func planGoto(all []Migration, applied map[int64]Applied, v int64) (up, down []Migration, err error) {
+	if err := checkVersion(all, v); err != nil {
+		return nil, nil, err
+	}
+
+	above := map[int64]Applied{}
+	for ver, a := range applied {
+		if ver > v {
+			above[ver] = a
+		}
+	}
+	down, err = planDown(all, above, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, mig := range planUp(all, applied, 0) {
+		if mig.Version <= v {
+			up = append(up, mig)
+		}
+	}
+	return up, down, nil
+}
+
+// verify проверяет, что применённые миграции не редактировали после наката.
+func verify(all []Migration, applied map[int64]Applied) error {
+	var errs []error
+	for _, mig := range all {
+		if a, ok := applied[mig.Version]; ok && a.Checksum != mig.Checksum {
+			errs = append(errs, fmt.Errorf("%w: %s (use force to accept)", ErrChecksumMismatch, mig))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func status(all []Migration, applied map[int64]Applied) []Status {
+	out := make([]Status, 0, len(all))
+	for _, mig := range all {
+		st := Status{Version: mig.Version, Name: mig.Name}
+		if a, ok := applied[mig.Version]; ok {
+			st.Applied, st.AppliedAt, st.Modified = true, a.AppliedAt, a.Checksum != mig.Checksum
+		}
+		out = append(out, st)
+	}
+	for v, a := range applied {
+		if _, ok := find(all, v); !ok {
+			out = append(out, Status{Version: v, Name: a.Name, Applied: true, AppliedAt: a.AppliedAt, Missing: true})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out
+}
+
+// checkVersion проверяет, что v — 0 или версия одной из миграций.
+func checkVersion(all []Migration, v int64) error {
+	if v == 0 {
+		return nil
+	}
+	if _, ok := find(all, v); !ok {
+		return fmt.Errorf("%w %d", ErrUnknownVersion, v)
+	}
+	return nil
+}
+
+func find(all []Migration, v int64) (Migration, bool) {
+	i := sort.Search(len(all), func(i int) bool { return all[i].Version >= v })
+	if i < len(all) && all[i].Version == v {
+		return all[i], true
+	}
+	return Migration{}, false
+}
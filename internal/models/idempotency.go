@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// IdempotencyRecord — ответ на запрос с заголовком Idempotency-Key.
+// Повтор запроса с тем же ключом получает этот ответ без повторной записи.
+// Пока запрос выполняется, ответа ещё нет: StatusCode = 0, см. Pending.
+type IdempotencyRecord struct {
+	Key         string
+	RequestHash string
+	StatusCode  int
+	Response    []byte
+	CreatedAt   time.Time
+}
+
+// Pending сообщает, что ключ занят запросом, который ещё выполняется.
+func (r IdempotencyRecord) Pending() bool {
+	return r.StatusCode == 0
+}
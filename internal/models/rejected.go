@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// RejectedMessage — сообщение из Kafka, которое не прошло разбор или валидацию.
+type RejectedMessage struct {
+	ID          int64     `json:"id"`
+	Topic       string    `json:"topic"`
+	Partition   int       `json:"partition"`
+	Offset      int64     `json:"offset"`
+	Key         string    `json:"key"`
+	Payload     string    `json:"payload"`
+	Reason      string    `json:"reason"`
+	Error       string    `json:"error"`
+	MessageTime time.Time `json:"message_time"`
+	RejectedAt  time.Time `json:"rejected_at"`
+}
@@ -0,0 +1,43 @@
+package models
+
+import "time"
+
+// StatusEvent — событие смены статуса заказа или одной его позиции.
+// Приходит в топик заказов сообщением с заголовком message-type: status.
+type StatusEvent struct {
+	OrderUID string `json:"order_uid" validate:"required,min=8,max=64"`
+	// Rid — позиция заказа. Пустой — меняется статус самого заказа.
+	Rid    string `json:"rid,omitempty" validate:"max=64"`
+	Status string `json:"status" validate:"required,order_status"`
+	// At — время смены статуса. Пустое — время обработки события.
+	At     time.Time `json:"at"`
+	Reason string    `json:"reason,omitempty" validate:"max=256"`
+}
+
+// StatusChange — один переход в истории статусов.
+type StatusChange struct {
+	Rid       string    `json:"rid,omitempty"`
+	From      string    `json:"from,omitempty"`
+	To        string    `json:"to"`
+	Reason    string    `json:"reason,omitempty"`
+	Source    string    `json:"source,omitempty"`
+	SourceRef string    `json:"source_ref,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+// ItemStatus — текущий статус позиции.
+type ItemStatus struct {
+	Rid       string    `json:"rid"`
+	Status    string    `json:"status"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// OrderStatus — текущий статус заказа, статусы позиций и история переходов.
+// Позиции без собственных событий в Items не попадают.
+type OrderStatus struct {
+	OrderUID  string         `json:"order_uid"`
+	Status    string         `json:"status"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	Items     []ItemStatus   `json:"items,omitempty"`
+	Timeline  []StatusChange `json:"timeline"`
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// OrderVersion — снимок заказа после одной из записей.
+// Order заполнен только при запросе конкретной версии, в списке истории он пустой.
+type OrderVersion struct {
+	OrderUID  string    `json:"order_uid"`
+	Version   int       `json:"version"`
+	Source    string    `json:"source"`
+	SourceRef string    `json:"source_ref,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	Order     *Order    `json:"order,omitempty"`
+}
@@ -0,0 +1,96 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Типовые ошибки хранилища. Репозитории оборачивают в них ошибки pgx,
+// исходная ошибка остаётся в цепочке и доступна через errors.As.
+var (
+	// ErrNotFound — запрошенной записи нет.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict — запись нарушает ограничение целостности (уникальность, внешний ключ).
+	ErrConflict = errors.New("conflict")
+	// ErrUnavailable — БД временно недоступна или перегружена, запрос можно повторить.
+	ErrUnavailable = errors.New("storage unavailable")
+	// ErrStale — пришла копия заказа старее сохранённой, запись не применена.
+	ErrStale = errors.New("stale update")
+)
+
+// wrapErr сопоставляет ошибку pgx одной из типовых ошибок.
+// Остальные ошибки (и уже обёрнутые) возвращаются как есть.
+func wrapErr(err error) error {
+	switch {
+	case err == nil,
+		errors.Is(err, ErrNotFound),
+		errors.Is(err, ErrConflict),
+		errors.Is(err, ErrUnavailable),
+		errors.Is(err, ErrStale):
+		return err
+	case errors.Is(err, pgx.ErrNoRows):
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	case isConflict(err):
+		return fmt.Errorf("%w: %w", ErrConflict, err)
+	case isUnavailable(err):
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+	return err
+}
+
+// isConflict сообщает, что запрос нарушил ограничение целостности (класс 23).
+func isConflict(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && len(pgErr.Code) >= 2 && pgErr.Code[:2] == "23"
+}
+
+// isUnavailable сообщает, есть ли смысл повторять запрос:
+// обрыв соединения, таймаут, перегрузка или перезапуск постгреса.
+// Всё остальное (битые данные, нарушение ограничений) считается постоянной ошибкой.
+func isUnavailable(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		pgconn.Timeout(err) ||
+		pgconn.SafeToRetry(err) {
+		return true
+	}
+
+	var connErr *pgconn.ConnectError
+	if errors.As(err, &connErr) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		class := pgErr.Code
+		if len(class) > 2 {
+			class = class[:2]
+		}
+		switch class {
+		case "08", // connection_exception
+			"53", // insufficient_resources (too_many_connections и т.п.)
+			"57": // operator_intervention (admin_shutdown, cannot_connect_now)
+			return true
+		}
+		// serialization_failure, deadlock_detected
+		return pgErr.Code == "40001" || pgErr.Code == "40P01"
+	}
+
+	return false
+}
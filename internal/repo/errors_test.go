@@ -0,0 +1,55 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestWrapErr(t *testing.T) {
+	cases := []struct {
+		err  error
+		want error // nil — ошибка не должна оборачиваться
+	}{
+		{pgx.ErrNoRows, ErrNotFound},
+		{fmt.Errorf("scan: %w", pgx.ErrNoRows), ErrNotFound},
+		{&pgconn.PgError{Code: "23505"}, ErrConflict},
+		{&pgconn.PgError{Code: "23503"}, ErrConflict},
+		{&pgconn.PgError{Code: "08006"}, ErrUnavailable},
+		{&pgconn.PgError{Code: "57P01"}, ErrUnavailable},
+		{&pgconn.PgError{Code: "53300"}, ErrUnavailable},
+		{&pgconn.PgError{Code: "40001"}, ErrUnavailable},
+		{context.DeadlineExceeded, ErrUnavailable},
+		{&net.OpError{Op: "dial", Err: errors.New("refused")}, ErrUnavailable},
+		{&pgconn.PgError{Code: "42P01"}, nil},
+		{context.Canceled, nil},
+		{errors.New("boom"), nil},
+	}
+
+	sentinels := []error{ErrNotFound, ErrConflict, ErrUnavailable}
+	for _, tc := range cases {
+		got := wrapErr(tc.err)
+		if !errors.Is(got, tc.err) {
+			t.Errorf("wrapErr(%v) lost the original error", tc.err)
+		}
+		for _, s := range sentinels {
+			if errors.Is(got, s) != (s == tc.want) {
+				t.Errorf("wrapErr(%v) = %v, want %v", tc.err, got, tc.want)
+			}
+		}
+	}
+
+	if wrapErr(nil) != nil {
+		t.Fatalf("wrapErr(nil) must be nil")
+	}
+	// повторная обёртка ничего не меняет
+	once := wrapErr(pgx.ErrNoRows)
+	if wrapErr(once) != once {
+		t.Fatalf("wrapped error must be returned as is")
+	}
+}
@@ -0,0 +1,108 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// IdempotencyTTL — сколько хранится ответ на запрос с Idempotency-Key.
+// Более старые записи не находятся и перезаписываются.
+const IdempotencyTTL = 24 * time.Hour
+
+// IdempotencyPendingTTL — сколько держится резерв ключа без ответа. Запрос столько
+// не выполняется, так что более старый резерв брошен (сервис упал посреди запроса)
+// и ключ можно занять снова.
+const IdempotencyPendingTTL = 5 * time.Minute
+
+// IdempotencyRepo хранит ответы на запросы с ключом идемпотентности.
+type IdempotencyRepo struct {
+	pool *pgxpool.Pool
+}
+
+// NewIdempotencyRepo создаёт репозиторий ключей идемпотентности.
+func NewIdempotencyRepo(pool *pgxpool.Pool) *IdempotencyRepo {
+	return &IdempotencyRepo{pool: pool}
+}
+
+// ReserveIdempotency занимает ключ до выполнения запроса одним INSERT ... ON CONFLICT,
+// поэтому из одновременных запросов с одним ключом его получает только один.
+// reserved=true — ключ свободен (записи нет, она устарела или резерв брошен):
+// запрос можно выполнять, а потом вызвать CompleteIdempotency или ReleaseIdempotency.
+// Иначе возвращается действующая запись: готовый ответ или резерв запроса,
+// который ещё выполняется (rec.Pending()).
+func (r *IdempotencyRepo) ReserveIdempotency(ctx context.Context, key, requestHash string) (models.IdempotencyRecord, bool, error) {
+	for {
+		now := time.Now()
+		tag, err := r.pool.Exec(ctx, `
+			INSERT INTO idempotency_keys (key, request_hash)
+			VALUES ($1,$2)
+			ON CONFLICT (key) DO UPDATE SET
+			  request_hash = EXCLUDED.request_hash,
+			  status_code  = NULL,
+			  response     = NULL,
+			  created_at   = now()
+			WHERE idempotency_keys.created_at <= $3
+			   OR (idempotency_keys.status_code IS NULL AND idempotency_keys.created_at <= $4)
+		`, key, requestHash, now.Add(-IdempotencyTTL), now.Add(-IdempotencyPendingTTL))
+		if err != nil {
+			return models.IdempotencyRecord{}, false, wrapErr(err)
+		}
+		if tag.RowsAffected() == 1 {
+			return models.IdempotencyRecord{Key: key, RequestHash: requestHash}, true, nil
+		}
+
+		rec := models.IdempotencyRecord{Key: key}
+		err = r.pool.QueryRow(ctx, `
+			SELECT request_hash, COALESCE(status_code, 0), COALESCE(response, ''::bytea), created_at
+			FROM idempotency_keys
+			WHERE key = $1
+		`, key).Scan(&rec.RequestHash, &rec.StatusCode, &rec.Response, &rec.CreatedAt)
+		if errors.Is(err, pgx.ErrNoRows) {
+			// запись удалили между запросами, ключ снова свободен
+			continue
+		}
+		if err != nil {
+			return models.IdempotencyRecord{}, false, wrapErr(err)
+		}
+		return rec, false, nil
+	}
+}
+
+// CompleteIdempotency записывает ответ в резерв ключа.
+// Резерв, который уже занял другой запрос, не трогается.
+func (r *IdempotencyRepo) CompleteIdempotency(ctx context.Context, rec models.IdempotencyRecord) error {
+	_, err := r.pool.Exec(ctx, `
+		UPDATE idempotency_keys
+		SET status_code = $3, response = $4
+		WHERE key = $1 AND request_hash = $2 AND status_code IS NULL
+	`, rec.Key, rec.RequestHash, rec.StatusCode, rec.Response)
+	return wrapErr(err)
+}
+
+// ReleaseIdempotency снимает резерв, если ответ сохранять не нужно (5xx):
+// повтор запроса выполнится заново.
+func (r *IdempotencyRepo) ReleaseIdempotency(ctx context.Context, key, requestHash string) error {
+	_, err := r.pool.Exec(ctx, `
+		DELETE FROM idempotency_keys
+		WHERE key = $1 AND request_hash = $2 AND status_code IS NULL
+	`, key, requestHash)
+	return wrapErr(err)
+}
+
+// DeleteExpiredIdempotency удаляет устаревшие ключи и возвращает, сколько удалено.
+// Без этого таблица растёт бесконечно: устаревшие записи только пропускаются при чтении.
+func (r *IdempotencyRepo) DeleteExpiredIdempotency(ctx context.Context) (int64, error) {
+	tag, err := r.pool.Exec(ctx, `
+		DELETE FROM idempotency_keys WHERE created_at < $1
+	`, time.Now().Add(-IdempotencyTTL))
+	if err != nil {
+		return 0, wrapErr(err)
+	}
+	return tag.RowsAffected(), nil
+}
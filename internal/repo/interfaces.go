@@ -8,9 +8,46 @@ import (
 )
 
 // OrdersStorage описывает, что нам нужно от хранилища заказов.
+// Ошибки БД возвращаются обёрнутыми в ErrNotFound, ErrConflict или ErrUnavailable.
+// Запись копии заказа старее сохранённой не применяется (ErrStale или индекс в stale).
 type OrdersStorage interface {
-	InsertOrUpdateOrder(ctx context.Context, o models.Order) error
+	InsertOrUpdateOrder(ctx context.Context, o models.Order, src Source) error
+	InsertOrUpdateOrders(ctx context.Context, writes []OrderWrite) (stale []int, err error)
 	LoadAllOrders(ctx context.Context, limit int) ([]models.Order, error)
+	StreamOrders(ctx context.Context, fn func(models.Order) error) error
 	GetOrder(ctx context.Context, id string) (models.Order, error)
+	GetOrderByTrack(ctx context.Context, track string) (models.Order, error)
+	GetOrderByTransaction(ctx context.Context, tx string) (models.Order, error)
+	SearchOrders(ctx context.Context, f OrderFilter) (SearchPage, error)
 	InsertTestOrder(ctx context.Context) error
 }
+
+// VersionStorage отдаёт историю версий заказов.
+// Если у заказа нет ни одной версии, возвращается ErrNotFound.
+type VersionStorage interface {
+	ListOrderVersions(ctx context.Context, id string) ([]models.OrderVersion, error)
+	GetOrderVersion(ctx context.Context, id string, version int) (models.OrderVersion, error)
+}
+
+// StatusStorage хранит статусы заказов и историю переходов.
+// Недопустимый переход возвращается ошибкой с lifecycle.ErrIllegalTransition.
+type StatusStorage interface {
+	ApplyStatus(ctx context.Context, ev models.StatusEvent, src Source) (models.StatusChange, error)
+	GetStatus(ctx context.Context, id string) (models.OrderStatus, error)
+}
+
+// RejectedStorage описывает хранилище отклонённых сообщений из Kafka.
+type RejectedStorage interface {
+	SaveRejected(ctx context.Context, m models.RejectedMessage) error
+	ListRejected(ctx context.Context, limit, offset int) ([]models.RejectedMessage, error)
+	GetRejected(ctx context.Context, id int64) (models.RejectedMessage, error)
+}
+
+// IdempotencyStorage хранит ответы на запросы с заголовком Idempotency-Key.
+// Ключ занимается до выполнения запроса (ReserveIdempotency), а после
+// в него записывается ответ (CompleteIdempotency) или резерв снимается (ReleaseIdempotency).
+type IdempotencyStorage interface {
+	ReserveIdempotency(ctx context.Context, key, requestHash string) (models.IdempotencyRecord, bool, error)
+	CompleteIdempotency(ctx context.Context, rec models.IdempotencyRecord) error
+	ReleaseIdempotency(ctx context.Context, key, requestHash string) error
+}
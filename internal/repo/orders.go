@@ -4,6 +4,9 @@ package repo
 import (
 	"context"
 	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
 	"time"
 
 	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/models"
@@ -14,28 +17,83 @@ import (
 
 // OrdersRepo хранит пул подключений к БД.
 type OrdersRepo struct {
-	pool *pgxpool.Pool
+	pool     *pgxpool.Pool
+	observer QueryObserver
+	log      *slog.Logger
+}
+
+// QueryObserver получает длительность и результат операции репозитория,
+// например, чтобы записать их в метрики.
+type QueryObserver func(op string, took time.Duration, err error)
+
+// Названия операций для QueryObserver.
+const (
+	OpInsertOrder           = "insert_order"
+	OpInsertOrders          = "insert_orders"
+	OpGetOrder              = "get_order"
+	OpGetOrderByTrack       = "get_order_by_track"
+	OpGetOrderByTransaction = "get_order_by_transaction"
+	OpSearchOrders          = "search_orders"
+	OpListOrderVersions     = "list_order_versions"
+	OpGetOrderVersion       = "get_order_version"
+	OpApplyStatus           = "apply_status"
+	OpGetStatus             = "get_status"
+)
+
+// Option настраивает репозиторий заказов.
+type Option func(*OrdersRepo)
+
+// WithQueryObserver подключает наблюдателя за операциями репозитория.
+func WithQueryObserver(obs QueryObserver) Option {
+	return func(r *OrdersRepo) { r.observer = obs }
+}
+
+// WithLogger задаёт логгер репозитория. По умолчанию slog.Default().
+func WithLogger(l *slog.Logger) Option {
+	return func(r *OrdersRepo) { r.log = l }
 }
 
 // NewOrdersRepo создаёт репозиторий заказов.
-func NewOrdersRepo(pool *pgxpool.Pool) *OrdersRepo {
-	return &OrdersRepo{pool: pool}
+func NewOrdersRepo(pool *pgxpool.Pool, opts ...Option) *OrdersRepo {
+	r := &OrdersRepo{pool: pool, log: slog.Default()}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
 }
 
-// InsertOrUpdateOrder сохраняет заказ одной транзакцией.
-func (r *OrdersRepo) InsertOrUpdateOrder(ctx context.Context, o models.Order) error {
-	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{})
-	if err != nil {
-		return err
+// observe сообщает наблюдателю, сколько заняла операция, и пишет её в лог:
+// успешные, ненайденные и устаревшие — на уровне debug, остальные ошибки — warn.
+func (r *OrdersRepo) observe(ctx context.Context, op string, start time.Time, err error) {
+	took := time.Since(start)
+	if r.observer != nil {
+		r.observer(op, took, err)
 	}
-	defer func() { _ = tx.Rollback(ctx) }()
 
-	// orders
-	_, err = tx.Exec(ctx, `
+	switch {
+	case err == nil, errors.Is(err, ErrNotFound), errors.Is(err, ErrStale):
+		r.log.DebugContext(ctx, "query done", "op", op, "took", took)
+	case errors.Is(err, context.Canceled):
+		// клиент ушёл — не ошибка хранилища
+	default:
+		r.log.WarnContext(ctx, "query failed", "op", op, "took", took, "error", err)
+	}
+}
+
+// SQL для записи заказа. Общий для одиночной и пакетной записи.
+//
+// upsertOrderSQL не трогает строку, если сохранённая копия свежее пришедшей
+// (source_ts больше), и тогда не возвращает строк. Копия с неизвестной
+// свежестью применяется всегда, а source_ts остаётся максимумом из известных.
+// version растёт на $13 — число версий, которые запись добавит в историю
+// (больше 1, если в пачке несколько вариантов заказа), и возвращается
+// как номер последней из них.
+const (
+	upsertOrderSQL = `
 		INSERT INTO orders
 		  (order_uid, track_number, entry, locale, internal_signature, customer_id,
-		   delivery_service, shardkey, sm_id, date_created, oof_shard)
-		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)
+		   delivery_service, shardkey, sm_id, date_created, oof_shard, source_ts, version)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13)
 		ON CONFLICT (order_uid) DO UPDATE SET
 		  track_number=EXCLUDED.track_number,
 		  entry=EXCLUDED.entry,
@@ -46,15 +104,17 @@ func (r *OrdersRepo) InsertOrUpdateOrder(ctx context.Context, o models.Order) er
 		  shardkey=EXCLUDED.shardkey,
 		  sm_id=EXCLUDED.sm_id,
 		  date_created=EXCLUDED.date_created,
-		  oof_shard=EXCLUDED.oof_shard
-	`, o.OrderUID, o.TrackNumber, o.Entry, o.Locale, o.InternalSignature,
-		o.CustomerID, o.DeliveryService, o.ShardKey, o.SmID, o.DateCreated, o.OofShard)
-	if err != nil {
-		return err
-	}
+		  oof_shard=EXCLUDED.oof_shard,
+		  source_ts=GREATEST(orders.source_ts, EXCLUDED.source_ts),
+		  version=orders.version + EXCLUDED.version,
+		  updated_at=now()
+		WHERE EXCLUDED.source_ts IS NULL
+		   OR orders.source_ts IS NULL
+		   OR EXCLUDED.source_ts >= orders.source_ts
+		RETURNING version
+	`
 
-	// deliveries
-	_, err = tx.Exec(ctx, `
+	upsertDeliverySQL = `
 		INSERT INTO deliveries
 		  (order_uid, name, phone, zip, city, address, region, email)
 		VALUES ($1,$2,$3,$4,$5,$6,$7,$8)
@@ -66,14 +126,9 @@ func (r *OrdersRepo) InsertOrUpdateOrder(ctx context.Context, o models.Order) er
 		  address=EXCLUDED.address,
 		  region=EXCLUDED.region,
 		  email=EXCLUDED.email
-	`, o.OrderUID, o.Delivery.Name, o.Delivery.Phone, o.Delivery.Zip, o.Delivery.City,
-		o.Delivery.Address, o.Delivery.Region, o.Delivery.Email)
-	if err != nil {
-		return err
-	}
+	`
 
-	// payments
-	_, err = tx.Exec(ctx, `
+	upsertPaymentSQL = `
 		INSERT INTO payments
 		  (order_uid, transaction, request_id, currency, provider, amount,
 		   payment_dt, bank, delivery_cost, goods_total, custom_fee)
@@ -89,128 +144,416 @@ func (r *OrdersRepo) InsertOrUpdateOrder(ctx context.Context, o models.Order) er
 		  delivery_cost=EXCLUDED.delivery_cost,
 		  goods_total=EXCLUDED.goods_total,
 		  custom_fee=EXCLUDED.custom_fee
-	`, o.OrderUID, o.Payment.Transaction, o.Payment.RequestID, o.Payment.Currency, o.Payment.Provider,
-		o.Payment.Amount, o.Payment.PaymentDt, o.Payment.Bank, o.Payment.DeliveryCost, o.Payment.GoodsTotal, o.Payment.CustomFee)
+	`
+
+	insertItemSQL = `
+		INSERT INTO items
+		  (order_uid, chrt_id, track_number, price, rid, name, sale, size, total_price, nm_id, brand, status)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12)
+	`
+)
+
+// itemColumns — колонки items в том же порядке, что и itemArgs (для CopyFrom).
+var itemColumns = []string{
+	"order_uid", "chrt_id", "track_number", "price", "rid", "name",
+	"sale", "size", "total_price", "nm_id", "brand", "status",
+}
+
+// orderArgs — аргументы upsertOrderSQL. versions — сколько версий запись добавит в историю.
+func orderArgs(o models.Order, src Source, versions int) []any {
+	var ts *time.Time
+	if !src.At.IsZero() {
+		ts = &src.At
+	}
+	return []any{o.OrderUID, o.TrackNumber, o.Entry, o.Locale, o.InternalSignature,
+		o.CustomerID, o.DeliveryService, o.ShardKey, o.SmID, o.DateCreated, o.OofShard, ts, versions}
+}
+
+func deliveryArgs(o models.Order) []any {
+	return []any{o.OrderUID, o.Delivery.Name, o.Delivery.Phone, o.Delivery.Zip, o.Delivery.City,
+		o.Delivery.Address, o.Delivery.Region, o.Delivery.Email}
+}
+
+func paymentArgs(o models.Order) []any {
+	return []any{o.OrderUID, o.Payment.Transaction, o.Payment.RequestID, o.Payment.Currency, o.Payment.Provider,
+		o.Payment.Amount, o.Payment.PaymentDt, o.Payment.Bank, o.Payment.DeliveryCost, o.Payment.GoodsTotal, o.Payment.CustomFee}
+}
+
+func itemArgs(orderUID string, it models.Item) []any {
+	return []any{orderUID, it.ChrtID, it.TrackNumber, it.Price, it.Rid, it.Name, it.Sale, it.Size,
+		it.TotalPrice, it.NmID, it.Brand, it.Status}
+}
+
+// InsertOrUpdateOrder сохраняет заказ одной транзакцией
+// и добавляет его снимок в историю версий с указанием источника.
+// Если в БД уже есть более свежая копия (см. Source.At), ничего не пишется
+// и возвращается ErrStale.
+func (r *OrdersRepo) InsertOrUpdateOrder(ctx context.Context, o models.Order, src Source) (err error) {
+	defer func(start time.Time) {
+		err = wrapErr(err)
+		r.observe(ctx, OpInsertOrder, start, err)
+	}(time.Now())
+
+	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	vargs, err := versionArgs(o, src)
+	if err != nil {
+		return err
+	}
+
+	// orders
+	var version int64
+	err = tx.QueryRow(ctx, upsertOrderSQL, orderArgs(o, src, 1)...).Scan(&version)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("order %s: %w", o.OrderUID, ErrStale)
+	}
 	if err != nil {
 		return err
 	}
 
+	// deliveries
+	if _, err = tx.Exec(ctx, upsertDeliverySQL, deliveryArgs(o)...); err != nil {
+		return err
+	}
+
+	// payments
+	if _, err = tx.Exec(ctx, upsertPaymentSQL, paymentArgs(o)...); err != nil {
+		return err
+	}
+
 	// items
 	_, err = tx.Exec(ctx, `DELETE FROM items WHERE order_uid = $1`, o.OrderUID)
 	if err != nil {
 		return err
 	}
 	for _, it := range o.Items {
-		_, err = tx.Exec(ctx, `
-			INSERT INTO items
-			  (order_uid, chrt_id, track_number, price, rid, name, sale, size, total_price, nm_id, brand, status)
-			VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12)
-		`, o.OrderUID, it.ChrtID, it.TrackNumber, it.Price, it.Rid, it.Name, it.Sale, it.Size, it.TotalPrice, it.NmID, it.Brand, it.Status)
-		if err != nil {
+		if _, err = tx.Exec(ctx, insertItemSQL, itemArgs(o.OrderUID, it)...); err != nil {
 			return err
 		}
 	}
 
+	// order_versions
+	if _, err = tx.Exec(ctx, insertVersionSQL, append(vargs, version)...); err != nil {
+		return err
+	}
+
 	return tx.Commit(ctx)
 }
 
-// GetOrder возвращает заказ по order_uid из всех таблиц.
-func (r *OrdersRepo) GetOrder(ctx context.Context, id string) (models.Order, error) {
-	var o models.Order
+// InsertOrUpdateOrders сохраняет пачку заказов одной транзакцией:
+// upsert-ы orders, затем одним pgx.Batch deliveries/payments и удаление старых позиций,
+// новые позиции заливаются через CopyFrom.
+// Если один order_uid встречается в пачке несколько раз, в таблицах остаётся самый
+// свежий вариант (при равной свежести — последний), а в историю версий попадают
+// все применённые варианты — в порядке пачки.
+// Возвращает индексы записей, пропущенных как устаревшие: старее сохранённой копии
+// или более свежего варианта раньше в той же пачке.
+func (r *OrdersRepo) InsertOrUpdateOrders(ctx context.Context, writes []OrderWrite) (stale []int, err error) {
+	defer func(start time.Time) {
+		err = wrapErr(err)
+		r.observe(ctx, OpInsertOrders, start, err)
+	}(time.Now())
 
-	// orders
-	err := r.pool.QueryRow(ctx, `
-		SELECT order_uid, track_number, entry, locale, internal_signature, customer_id,
-		       delivery_service, shardkey, sm_id, date_created, oof_shard
-		FROM orders WHERE order_uid = $1
-	`, id).
-		Scan(&o.OrderUID, &o.TrackNumber, &o.Entry, &o.Locale, &o.InternalSignature, &o.CustomerID,
-			&o.DeliveryService, &o.ShardKey, &o.SmID, &o.DateCreated, &o.OofShard)
+	if len(writes) == 0 {
+		return nil, nil
+	}
+	versions := make([][]any, len(writes))
+	uids := make([]string, 0, len(writes))
+	for i, w := range writes {
+		if versions[i], err = versionArgs(w.Order, w.Source); err != nil {
+			return nil, err
+		}
+		uids = append(uids, w.Order.OrderUID)
+	}
+
+	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{})
 	if err != nil {
-		return models.Order{}, err
+		return nil, err
 	}
+	defer func() { _ = tx.Rollback(ctx) }()
 
-	// deliveries
-	err = r.pool.QueryRow(ctx, `
-		SELECT name, phone, zip, city, address, region, email
-		FROM deliveries WHERE order_uid = $1
-	`, id).Scan(&o.Delivery.Name, &o.Delivery.Phone, &o.Delivery.Zip, &o.Delivery.City,
-		&o.Delivery.Address, &o.Delivery.Region, &o.Delivery.Email)
-	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
-		return models.Order{}, err
+	current, err := lockFreshness(ctx, tx, uids)
+	if err != nil {
+		return nil, err
 	}
+	plan := planWrites(writes, current)
 
-	// payments
-	err = r.pool.QueryRow(ctx, `
-		SELECT transaction, request_id, currency, provider, amount, payment_dt, bank, delivery_cost, goods_total, custom_fee
-		FROM payments WHERE order_uid = $1
-	`, id).Scan(&o.Payment.Transaction, &o.Payment.RequestID, &o.Payment.Currency, &o.Payment.Provider,
-		&o.Payment.Amount, &o.Payment.PaymentDt, &o.Payment.Bank, &o.Payment.DeliveryCost, &o.Payment.GoodsTotal, &o.Payment.CustomFee)
-	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
-		return models.Order{}, err
+	// сколько версий каждого заказа пачка добавит в историю
+	count := make(map[string]int, len(plan.apply))
+	for i, w := range writes {
+		if !plan.stale[i] {
+			count[w.Order.OrderUID]++
+		}
 	}
 
-	// items
-	rows, err := r.pool.Query(ctx, `
-		SELECT chrt_id, track_number, price, rid, name, sale, size, total_price, nm_id, brand, status
-		FROM items WHERE order_uid = $1
-	`, id)
+	// orders; строку, вставленную параллельно уже после блокировки, upsert может не тронуть.
+	// next — номер следующей версии заказа в истории
+	lost := make(map[string]bool)
+	next := make(map[string]int64, len(plan.apply))
+	if len(plan.apply) > 0 {
+		b := &pgx.Batch{}
+		for _, i := range plan.apply {
+			uid := writes[i].Order.OrderUID
+			b.Queue(upsertOrderSQL, orderArgs(writes[i].Order, writes[i].Source, count[uid])...)
+		}
+		br := tx.SendBatch(ctx, b)
+		for _, i := range plan.apply {
+			uid := writes[i].Order.OrderUID
+			var version int64
+			err := br.QueryRow().Scan(&version)
+			if errors.Is(err, pgx.ErrNoRows) {
+				lost[uid] = true
+				continue
+			}
+			if err != nil {
+				_ = br.Close()
+				return nil, err
+			}
+			next[uid] = version - int64(count[uid]) + 1
+		}
+		if err := br.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	b := &pgx.Batch{}
+	applied := make([]string, 0, len(plan.apply))
+	var rows [][]any
+	for _, i := range plan.apply {
+		o := writes[i].Order
+		if lost[o.OrderUID] {
+			continue
+		}
+		b.Queue(upsertDeliverySQL, deliveryArgs(o)...)
+		b.Queue(upsertPaymentSQL, paymentArgs(o)...)
+		applied = append(applied, o.OrderUID)
+		for _, it := range o.Items {
+			rows = append(rows, itemArgs(o.OrderUID, it))
+		}
+	}
+	if len(applied) > 0 {
+		b.Queue(`DELETE FROM items WHERE order_uid = ANY($1)`, applied)
+		if err := tx.SendBatch(ctx, b).Close(); err != nil {
+			return nil, err
+		}
+		if _, err := tx.CopyFrom(ctx, pgx.Identifier{"items"}, itemColumns, pgx.CopyFromRows(rows)); err != nil {
+			return nil, err
+		}
+	}
+
+	vb := &pgx.Batch{}
+	for i, w := range writes {
+		if plan.stale[i] || lost[w.Order.OrderUID] {
+			stale = append(stale, i)
+			continue
+		}
+		uid := w.Order.OrderUID
+		vb.Queue(insertVersionSQL, append(versions[i], next[uid])...)
+		next[uid]++
+	}
+	if vb.Len() > 0 {
+		if err := tx.SendBatch(ctx, vb).Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return stale, nil
+}
+
+// lockFreshnessSQL блокирует уже сохранённые заказы пачки до конца транзакции.
+// Строки берутся в порядке order_uid, чтобы параллельные пачки не ловили дедлоки.
+const lockFreshnessSQL = `
+	SELECT order_uid, source_ts FROM orders
+	WHERE order_uid = ANY($1)
+	ORDER BY order_uid
+	FOR UPDATE
+`
+
+// lockFreshness блокирует сохранённые заказы и возвращает их source_ts.
+// Заказов, которых ещё нет или у которых свежесть неизвестна, в ответе нет.
+func lockFreshness(ctx context.Context, tx pgx.Tx, uids []string) (map[string]time.Time, error) {
+	rows, err := tx.Query(ctx, lockFreshnessSQL, uids)
 	if err != nil {
-		return models.Order{}, err
+		return nil, err
 	}
 	defer rows.Close()
 
-	o.Items = make([]models.Item, 0, 8)
+	out := make(map[string]time.Time, len(uids))
 	for rows.Next() {
-		var it models.Item
-		if err := rows.Scan(&it.ChrtID, &it.TrackNumber, &it.Price, &it.Rid, &it.Name, &it.Sale,
-			&it.Size, &it.TotalPrice, &it.NmID, &it.Brand, &it.Status); err != nil {
-			return models.Order{}, err
+		var (
+			uid string
+			ts  *time.Time
+		)
+		if err := rows.Scan(&uid, &ts); err != nil {
+			return nil, err
 		}
-		o.Items = append(o.Items, it)
+		if ts != nil {
+			out[uid] = *ts
+		}
+	}
+	return out, rows.Err()
+}
+
+// writePlan — что делать с записями пачки.
+type writePlan struct {
+	// apply — индексы вариантов, которые пишутся в таблицы, отсортированные по order_uid
+	apply []int
+	// stale[i] — запись i старее сохранённой копии или более раннего варианта в пачке
+	stale []bool
+}
+
+// planWrites проходит пачку по порядку и отбрасывает варианты старее уже виденных.
+// current — свежесть сохранённых заказов, она дополняется по ходу прохода.
+func planWrites(writes []OrderWrite, current map[string]time.Time) writePlan {
+	p := writePlan{stale: make([]bool, len(writes))}
+	last := make(map[string]int, len(writes))
+	for i, w := range writes {
+		uid, at := w.Order.OrderUID, w.Source.At
+		if !at.IsZero() && at.Before(current[uid]) {
+			p.stale[i] = true
+			continue
+		}
+		if at.After(current[uid]) {
+			current[uid] = at
+		}
+		last[uid] = i
+	}
+
+	p.apply = make([]int, 0, len(last))
+	for _, i := range last {
+		p.apply = append(p.apply, i)
+	}
+	sort.Slice(p.apply, func(i, j int) bool {
+		return writes[p.apply[i]].Order.OrderUID < writes[p.apply[j]].Order.OrderUID
+	})
+	return p
+}
+
+// selectOrderSQL выбирает заказы целиком одним запросом: доставка и оплата
+// приходят через JOIN в виде JSON, позиции — массивом через json_agg.
+// Имена колонок deliveries/payments/items совпадают с json-тегами моделей,
+// поэтому JSON раскладывается в структуры без ручного маппинга.
+const selectOrderSQL = `
+	SELECT o.order_uid, o.track_number, o.entry, o.locale, COALESCE(o.internal_signature, ''), o.customer_id,
+	       o.delivery_service, o.shardkey, o.sm_id, o.date_created, o.oof_shard,
+	       COALESCE(row_to_json(d), '{}'::json),
+	       COALESCE(row_to_json(p), '{}'::json),
+	       COALESCE(i.items, '[]'::json)
+	FROM orders o
+	LEFT JOIN deliveries d ON d.order_uid = o.order_uid
+	LEFT JOIN payments p ON p.order_uid = o.order_uid
+	LEFT JOIN LATERAL (
+		SELECT json_agg(it ORDER BY it.id) AS items
+		FROM items it WHERE it.order_uid = o.order_uid
+	) i ON true
+`
+
+// scanOrder читает одну строку selectOrderSQL.
+func scanOrder(row pgx.Row) (models.Order, error) {
+	var o models.Order
+	err := row.Scan(&o.OrderUID, &o.TrackNumber, &o.Entry, &o.Locale, &o.InternalSignature, &o.CustomerID,
+		&o.DeliveryService, &o.ShardKey, &o.SmID, &o.DateCreated, &o.OofShard,
+		&o.Delivery, &o.Payment, &o.Items)
+	if err != nil {
+		return models.Order{}, wrapErr(err)
 	}
 	return o, nil
 }
 
-// LoadAllOrders возвращает последние N заказов по order_uid (для прогрева кэша).
+// GetOrder возвращает заказ по order_uid из всех таблиц одним запросом.
+func (r *OrdersRepo) GetOrder(ctx context.Context, id string) (models.Order, error) {
+	start := time.Now()
+	o, err := scanOrder(r.pool.QueryRow(ctx, selectOrderSQL+` WHERE o.order_uid = $1`, id))
+	r.observe(ctx, OpGetOrder, start, err)
+	return o, err
+}
+
+// GetOrderByTrack возвращает заказ по track_number.
+// Если заказов с таким треком несколько, возвращается самый свежий по date_created,
+// при равной дате — с большим order_uid (так же выбирает кэш).
+func (r *OrdersRepo) GetOrderByTrack(ctx context.Context, track string) (models.Order, error) {
+	start := time.Now()
+	o, err := scanOrder(r.pool.QueryRow(ctx, selectOrderSQL+`
+		WHERE o.track_number = $1 ORDER BY o.date_created DESC, o.order_uid DESC LIMIT 1`, track))
+	r.observe(ctx, OpGetOrderByTrack, start, err)
+	return o, err
+}
+
+// GetOrderByTransaction возвращает заказ по payment.transaction.
+// Если заказов с такой транзакцией несколько, выбор такой же, как в GetOrderByTrack.
+func (r *OrdersRepo) GetOrderByTransaction(ctx context.Context, tx string) (models.Order, error) {
+	start := time.Now()
+	o, err := scanOrder(r.pool.QueryRow(ctx, selectOrderSQL+`
+		WHERE p.transaction = $1 ORDER BY o.date_created DESC, o.order_uid DESC LIMIT 1`, tx))
+	r.observe(ctx, OpGetOrderByTransaction, start, err)
+	return o, err
+}
+
+// LoadAllOrders возвращает последние N заказов по date_created (для прогрева кэша).
 func (r *OrdersRepo) LoadAllOrders(ctx context.Context, limit int) ([]models.Order, error) {
 	if limit <= 0 {
 		limit = 1000
 	}
-	rows, err := r.pool.Query(ctx, `
-		SELECT order_uid
-		FROM orders
-		ORDER BY date_created DESC
-		LIMIT $1
-	`, limit)
+
+	out := make([]models.Order, 0, limit)
+	err := r.queryOrders(ctx, func(o models.Order) error {
+		out = append(out, o)
+		return nil
+	}, selectOrderSQL+` ORDER BY o.date_created DESC LIMIT $1`, limit)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	return out, nil
+}
 
-	var ids []string
-	for rows.Next() {
-		var id string
-		if err := rows.Scan(&id); err != nil {
-			return nil, err
-		}
-		ids = append(ids, id)
+// StreamOrders проходит по всем заказам от новых к старым и отдаёт их в fn по одному,
+// не держа всю выборку в памяти. Если fn вернула ошибку, обход прекращается с этой ошибкой.
+func (r *OrdersRepo) StreamOrders(ctx context.Context, fn func(models.Order) error) error {
+	return r.queryOrders(ctx, fn, selectOrderSQL+` ORDER BY o.date_created DESC`)
+}
+
+// StreamOrdersUpdatedSince проходит по заказам, записанным начиная с момента since
+// (orders.updated_at), от более ранних записей к более поздним. Нужен, чтобы догнать
+// снимок кэша изменениями, сделанными, пока сервис не работал.
+func (r *OrdersRepo) StreamOrdersUpdatedSince(ctx context.Context, since time.Time, fn func(models.Order) error) error {
+	return r.queryOrders(ctx, fn, selectOrderSQL+` WHERE o.updated_at >= $1 ORDER BY o.updated_at, o.order_uid`, since)
+}
+
+// queryOrders выполняет запрос на основе selectOrderSQL и вызывает fn для каждой строки.
+// Ошибка из fn возвращается без изменений.
+func (r *OrdersRepo) queryOrders(ctx context.Context, fn func(models.Order) error, sql string, args ...any) error {
+	rows, err := r.pool.Query(ctx, sql, args...)
+	if err != nil {
+		return wrapErr(err)
 	}
+	defer rows.Close()
 
-	out := make([]models.Order, 0, len(ids))
-	for _, id := range ids {
-		o, err := r.GetOrder(ctx, id)
+	for rows.Next() {
+		o, err := scanOrder(rows)
 		if err != nil {
-			return nil, err
+			return err
+		}
+		if err := fn(o); err != nil {
+			return err
 		}
-		out = append(out, o)
 	}
-	return out, nil
+	return wrapErr(rows.Err())
 }
 
 // InsertTestOrder вставляет демонстрационный заказ.
 func (r *OrdersRepo) InsertTestOrder(ctx context.Context) error {
-	return r.InsertOrUpdateOrder(ctx, models.Order{
+	return r.InsertOrUpdateOrder(ctx, testOrder(), Source{Kind: SourceAdmin, Ref: "test order"})
+}
+
+func testOrder() models.Order {
+	return models.Order{
 		OrderUID:          "b563feb7b2b84b6test",
 		TrackNumber:       "WBILMTESTTRACK",
 		Entry:             "WBIL",
@@ -256,5 +599,5 @@ func (r *OrdersRepo) InsertTestOrder(ctx context.Context) error {
 			Brand:       "Vivienne Sabo",
 			Status:      202,
 		}},
-	})
+	}
 }
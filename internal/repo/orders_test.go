@@ -0,0 +1,34 @@
+package repo
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/models"
+)
+
+func TestPlanWrites(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	w := func(uid string, at time.Time) OrderWrite {
+		return OrderWrite{Order: models.Order{OrderUID: uid}, Source: Source{Kind: SourceKafka, At: at}}
+	}
+	writes := []OrderWrite{
+		w("b", t0.Add(2*time.Second)),
+		w("a", t0),                    // старее сохранённой копии
+		w("b", t0.Add(time.Second)),   // старее варианта выше
+		w("c", time.Time{}),           // свежесть неизвестна — применяется
+		w("b", t0.Add(2*time.Second)), // та же свежесть — побеждает последний
+		w("a", t0.Add(time.Minute)),
+	}
+	current := map[string]time.Time{"a": t0.Add(time.Second), "c": t0.Add(time.Hour)}
+
+	p := planWrites(writes, current)
+
+	if want := []bool{false, true, true, false, false, false}; !reflect.DeepEqual(p.stale, want) {
+		t.Fatalf("stale = %v, want %v", p.stale, want)
+	}
+	if want := []int{5, 4, 3}; !reflect.DeepEqual(p.apply, want) {
+		t.Fatalf("apply = %v, want %v", p.apply, want)
+	}
+}
@@ -0,0 +1,99 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// RejectedRepo хранит сообщения, которые консьюмер не смог принять.
+type RejectedRepo struct {
+	pool *pgxpool.Pool
+}
+
+// NewRejectedRepo создаёт репозиторий отклонённых сообщений.
+func NewRejectedRepo(pool *pgxpool.Pool) *RejectedRepo {
+	return &RejectedRepo{pool: pool}
+}
+
+// SaveRejected сохраняет отклонённое сообщение.
+// Повторная доставка того же оффсета не создаёт дубликат.
+func (r *RejectedRepo) SaveRejected(ctx context.Context, m models.RejectedMessage) error {
+	var msgTime *time.Time
+	if !m.MessageTime.IsZero() {
+		msgTime = &m.MessageTime
+	}
+
+	_, err := r.pool.Exec(ctx, `
+		INSERT INTO rejected_messages
+		  (topic, kafka_partition, kafka_offset, message_key, payload, reason, error, message_time)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8)
+		ON CONFLICT (topic, kafka_partition, kafka_offset) DO NOTHING
+	`, m.Topic, m.Partition, m.Offset, []byte(m.Key), []byte(m.Payload), m.Reason, m.Error, msgTime)
+	return wrapErr(err)
+}
+
+// ListRejected возвращает отклонённые сообщения, начиная с самых свежих.
+func (r *RejectedRepo) ListRejected(ctx context.Context, limit, offset int) ([]models.RejectedMessage, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	rows, err := r.pool.Query(ctx, `
+		SELECT id, topic, kafka_partition, kafka_offset, message_key, payload, reason, error, message_time, rejected_at
+		FROM rejected_messages
+		ORDER BY rejected_at DESC, id DESC
+		LIMIT $1 OFFSET $2
+	`, limit, offset)
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+	defer rows.Close()
+
+	out := make([]models.RejectedMessage, 0, limit)
+	for rows.Next() {
+		m, err := scanRejected(rows)
+		if err != nil {
+			return nil, wrapErr(err)
+		}
+		out = append(out, m)
+	}
+	return out, wrapErr(rows.Err())
+}
+
+// GetRejected возвращает отклонённое сообщение по id.
+func (r *RejectedRepo) GetRejected(ctx context.Context, id int64) (models.RejectedMessage, error) {
+	row := r.pool.QueryRow(ctx, `
+		SELECT id, topic, kafka_partition, kafka_offset, message_key, payload, reason, error, message_time, rejected_at
+		FROM rejected_messages WHERE id = $1
+	`, id)
+	return scanRejected(row)
+}
+
+// scanRejected читает одну строку rejected_messages.
+func scanRejected(row pgx.Row) (models.RejectedMessage, error) {
+	var (
+		m       models.RejectedMessage
+		key     []byte
+		payload []byte
+		msgTime *time.Time
+	)
+	if err := row.Scan(&m.ID, &m.Topic, &m.Partition, &m.Offset, &key, &payload,
+		&m.Reason, &m.Error, &msgTime, &m.RejectedAt); err != nil {
+		return models.RejectedMessage{}, wrapErr(err)
+	}
+
+	m.Key = string(key)
+	m.Payload = string(payload)
+	if msgTime != nil {
+		m.MessageTime = *msgTime
+	}
+	return m, nil
+}
@@ -0,0 +1,184 @@
+package repo
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/models"
+)
+
+// Ограничения размера страницы поиска.
+const (
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 100
+)
+
+// SortOrder — порядок выдачи поиска.
+type SortOrder string
+
+const (
+	// SortNewest — сначала новые заказы (по умолчанию).
+	SortNewest SortOrder = "date_desc"
+	// SortOldest — сначала старые заказы.
+	SortOldest SortOrder = "date_asc"
+)
+
+// OrderFilter — параметры поиска заказов. Пустые поля не фильтруют.
+type OrderFilter struct {
+	CustomerID      string
+	TrackNumber     string
+	DeliveryService string
+	CreatedFrom     time.Time // включительно
+	CreatedTo       time.Time // не включительно
+
+	// по оплате
+	Provider string
+	Currency string
+
+	// по позициям: заказ подходит, если в нём есть такая позиция
+	Brand string
+	NmID  int64
+
+	Sort  SortOrder
+	Limit int
+	After *Cursor // продолжить после этого заказа
+}
+
+// Cursor — позиция в выдаче: ключ последнего отданного заказа.
+type Cursor struct {
+	DateCreated time.Time `json:"d"`
+	OrderUID    string    `json:"id"`
+}
+
+// Encode упаковывает курсор в строку для передачи клиенту.
+func (c Cursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeCursor разбирает строку, полученную из Cursor.Encode.
+func DecodeCursor(s string) (Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, fmt.Errorf("bad cursor: %w", err)
+	}
+
+	var c Cursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return Cursor{}, fmt.Errorf("bad cursor: %w", err)
+	}
+	if c.OrderUID == "" || c.DateCreated.IsZero() {
+		return Cursor{}, fmt.Errorf("bad cursor: empty key")
+	}
+	return c, nil
+}
+
+// SearchPage — страница результатов поиска.
+// Next равен nil, если дальше заказов нет.
+type SearchPage struct {
+	Orders []models.Order
+	Next   *Cursor
+}
+
+// SearchOrders ищет заказы по фильтру с keyset-пагинацией по (date_created, order_uid).
+func (r *OrdersRepo) SearchOrders(ctx context.Context, f OrderFilter) (SearchPage, error) {
+	f.Limit = normalizeLimit(f.Limit)
+	sql, args := buildSearchSQL(f)
+
+	start := time.Now()
+	orders := make([]models.Order, 0, f.Limit+1)
+	err := r.queryOrders(ctx, func(o models.Order) error {
+		orders = append(orders, o)
+		return nil
+	}, sql, args...)
+	r.observe(ctx, OpSearchOrders, start, err)
+	if err != nil {
+		return SearchPage{}, err
+	}
+
+	page := SearchPage{Orders: orders}
+	// запрашивали на одну запись больше, чтобы понять, есть ли следующая страница
+	if len(orders) > f.Limit {
+		page.Orders = orders[:f.Limit]
+		last := page.Orders[f.Limit-1]
+		page.Next = &Cursor{DateCreated: last.DateCreated, OrderUID: last.OrderUID}
+	}
+	return page, nil
+}
+
+func normalizeLimit(limit int) int {
+	if limit <= 0 {
+		return DefaultSearchLimit
+	}
+	return min(limit, MaxSearchLimit)
+}
+
+// buildSearchSQL собирает запрос поиска на основе selectOrderSQL.
+// Limit должен быть уже нормализован.
+func buildSearchSQL(f OrderFilter) (string, []any) {
+	var (
+		where []string
+		args  []any
+	)
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if f.CustomerID != "" {
+		where = append(where, "o.customer_id = "+arg(f.CustomerID))
+	}
+	if f.TrackNumber != "" {
+		where = append(where, "o.track_number = "+arg(f.TrackNumber))
+	}
+	if f.DeliveryService != "" {
+		where = append(where, "o.delivery_service = "+arg(f.DeliveryService))
+	}
+	if !f.CreatedFrom.IsZero() {
+		where = append(where, "o.date_created >= "+arg(f.CreatedFrom))
+	}
+	if !f.CreatedTo.IsZero() {
+		where = append(where, "o.date_created < "+arg(f.CreatedTo))
+	}
+	if f.Provider != "" {
+		where = append(where, "p.provider = "+arg(f.Provider))
+	}
+	if f.Currency != "" {
+		where = append(where, "p.currency = "+arg(f.Currency))
+	}
+
+	if f.Brand != "" || f.NmID != 0 {
+		var conds []string
+		if f.Brand != "" {
+			conds = append(conds, "f.brand = "+arg(f.Brand))
+		}
+		if f.NmID != 0 {
+			conds = append(conds, "f.nm_id = "+arg(f.NmID))
+		}
+		where = append(where, "EXISTS (SELECT 1 FROM items f WHERE f.order_uid = o.order_uid AND "+
+			strings.Join(conds, " AND ")+")")
+	}
+
+	dir, op := "DESC", "<"
+	if f.Sort == SortOldest {
+		dir, op = "ASC", ">"
+	}
+	if f.After != nil {
+		where = append(where, fmt.Sprintf("(o.date_created, o.order_uid) %s (%s, %s)",
+			op, arg(f.After.DateCreated), arg(f.After.OrderUID)))
+	}
+
+	var sb strings.Builder
+	sb.WriteString(selectOrderSQL)
+	if len(where) > 0 {
+		sb.WriteString(" WHERE ")
+		sb.WriteString(strings.Join(where, " AND "))
+	}
+	fmt.Fprintf(&sb, " ORDER BY o.date_created %s, o.order_uid %s LIMIT %s", dir, dir, arg(f.Limit+1))
+
+	return sb.String(), args
+}
@@ -0,0 +1,59 @@
+package repo
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	c := Cursor{DateCreated: time.Date(2021, 11, 26, 6, 22, 19, 0, time.UTC), OrderUID: "b563feb7b2b84b6test"}
+
+	got, err := DecodeCursor(c.Encode())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.OrderUID != c.OrderUID || !got.DateCreated.Equal(c.DateCreated) {
+		t.Fatalf("expected %+v got %+v", c, got)
+	}
+
+	if _, err := DecodeCursor("e30"); err == nil { // {}
+		t.Fatalf("expected error for empty cursor")
+	}
+}
+
+func TestBuildSearchSQL(t *testing.T) {
+	after := &Cursor{DateCreated: time.Now(), OrderUID: "x"}
+	sql, args := buildSearchSQL(OrderFilter{
+		CustomerID: "c",
+		Currency:   "USD",
+		Brand:      "b",
+		NmID:       7,
+		Sort:       SortOldest,
+		Limit:      10,
+		After:      after,
+	})
+
+	for _, want := range []string{
+		"o.customer_id = $1",
+		"p.currency = $2",
+		"f.brand = $3 AND f.nm_id = $4",
+		"(o.date_created, o.order_uid) > ($5, $6)",
+		"ORDER BY o.date_created ASC, o.order_uid ASC LIMIT $7",
+	} {
+		if !strings.Contains(sql, want) {
+			t.Fatalf("sql should contain %q:\n%s", want, sql)
+		}
+	}
+	if len(args) != 7 || args[6] != 11 {
+		t.Fatalf("unexpected args: %v", args)
+	}
+
+	sql, args = buildSearchSQL(OrderFilter{Limit: 20})
+	if strings.Contains(sql, "WHERE o.") || len(args) != 1 {
+		t.Fatalf("empty filter should not add conditions:\n%s", sql)
+	}
+	if !strings.Contains(sql, "ORDER BY o.date_created DESC, o.order_uid DESC") {
+		t.Fatalf("default sort should be newest first:\n%s", sql)
+	}
+}
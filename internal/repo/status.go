@@ -0,0 +1,165 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/lifecycle"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ApplyStatus переводит заказ или позицию (ev.Rid) в новый статус и пишет переход в историю.
+// Строка статуса блокируется до конца транзакции, поэтому параллельные события
+// одного заказа проверяются по очереди.
+//
+// Если заказа или позиции нет, возвращается ErrNotFound, если переход не разрешён —
+// ошибка с lifecycle.ErrIllegalTransition. Если заказ уже в этом статусе
+// (повтор события), ничего не пишется и возвращается переход с From == To.
+func (r *OrdersRepo) ApplyStatus(ctx context.Context, ev models.StatusEvent, src Source) (ch models.StatusChange, err error) {
+	defer func(start time.Time) {
+		err = wrapErr(err)
+		r.observe(ctx, OpApplyStatus, start, err)
+	}(time.Now())
+
+	to, err := lifecycle.Parse(ev.Status)
+	if err != nil {
+		return models.StatusChange{}, err
+	}
+	at := ev.At
+	if at.IsZero() {
+		at = time.Now()
+	}
+
+	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return models.StatusChange{}, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	// первая смена статуса создаёт строку created; заодно проверяется, что заказ и позиция есть
+	_, err = tx.Exec(ctx, `
+		INSERT INTO order_statuses (order_uid, rid, status, updated_at)
+		SELECT o.order_uid, $2::text, $3::text, o.date_created
+		FROM orders o
+		WHERE o.order_uid = $1
+		  AND ($2 = '' OR EXISTS (SELECT 1 FROM items i WHERE i.order_uid = $1 AND i.rid = $2))
+		ON CONFLICT (order_uid, rid) DO NOTHING
+	`, ev.OrderUID, ev.Rid, string(lifecycle.Created))
+	if err != nil {
+		return models.StatusChange{}, err
+	}
+
+	var cur string
+	err = tx.QueryRow(ctx, `
+		SELECT status FROM order_statuses
+		WHERE order_uid = $1 AND rid = $2
+		FOR UPDATE
+	`, ev.OrderUID, ev.Rid).Scan(&cur)
+	if errors.Is(err, pgx.ErrNoRows) {
+		if ev.Rid != "" {
+			return models.StatusChange{}, fmt.Errorf("order %s item %s %w", ev.OrderUID, ev.Rid, ErrNotFound)
+		}
+		return models.StatusChange{}, fmt.Errorf("order %s %w", ev.OrderUID, ErrNotFound)
+	}
+	if err != nil {
+		return models.StatusChange{}, err
+	}
+	from := lifecycle.Status(cur)
+
+	ch = models.StatusChange{
+		Rid: ev.Rid, From: string(from), To: string(to), Reason: ev.Reason,
+		Source: src.Kind, SourceRef: src.Ref, At: at,
+	}
+	if from == to {
+		return ch, nil
+	}
+	if err := lifecycle.Check(from, to); err != nil {
+		return models.StatusChange{}, fmt.Errorf("order %s: %w", ev.OrderUID, err)
+	}
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE order_statuses SET status = $3, updated_at = $4
+		WHERE order_uid = $1 AND rid = $2
+	`, ev.OrderUID, ev.Rid, string(to), at); err != nil {
+		return models.StatusChange{}, err
+	}
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO order_status_history
+		  (order_uid, rid, from_status, to_status, reason, source, source_ref, changed_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8)
+	`, ev.OrderUID, ev.Rid, ch.From, ch.To, ev.Reason, src.Kind, src.Ref, at); err != nil {
+		return models.StatusChange{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return models.StatusChange{}, err
+	}
+	return ch, nil
+}
+
+// GetStatus возвращает текущий статус заказа, статусы позиций и историю переходов.
+// Первая запись истории — создание заказа (date_created).
+func (r *OrdersRepo) GetStatus(ctx context.Context, id string) (st models.OrderStatus, err error) {
+	defer func(start time.Time) {
+		err = wrapErr(err)
+		r.observe(ctx, OpGetStatus, start, err)
+	}(time.Now())
+
+	var created time.Time
+	err = r.pool.QueryRow(ctx, `SELECT date_created FROM orders WHERE order_uid = $1`, id).Scan(&created)
+	if err != nil {
+		return models.OrderStatus{}, err
+	}
+	st = models.OrderStatus{
+		OrderUID:  id,
+		Status:    string(lifecycle.Created),
+		UpdatedAt: created,
+		Timeline:  []models.StatusChange{{To: string(lifecycle.Created), At: created}},
+	}
+
+	rows, err := r.pool.Query(ctx, `
+		SELECT rid, status, updated_at FROM order_statuses
+		WHERE order_uid = $1 ORDER BY rid
+	`, id)
+	if err != nil {
+		return models.OrderStatus{}, err
+	}
+	items, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.ItemStatus, error) {
+		var it models.ItemStatus
+		err := row.Scan(&it.Rid, &it.Status, &it.UpdatedAt)
+		return it, err
+	})
+	if err != nil {
+		return models.OrderStatus{}, err
+	}
+	for _, it := range items {
+		if it.Rid == "" {
+			st.Status, st.UpdatedAt = it.Status, it.UpdatedAt
+			continue
+		}
+		st.Items = append(st.Items, it)
+	}
+
+	rows, err = r.pool.Query(ctx, `
+		SELECT rid, from_status, to_status, reason, source, source_ref, changed_at
+		FROM order_status_history
+		WHERE order_uid = $1 ORDER BY changed_at, id
+	`, id)
+	if err != nil {
+		return models.OrderStatus{}, err
+	}
+	timeline, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.StatusChange, error) {
+		var ch models.StatusChange
+		err := row.Scan(&ch.Rid, &ch.From, &ch.To, &ch.Reason, &ch.Source, &ch.SourceRef, &ch.At)
+		return ch, err
+	})
+	if err != nil {
+		return models.OrderStatus{}, err
+	}
+	st.Timeline = append(st.Timeline, timeline...)
+	return st, nil
+}
@@ -0,0 +1,121 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/models"
+)
+
+// Источники записи заказа.
+const (
+	SourceKafka = "kafka"
+	SourceHTTP  = "http"
+	SourceAdmin = "admin"
+)
+
+// Source — откуда пришла запись заказа. Сохраняется вместе со снимком в order_versions.
+type Source struct {
+	Kind string // SourceKafka, SourceHTTP или SourceAdmin
+	// Ref уточняет источник: для Kafka — topic/partition/offset, для HTTP — request_id.
+	Ref string
+	// At — свежесть копии заказа. Копия старее уже сохранённой не применяется.
+	// Нулевое значение — свежесть неизвестна, запись применяется всегда.
+	At time.Time
+}
+
+// KafkaSource описывает сообщение Kafka как источник записи.
+func KafkaSource(topic string, partition int, offset int64) Source {
+	return Source{Kind: SourceKafka, Ref: topic + "/" + strconv.Itoa(partition) + "/" + strconv.FormatInt(offset, 10)}
+}
+
+// OrderWrite — заказ из пачки вместе с источником.
+type OrderWrite struct {
+	Order  models.Order
+	Source Source
+}
+
+// insertVersionSQL добавляет снимок с номером версии $5. Номер берётся из orders.version,
+// который вернул upsert (RETURNING version), поэтому история и orders.version
+// не расходятся. Параллельные записи одного заказа не получат одинаковый номер:
+// upsert держит блокировку строки orders до конца транзакции.
+const insertVersionSQL = `
+	INSERT INTO order_versions (order_uid, version, data, source, source_ref)
+	VALUES ($1, $5, $2, $3, $4)
+`
+
+func versionArgs(o models.Order, src Source) ([]any, error) {
+	data, err := json.Marshal(o)
+	if err != nil {
+		return nil, fmt.Errorf("marshal order %s: %w", o.OrderUID, err)
+	}
+	kind := src.Kind
+	if kind == "" {
+		kind = SourceAdmin
+	}
+	return []any{o.OrderUID, data, kind, src.Ref}, nil
+}
+
+// ListOrderVersions возвращает историю заказа от первой версии к последней, без снимков.
+// Если версий нет, возвращается ErrNotFound.
+func (r *OrdersRepo) ListOrderVersions(ctx context.Context, id string) (out []models.OrderVersion, err error) {
+	defer func(start time.Time) {
+		err = wrapErr(err)
+		r.observe(ctx, OpListOrderVersions, start, err)
+	}(time.Now())
+
+	rows, err := r.pool.Query(ctx, `
+		SELECT order_uid, version, source, source_ref, created_at
+		FROM order_versions WHERE order_uid = $1
+		ORDER BY version
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var v models.OrderVersion
+		if err := rows.Scan(&v.OrderUID, &v.Version, &v.Source, &v.SourceRef, &v.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("order versions %w", ErrNotFound)
+	}
+	return out, nil
+}
+
+// GetOrderVersion возвращает снимок заказа в указанной версии.
+// Версия 0 — последняя.
+func (r *OrdersRepo) GetOrderVersion(ctx context.Context, id string, version int) (v models.OrderVersion, err error) {
+	defer func(start time.Time) {
+		err = wrapErr(err)
+		r.observe(ctx, OpGetOrderVersion, start, err)
+	}(time.Now())
+
+	var data []byte
+	err = r.pool.QueryRow(ctx, `
+		SELECT order_uid, version, source, source_ref, created_at, data
+		FROM order_versions
+		WHERE order_uid = $1 AND ($2 = 0 OR version = $2)
+		ORDER BY version DESC LIMIT 1
+	`, id, version).Scan(&v.OrderUID, &v.Version, &v.Source, &v.SourceRef, &v.CreatedAt, &data)
+	if err != nil {
+		return models.OrderVersion{}, err
+	}
+
+	var o models.Order
+	if err := json.Unmarshal(data, &o); err != nil {
+		return models.OrderVersion{}, fmt.Errorf("decode order %s version %d: %w", id, v.Version, err)
+	}
+	v.Order = &o
+	return v, nil
+}
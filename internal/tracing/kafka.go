@@ -0,0 +1,57 @@
+package tracing
+
+import (
+	"context"
+	"strings"
+
+	kafka "github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// HeaderCarrier даёт пропагатору доступ к заголовкам Kafka-сообщения.
+type HeaderCarrier struct {
+	Headers *[]kafka.Header
+}
+
+var _ propagation.TextMapCarrier = HeaderCarrier{}
+
+// Get возвращает значение заголовка. Имена сравниваются без учёта регистра.
+func (c HeaderCarrier) Get(key string) string {
+	for _, h := range *c.Headers {
+		if strings.EqualFold(h.Key, key) {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+// Set заменяет заголовок или добавляет новый.
+func (c HeaderCarrier) Set(key, value string) {
+	for i, h := range *c.Headers {
+		if strings.EqualFold(h.Key, key) {
+			(*c.Headers)[i].Value = []byte(value)
+			return
+		}
+	}
+	*c.Headers = append(*c.Headers, kafka.Header{Key: key, Value: []byte(value)})
+}
+
+// Keys возвращает имена всех заголовков.
+func (c HeaderCarrier) Keys() []string {
+	keys := make([]string, 0, len(*c.Headers))
+	for _, h := range *c.Headers {
+		keys = append(keys, h.Key)
+	}
+	return keys
+}
+
+// Inject записывает контекст трассировки из ctx в заголовки сообщения.
+func Inject(ctx context.Context, m *kafka.Message) {
+	otel.GetTextMapPropagator().Inject(ctx, HeaderCarrier{Headers: &m.Headers})
+}
+
+// Extract достаёт контекст трассировки из заголовков сообщения.
+func Extract(ctx context.Context, m kafka.Message) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, HeaderCarrier{Headers: &m.Headers})
+}
@@ -0,0 +1,104 @@
+// Package tracing настраивает OpenTelemetry и переносит контекст трассировки
+// через заголовки Kafka-сообщений в формате W3C Trace Context.
+//
+// Спаны пишутся экспортёром в stdout или в файл (по строке JSON на спан),
+// поэтому для локальной отладки коллектор не нужен.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Экспортёры спанов.
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+)
+
+// Config — настройки трассировки.
+type Config struct {
+	ServiceName string
+	// Exporter — none, stdout или file. При none спаны не пишутся,
+	// но входящий контекст трассировки всё равно передаётся дальше.
+	Exporter string
+	// File — куда писать спаны при Exporter = file. Файл дописывается.
+	File string
+	// SampleRatio — доля трасс, которые пишутся (0..1). Если у входящего
+	// сообщения или запроса трасса уже выбрана, решение родителя сохраняется.
+	SampleRatio float64
+}
+
+// Setup ставит глобальные TracerProvider и пропагатор (traceparent и baggage).
+// Возвращённую функцию нужно вызвать при остановке: она дописывает
+// оставшиеся спаны и закрывает файл.
+func Setup(cfg Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{},
+	))
+
+	var (
+		w      io.Writer
+		closer io.Closer
+	)
+	switch strings.ToLower(strings.TrimSpace(cfg.Exporter)) {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		w = os.Stdout
+	case ExporterFile:
+		if cfg.File == "" {
+			return nil, errors.New("tracing: file exporter requires a file path")
+		}
+		f, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("tracing: %w", err)
+		}
+		w, closer = f, f
+	default:
+		return nil, fmt.Errorf("tracing: unknown exporter %q, expected none, stdout or file", cfg.Exporter)
+	}
+
+	exp, err := stdouttrace.New(stdouttrace.WithWriter(w))
+	if err != nil {
+		return nil, fmt.Errorf("tracing: %w", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", cfg.ServiceName))),
+	)
+	otel.SetTracerProvider(tp)
+
+	return func(ctx context.Context) error {
+		err := tp.Shutdown(ctx)
+		if closer != nil {
+			err = errors.Join(err, closer.Close())
+		}
+		return err
+	}, nil
+}
+
+// End завершает спан, отмечая в нём ошибку, если она есть.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
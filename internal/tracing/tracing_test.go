@@ -0,0 +1,55 @@
+package tracing
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	kafka "github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestKafkaPropagationAndFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.json")
+	shutdown, err := Setup(Config{ServiceName: "test", Exporter: ExporterFile, File: path, SampleRatio: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, span := otel.Tracer("test").Start(context.Background(), "orders publish")
+	m := kafka.Message{Headers: []kafka.Header{{Key: "x-other", Value: []byte("1")}}}
+	Inject(ctx, &m)
+	span.End()
+
+	if got := (HeaderCarrier{Headers: &m.Headers}).Get("Traceparent"); got == "" {
+		t.Fatalf("traceparent not injected: %v", m.Headers)
+	}
+
+	got := trace.SpanContextFromContext(Extract(context.Background(), m))
+	if !got.IsRemote() || got.TraceID() != span.SpanContext().TraceID() || got.SpanID() != span.SpanContext().SpanID() {
+		t.Fatalf("extracted %v, want span %v", got, span.SpanContext())
+	}
+
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"Name":"orders publish"`) {
+		t.Fatalf("span not exported to file: %s", b)
+	}
+}
+
+func TestSetupErrors(t *testing.T) {
+	if _, err := Setup(Config{Exporter: "jaeger"}); err == nil {
+		t.Fatal("expected error for unknown exporter")
+	}
+	if _, err := Setup(Config{Exporter: ExporterFile}); err == nil {
+		t.Fatal("expected error for file exporter without path")
+	}
+}
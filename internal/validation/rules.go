@@ -0,0 +1,95 @@
+package validation
+
+import (
+	"fmt"
+
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/models"
+)
+
+// Имена встроенных правил.
+const (
+	RuleGoodsTotal = "goods_total"
+	RuleAmount     = "amount"
+	RuleItemTrack  = "item_track_number"
+	RuleItemTotal  = "item_total_price"
+)
+
+// Builtin возвращает встроенные правила. Все они по умолчанию отклоняют заказ.
+func Builtin() []Rule {
+	return []Rule{
+		{Name: RuleGoodsTotal, Severity: SeverityReject, Check: checkGoodsTotal},
+		{Name: RuleAmount, Severity: SeverityReject, Check: checkAmount},
+		{Name: RuleItemTrack, Severity: SeverityReject, Check: checkItemTrack},
+		{Name: RuleItemTotal, Severity: SeverityReject, Check: checkItemTotal},
+	}
+}
+
+// Names возвращает имена встроенных правил.
+func Names() []string {
+	rules := Builtin()
+	names := make([]string, len(rules))
+	for i, r := range rules {
+		names[i] = r.Name
+	}
+	return names
+}
+
+// checkGoodsTotal: payment.goods_total — сумма total_price позиций.
+func checkGoodsTotal(o models.Order) []Violation {
+	sum := 0
+	for _, it := range o.Items {
+		sum += it.TotalPrice
+	}
+	if o.Payment.GoodsTotal == sum {
+		return nil
+	}
+	return []Violation{{
+		Field:   "payment.goods_total",
+		Message: fmt.Sprintf("is %d, expected sum of items total_price %d", o.Payment.GoodsTotal, sum),
+	}}
+}
+
+// checkAmount: payment.amount = goods_total + delivery_cost + custom_fee.
+func checkAmount(o models.Order) []Violation {
+	p := o.Payment
+	want := p.GoodsTotal + p.DeliveryCost + p.CustomFee
+	if p.Amount == want {
+		return nil
+	}
+	return []Violation{{
+		Field:   "payment.amount",
+		Message: fmt.Sprintf("is %d, expected goods_total + delivery_cost + custom_fee = %d", p.Amount, want),
+	}}
+}
+
+// checkItemTrack: трек-номер позиции совпадает с трек-номером заказа.
+func checkItemTrack(o models.Order) []Violation {
+	var out []Violation
+	for i, it := range o.Items {
+		if it.TrackNumber != o.TrackNumber {
+			out = append(out, Violation{
+				Field:   fmt.Sprintf("items[%d].track_number", i),
+				Message: fmt.Sprintf("is %q, expected order track_number %q", it.TrackNumber, o.TrackNumber),
+			})
+		}
+	}
+	return out
+}
+
+// checkItemTotal: total_price = price*(100-sale)/100. Копейки округляются
+// в любую сторону, поэтому подходят оба ближайших целых.
+func checkItemTotal(o models.Order) []Violation {
+	var out []Violation
+	for i, it := range o.Items {
+		exact := it.Price * (100 - it.Sale)
+		floor, ceil := exact/100, (exact+99)/100
+		if it.TotalPrice == floor || it.TotalPrice == ceil {
+			continue
+		}
+		out = append(out, Violation{
+			Field:   fmt.Sprintf("items[%d].total_price", i),
+			Message: fmt.Sprintf("is %d, expected price*(100-sale)/100 = %d", it.TotalPrice, floor),
+		})
+	}
+	return out
+}
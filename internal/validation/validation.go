@@ -0,0 +1,172 @@
+// Package validation проверяет заказ бизнес-правилами, которые не выразить
+// тегами validator: сходятся ли суммы оплаты, цены позиций и трек-номера.
+//
+// Каждое правило имеет имя и серьёзность: reject — заказ отклоняется,
+// warn — заказ принимается, нарушение только попадает в логи и метрики.
+// Правила можно выключать и понижать до warn по имени.
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/models"
+)
+
+// Severity — что делать при нарушении правила.
+type Severity string
+
+const (
+	// SeverityReject — заказ отклоняется.
+	SeverityReject Severity = "reject"
+	// SeverityWarn — заказ принимается, нарушение логируется.
+	SeverityWarn Severity = "warn"
+)
+
+// Violation — одно нарушение правила.
+type Violation struct {
+	Rule     string   `json:"rule"`
+	Severity Severity `json:"severity"`
+	// Field — путь к полю в JSON, как в ошибках валидации, например items[0].total_price.
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+func (v Violation) String() string {
+	if v.Field == "" {
+		return v.Rule + ": " + v.Message
+	}
+	return v.Rule + ": " + v.Field + " " + v.Message
+}
+
+// Rule — именованное правило. Check возвращает нарушения без Rule и Severity,
+// их заполняет Engine.
+type Rule struct {
+	Name     string
+	Severity Severity
+	Check    func(o models.Order) []Violation
+}
+
+// Error — заказ нарушил правила с серьёзностью reject.
+type Error struct {
+	Violations []Violation
+}
+
+func (e *Error) Error() string {
+	msgs := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		msgs[i] = v.String()
+	}
+	return "business rules violated: " + strings.Join(msgs, "; ")
+}
+
+// ErrUnknownRule — в настройках указано правило, которого нет.
+var ErrUnknownRule = errors.New("unknown validation rule")
+
+// Engine проверяет заказы набором правил. Методы можно вызывать на nil:
+// тогда проверок нет.
+type Engine struct {
+	rules []Rule
+}
+
+// Option настраивает набор правил.
+type Option func(*settings)
+
+type settings struct {
+	rules   []Rule
+	disable []string
+	warn    []string
+}
+
+// WithRules добавляет правила к встроенным (или заменяет встроенное с тем же именем).
+func WithRules(rules ...Rule) Option {
+	return func(s *settings) {
+		for _, r := range rules {
+			s.rules = slices.DeleteFunc(s.rules, func(old Rule) bool { return old.Name == r.Name })
+			s.rules = append(s.rules, r)
+		}
+	}
+}
+
+// Disable выключает правила по имени.
+func Disable(names ...string) Option {
+	return func(s *settings) { s.disable = append(s.disable, names...) }
+}
+
+// Warn понижает правила до SeverityWarn.
+func Warn(names ...string) Option {
+	return func(s *settings) { s.warn = append(s.warn, names...) }
+}
+
+// New собирает движок из встроенных правил (см. Builtin) и опций.
+// Неизвестное имя в Disable или Warn — ошибка ErrUnknownRule.
+func New(opts ...Option) (*Engine, error) {
+	s := &settings{rules: Builtin()}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	index := make(map[string]int, len(s.rules))
+	for i, r := range s.rules {
+		index[r.Name] = i
+	}
+	var errs []error
+	for _, name := range append(slices.Clone(s.disable), s.warn...) {
+		if _, ok := index[name]; !ok {
+			errs = append(errs, fmt.Errorf("%w %q", ErrUnknownRule, name))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	e := &Engine{}
+	for _, r := range s.rules {
+		if slices.Contains(s.disable, r.Name) {
+			continue
+		}
+		if slices.Contains(s.warn, r.Name) {
+			r.Severity = SeverityWarn
+		}
+		e.rules = append(e.rules, r)
+	}
+	return e, nil
+}
+
+// Rules возвращает имена включённых правил.
+func (e *Engine) Rules() []string {
+	if e == nil {
+		return nil
+	}
+	names := make([]string, len(e.rules))
+	for i, r := range e.rules {
+		names[i] = r.Name
+	}
+	return names
+}
+
+// Check проверяет заказ всеми правилами. Нарушения с серьёзностью warn
+// возвращаются списком, с серьёзностью reject — ошибкой *Error.
+func (e *Engine) Check(o models.Order) (warnings []Violation, err error) {
+	if e == nil {
+		return nil, nil
+	}
+
+	var rejected []Violation
+	for _, r := range e.rules {
+		for _, v := range r.Check(o) {
+			v.Rule, v.Severity = r.Name, r.Severity
+			if r.Severity == SeverityWarn {
+				warnings = append(warnings, v)
+			} else {
+				rejected = append(rejected, v)
+			}
+		}
+	}
+	if len(rejected) > 0 {
+		return warnings, &Error{Violations: rejected}
+	}
+	return warnings, nil
+}
@@ -0,0 +1,146 @@
+package validation
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/models"
+)
+
+// consistentOrder — заказ, который проходит все встроенные правила.
+func consistentOrder() models.Order {
+	return models.Order{
+		OrderUID:    "order1",
+		TrackNumber: "WBILTRACK",
+		Payment:     models.Payment{Amount: 2317, DeliveryCost: 1500, GoodsTotal: 817, CustomFee: 0},
+		Items: []models.Item{
+			{TrackNumber: "WBILTRACK", Price: 453, Sale: 30, TotalPrice: 317},
+			{TrackNumber: "WBILTRACK", Price: 500, Sale: 0, TotalPrice: 500},
+		},
+	}
+}
+
+func TestSampleOrdersPassBuiltinRules(t *testing.T) {
+	e, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"model.json", "model_002.json", "model_003.json", "model_004.json"} {
+		data, err := os.ReadFile("../../" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var o models.Order
+		if err := json.Unmarshal(bytes.TrimPrefix(data, []byte{0xEF, 0xBB, 0xBF}), &o); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if warnings, err := e.Check(o); err != nil || len(warnings) != 0 {
+			t.Errorf("%s: warnings %v, err %v", name, warnings, err)
+		}
+	}
+}
+
+func TestBuiltinRules(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*models.Order)
+		rule   string
+		field  string
+	}{
+		{"goods total", func(o *models.Order) { o.Payment.GoodsTotal, o.Payment.Amount = 800, 2300 }, RuleGoodsTotal, "payment.goods_total"},
+		{"amount", func(o *models.Order) { o.Payment.Amount = 1 }, RuleAmount, "payment.amount"},
+		{"item track", func(o *models.Order) { o.Items[1].TrackNumber = "OTHER" }, RuleItemTrack, "items[1].track_number"},
+		{"item total", func(o *models.Order) { o.Items[0].TotalPrice = 300; o.Payment.GoodsTotal, o.Payment.Amount = 800, 2300 }, RuleItemTotal, "items[0].total_price"},
+	}
+
+	e, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := consistentOrder()
+			tt.modify(&o)
+
+			_, err := e.Check(o)
+			var verr *Error
+			if !errors.As(err, &verr) || len(verr.Violations) != 1 {
+				t.Fatalf("expected one violation, got %v", err)
+			}
+			v := verr.Violations[0]
+			if v.Rule != tt.rule || v.Field != tt.field || v.Severity != SeverityReject {
+				t.Fatalf("unexpected violation %+v", v)
+			}
+		})
+	}
+}
+
+func TestItemTotalRounding(t *testing.T) {
+	o := consistentOrder()
+	// 453*70/100 = 317.1: подходит и 317, и 318
+	o.Items[0].TotalPrice = 318
+	o.Payment.GoodsTotal, o.Payment.Amount = 818, 2318
+	if violations := checkItemTotal(o); len(violations) != 0 {
+		t.Fatalf("ceil should be accepted: %v", violations)
+	}
+}
+
+func TestWarnAndDisable(t *testing.T) {
+	o := consistentOrder()
+	o.Payment.Amount = 1
+	o.Items[0].TrackNumber = "OTHER"
+
+	e, err := New(Warn(RuleAmount), Disable(RuleItemTrack))
+	if err != nil {
+		t.Fatal(err)
+	}
+	warnings, err := e.Check(o)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(warnings) != 1 || warnings[0].Rule != RuleAmount || warnings[0].Severity != SeverityWarn {
+		t.Fatalf("unexpected warnings: %+v", warnings)
+	}
+	if got := e.Rules(); len(got) != len(Builtin())-1 {
+		t.Fatalf("enabled rules = %v", got)
+	}
+}
+
+func TestUnknownRule(t *testing.T) {
+	if _, err := New(Disable("nope"), Warn("amount")); !errors.Is(err, ErrUnknownRule) {
+		t.Fatalf("expected ErrUnknownRule, got %v", err)
+	}
+}
+
+func TestCustomRule(t *testing.T) {
+	e, err := New(WithRules(Rule{
+		Name:     "no_test_orders",
+		Severity: SeverityReject,
+		Check: func(o models.Order) []Violation {
+			if o.OrderUID == "order1" {
+				return []Violation{{Field: "order_uid", Message: "is a test order"}}
+			}
+			return nil
+		},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = e.Check(consistentOrder())
+	var verr *Error
+	if !errors.As(err, &verr) || verr.Violations[0].Rule != "no_test_orders" {
+		t.Fatalf("expected custom rule violation, got %v", err)
+	}
+}
+
+func TestNilEngine(t *testing.T) {
+	var e *Engine
+	o := consistentOrder()
+	o.Payment.Amount = 1
+	if warnings, err := e.Check(o); warnings != nil || err != nil {
+		t.Fatal("nil engine should not check anything")
+	}
+}
@@ -1,113 +1,408 @@
 // Package main — точка входа в сервис wb-order-service.
-// Здесь происходит инициализация базы данных, кэша, сервера и консьюмера.
+// Здесь загружается конфиг и происходит инициализация базы данных, кэша, сервера и консьюмера.
 package main
 
 import (
 	"context"
 	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"io/fs"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
-	"strings"
 	"syscall"
 	"time"
 
 	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/cache"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/config"
 	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/db"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/health"
 	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/httpserver"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/ingest"
 	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/kafkaconsumer"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/logging"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/metrics"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/migrate"
 	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/repo"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/tracing"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/internal/validation"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/migrations"
+	"github.com/Stanislav-Grinevich/wb-order-service-grinevich/web"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	kafka "github.com/segmentio/kafka-go"
 )
 
 func main() {
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	// логгер передаётся компонентам явно; default выставляется для сторонних библиотек
+	lg, err := logging.New(os.Stdout, cfg.Log.Level, cfg.Log.Format)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	slog.SetDefault(lg)
+
+	// failed — почему сервис остановился сам. Код выхода выставляется последним,
+	// после всех отложенных закрытий.
+	var failed error
+	defer func() {
+		if failed != nil {
+			os.Exit(1)
+		}
+	}()
+
+	shutdownTracing, err := tracing.Setup(tracing.Config{
+		ServiceName: "wb-order-service",
+		Exporter:    cfg.Tracing.Exporter,
+		File:        cfg.Tracing.File,
+		SampleRatio: cfg.Tracing.SampleRatio,
+	})
+	if err != nil {
+		fatal(lg, "tracing setup", err)
+	}
+
 	// контекст для graceful shutdown
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
 	// подключение к psql
-	pool, err := db.NewPostgresPool(ctx)
+	pool, err := db.NewPostgresPool(ctx, cfg.Postgres)
 	if err != nil {
-		log.Fatal(err)
+		fatal(lg, "connect to postgres", err)
 	}
 	defer pool.Close()
 
+	// миграции до всего остального: репозиторию и прогреву нужна актуальная схема
+	if cfg.Migrations.Auto {
+		if err := runMigrations(ctx, pool, cfg.Migrations.Dir, lg.With("component", "migrate")); err != nil {
+			fatal(lg, "migrations", err)
+		}
+	}
+
+	// метрики
+	mt := metrics.New()
+	mt.Register(metrics.NewPoolCollector(pool))
+
 	// репозиторий и кэш
-	rp := repo.NewOrdersRepo(pool)
-	cc := cache.New()
+	rp := repo.NewOrdersRepo(pool,
+		repo.WithQueryObserver(mt.ObserveQuery),
+		repo.WithLogger(lg.With("component", "repo")),
+	)
 
-	// прогрев кэша
-	orders, err := rp.LoadAllOrders(ctx, 200)
+	policy, err := cache.ParsePolicy(cfg.Cache.Policy)
 	if err != nil {
-		log.Fatal(err)
+		fatal(lg, "cache policy", err)
+	}
+	cacheOpts := []cache.Option{
+		cache.WithPolicy(policy),
+		cache.WithTTL(cfg.Cache.TTL),
+		cache.WithMaxBytes(cfg.Cache.MaxBytes),
+	}
+	var cc cache.ManagedCache
+	if cfg.Cache.Shards > 1 {
+		cc = cache.NewSharded(cfg.Cache.Shards, cfg.Cache.Size, cacheOpts...)
+	} else {
+		cc = cache.NewWithLimit(cfg.Cache.Size, cacheOpts...)
 	}
+	defer cc.Close()
+	mt.Register(metrics.NewCacheCollector(cc))
 
-	// если БД пустая, то вставляем тестовый заказ
-	if len(orders) == 0 {
-		if err := rp.InsertTestOrder(ctx); err != nil {
-			log.Fatal(err)
-		}
-		orders, _ = rp.LoadAllOrders(ctx, 1)
+	rules, err := validation.New(
+		validation.Disable(cfg.Validation.Disable...),
+		validation.Warn(cfg.Validation.Warn...),
+	)
+	if err != nil {
+		fatal(lg, "validation rules", err)
 	}
 
-	cc.Load(orders)
-	log.Printf("cache warmup: %d orders", len(orders))
+	// консьюмер Kafka создаётся заранее, чтобы его состояние попало в /readyz,
+	// а запускается после прогрева кэша
+	consumer := kafkaconsumer.New(consumerConfig(cfg.Kafka), rp, cc,
+		kafkaconsumer.WithRejectedStore(repo.NewRejectedRepo(pool)),
+		kafkaconsumer.WithStatusStore(rp),
+		kafkaconsumer.WithRules(rules),
+		kafkaconsumer.WithMetrics(mt),
+		kafkaconsumer.WithLogger(lg.With("component", "kafka")),
+	)
+
+	// проверки готовности
+	warmedUp := health.NewFlag("cache warmup in progress")
+	hc := health.New(health.WithTimeout(cfg.Health.Timeout))
+	hc.Add("postgres", pool.Ping, cfg.Health.DBTimeout)
+	hc.Add("kafka_consumer", consumer.Check, cfg.Health.KafkaTimeout)
+	hc.Add("cache_warmup", warmedUp.Check, 0)
 
-	// HTTP-сервер
-	srv := httpserver.New(cc, rp)
+	// HTTP-сервер поднимается сразу: /healthz отвечает, а /readyz отдаёт 503,
+	// пока не прогрет кэш и не запущен консьюмер
+	idem := repo.NewIdempotencyRepo(pool)
+	srv := httpserver.New(cc, rp,
+		httpserver.WithNegativeTTL(cfg.Cache.NegativeTTL),
+		httpserver.WithIdempotency(idem),
+		httpserver.WithHistory(rp),
+		httpserver.WithStatus(rp),
+		httpserver.WithRules(rules),
+		httpserver.WithFreshness(ingest.Freshness(cfg.Kafka.Freshness), cfg.Kafka.FreshnessHeader),
+		httpserver.WithWebFS(dirOr(cfg.HTTP.WebDir, web.FS)),
+		httpserver.WithMetrics(mt),
+		httpserver.WithHealth(hc),
+		httpserver.WithLogger(lg.With("component", "http")),
+	)
 
 	server := &http.Server{
-		Addr:              ":8081",
+		Addr:              cfg.HTTP.Addr,
 		Handler:           srv.Handler(),
-		ReadHeaderTimeout: 5 * time.Second,
+		ReadHeaderTimeout: cfg.HTTP.ReadHeaderTimeout,
+		ReadTimeout:       cfg.HTTP.ReadTimeout,
+		WriteTimeout:      cfg.HTTP.WriteTimeout,
+		IdleTimeout:       cfg.HTTP.IdleTimeout,
 	}
 
 	go func() {
-		fmt.Println("HTTP server listening on :8081")
+		lg.Info("HTTP server listening", "addr", cfg.HTTP.Addr)
 		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			// сравнение ошибок через errors.Is
-			log.Fatal(err)
+			fatal(lg, "HTTP server", err)
 		}
 	}()
 
-	// консьюмер Kafka
-	kcfg := kafkaconsumer.Config{
-		Brokers: splitCSV(os.Getenv("KAFKA_BROKERS")),
-		Topic:   os.Getenv("KAFKA_TOPIC"),
-		GroupID: os.Getenv("KAFKA_GROUP"),
+	// прогрев кэша: из снимка, если он есть, иначе из БД
+	if !restoreSnapshot(ctx, cc, rp, cfg.Cache.SnapshotPath, lg) {
+		orders, err := rp.LoadAllOrders(ctx, cfg.Cache.WarmupSize)
+		if err != nil {
+			fatal(lg, "cache warmup", err)
+		}
+
+		// если БД пустая, то вставляем тестовый заказ
+		if len(orders) == 0 {
+			if err := rp.InsertTestOrder(ctx); err != nil {
+				fatal(lg, "insert test order", err)
+			}
+			orders, _ = rp.LoadAllOrders(ctx, 1)
+		}
+
+		cc.Load(orders)
+		lg.Info("cache warmup done", "orders", len(orders))
 	}
+	warmedUp.Set()
 
-	consumer := kafkaconsumer.New(kcfg, rp, cc)
-	defer consumer.Close()
+	if cfg.Cache.SnapshotPath != "" && cfg.Cache.SnapshotInterval > 0 {
+		go saveSnapshots(ctx, cc, cfg.Cache.SnapshotPath, cfg.Cache.SnapshotInterval, lg)
+	}
+	go purgeIdempotencyKeys(ctx, idem, idempotencyPurgeInterval, lg)
 
-	go consumer.Run(ctx)
+	// консьюмер, остановившийся из-за ошибки, сам не перезапустится, а HTTP и /healthz
+	// продолжат отвечать. Поэтому процесс завершается, и его перезапускает оркестратор.
+	// consumerDone закрывается, когда Run вернулся, то есть все воркеры дописали своё.
+	consumerDone := make(chan struct{})
+	go func() {
+		defer close(consumerDone)
+		if err := consumer.Run(ctx); err != nil {
+			lg.Error("kafka consumer stopped", "error", err)
+			failed = err
+		}
+		stop()
+	}()
 
-	// ожидание окончания работы (Ctrl+C)
+	// ожидание окончания работы (Ctrl+C) или остановки консьюмера
 	<-ctx.Done()
-	log.Println("shutting down...")
+	lg.Info("shutting down")
 
 	// аккуратная остановка HTTP сервера
-	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.HTTP.ShutdownTimeout)
 	defer cancel()
 
 	_ = server.Shutdown(shutdownCtx)
+
+	// снимок и закрытие пула — только после того, как воркеры перестали писать в кэш и БД
+	<-consumerDone
+	if err := consumer.Close(); err != nil {
+		lg.Error("kafka consumer close failed", "error", err)
+	}
+
+	// снимок кэша для быстрого следующего старта
+	if cfg.Cache.SnapshotPath != "" {
+		saveSnapshot(cc, cfg.Cache.SnapshotPath, lg)
+	}
+
+	// оставшиеся спаны дописываются после остановки HTTP
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		lg.Error("tracing shutdown failed", "error", err)
+	}
 }
 
-// splitCSV превращает строку с брокерами в массив
-func splitCSV(s string) []string {
-	if s == "" {
-		return nil
+// snapshotClockSkew — запас на расхождение часов сервиса и Postgres:
+// изменения догружаются начиная с чуть более раннего момента, чем снят снимок.
+const snapshotClockSkew = time.Minute
+
+// restoreSnapshot загружает кэш из снимка и в фоне догружает из БД заказы,
+// изменённые после него. false — снимков нет или файл не читается,
+// тогда кэш нужно прогреть из БД.
+func restoreSnapshot(ctx context.Context, cc cache.ManagedCache, rp *repo.OrdersRepo, path string, lg *slog.Logger) bool {
+	if path == "" {
+		return false
 	}
+	info, err := cc.LoadSnapshot(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		lg.Info("no cache snapshot yet", "path", path)
+		return false
+	case err != nil:
+		lg.Warn("cache snapshot ignored", "path", path, "error", err)
+		return false
+	}
+	lg.Info("cache loaded from snapshot", "path", path, "orders", info.Orders, "taken_at", info.TakenAt)
 
-	parts := strings.Split(s, ",")
-	out := make([]string, 0, len(parts))
+	go func() {
+		start := time.Now()
+		n, err := cc.Reconcile(ctx, rp, info.TakenAt.Add(-snapshotClockSkew))
+		if err != nil {
+			if ctx.Err() == nil {
+				lg.Warn("cache reconcile failed", "error", err)
+			}
+			return
+		}
+		lg.Info("cache reconciled with postgres", "updated", n, "took", time.Since(start))
+	}()
+	return true
+}
+
+// saveSnapshots записывает снимок кэша каждые every, пока не отменён ctx.
+func saveSnapshots(ctx context.Context, cc cache.ManagedCache, path string, every time.Duration, lg *slog.Logger) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			saveSnapshot(cc, path, lg)
+		}
+	}
+}
+
+// saveSnapshot записывает снимок кэша. Ошибка только логируется: без снимка
+// сервис просто прогреет кэш из БД при следующем старте.
+func saveSnapshot(cc cache.ManagedCache, path string, lg *slog.Logger) {
+	start := time.Now()
+	info, err := cc.SaveSnapshot(path)
+	if err != nil {
+		lg.Error("cache snapshot failed", "path", path, "error", err)
+		return
+	}
+	lg.Info("cache snapshot saved", "path", path, "orders", info.Orders, "took", time.Since(start))
+}
+
+// idempotencyPurgeInterval — как часто удалять устаревшие ключи идемпотентности.
+const idempotencyPurgeInterval = time.Hour
 
-	for _, p := range parts {
-		if v := strings.TrimSpace(p); v != "" {
-			out = append(out, v)
+// purgeIdempotencyKeys удаляет устаревшие ключи идемпотентности каждые every,
+// пока не отменён ctx. Ошибка только логируется: попробуем в следующий раз.
+func purgeIdempotencyKeys(ctx context.Context, idem *repo.IdempotencyRepo, every time.Duration, lg *slog.Logger) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			n, err := idem.DeleteExpiredIdempotency(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					lg.Warn("purge idempotency keys failed", "error", err)
+				}
+				continue
+			}
+			lg.Info("expired idempotency keys purged", "deleted", n)
 		}
 	}
+}
+
+// runMigrations накатывает неприменённые миграции: встроенные или из dir.
+// Реплики, стартующие одновременно, ждут друг друга на advisory-локе мигратора.
+func runMigrations(ctx context.Context, pool *pgxpool.Pool, dir string, lg *slog.Logger) error {
+	list, err := migrate.Load(dirOr(dir, migrations.FS))
+	if err != nil {
+		return err
+	}
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
 
-	return out
+	done, err := migrate.New(conn.Conn(), list, migrate.WithLogger(lg)).Up(ctx, 0)
+	if err != nil {
+		return err
+	}
+	lg.Info("migrations up to date", "applied", len(done), "total", len(list))
+	return nil
+}
+
+// dirOr возвращает папку dir, если она задана, иначе встроенные файлы.
+func dirOr(dir string, embedded fs.FS) fs.FS {
+	if dir == "" {
+		return embedded
+	}
+	return os.DirFS(dir)
+}
+
+// fatal пишет ошибку запуска в лог и завершает процесс.
+func fatal(lg *slog.Logger, msg string, err error) {
+	lg.Error(msg+" failed", "error", err)
+	os.Exit(1)
+}
+
+// consumerConfig переносит настройки Kafka из конфига в консьюмера.
+func consumerConfig(k config.Kafka) kafkaconsumer.Config {
+	start := kafka.LastOffset
+	if k.StartOffset == "first" {
+		start = kafka.FirstOffset
+	}
+
+	return kafkaconsumer.Config{
+		Brokers: k.Brokers,
+		Topic:   k.Topic,
+		GroupID: k.GroupID,
+
+		DeadLetterTopic: k.DLQTopic,
+
+		MaxRetries:      k.MaxRetries,
+		RetryBackoff:    k.RetryBackoff,
+		RetryMaxBackoff: k.RetryMaxBackoff,
+		GiveUp:          kafkaconsumer.GiveUpPolicy(k.GiveUp),
+
+		BatchSize:    k.BatchSize,
+		BatchTimeout: k.BatchTimeout,
+
+		Workers:    k.Workers,
+		DispatchBy: kafkaconsumer.DispatchMode(k.DispatchBy),
+
+		Freshness:       kafkaconsumer.FreshnessMode(k.Freshness),
+		FreshnessHeader: k.FreshnessHeader,
+		OrderWait:       k.OrderWait,
+
+		StallTimeout: k.StallTimeout,
+
+		StartOffset:       start,
+		MinBytes:          k.MinBytes,
+		MaxBytes:          k.MaxBytes,
+		MaxWait:           k.MaxWait,
+		QueueCapacity:     k.QueueCapacity,
+		SessionTimeout:    k.SessionTimeout,
+		HeartbeatInterval: k.HeartbeatInterval,
+		RebalanceTimeout:  k.RebalanceTimeout,
+	}
 }
@@ -0,0 +1,5 @@
+-- Миграция вниз: удаляем таблицу отклонённых сообщений.
+
+DROP INDEX IF EXISTS idx_rejected_messages_rejected_at;
+
+DROP TABLE IF EXISTS rejected_messages;
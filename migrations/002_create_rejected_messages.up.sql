@@ -0,0 +1,17 @@
+-- Миграция вверх: таблица для сообщений, которые консьюмер отклонил.
+
+CREATE TABLE IF NOT EXISTS rejected_messages (
+    id              BIGSERIAL PRIMARY KEY,
+    topic           TEXT NOT NULL,
+    kafka_partition INTEGER NOT NULL,
+    kafka_offset    BIGINT NOT NULL,
+    message_key     BYTEA,
+    payload         BYTEA NOT NULL,
+    reason          TEXT NOT NULL,
+    error           TEXT NOT NULL,
+    message_time    TIMESTAMPTZ,
+    rejected_at     TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (topic, kafka_partition, kafka_offset)
+);
+
+CREATE INDEX IF NOT EXISTS idx_rejected_messages_rejected_at ON rejected_messages(rejected_at);
@@ -0,0 +1,14 @@
+-- Миграция вверх: индексы под поиск заказов (GET /orders).
+
+-- keyset-пагинация идёт по (date_created, order_uid)
+CREATE INDEX IF NOT EXISTS idx_orders_date_created_uid ON orders(date_created, order_uid);
+
+CREATE INDEX IF NOT EXISTS idx_orders_customer_id ON orders(customer_id);
+CREATE INDEX IF NOT EXISTS idx_orders_track_number ON orders(track_number);
+CREATE INDEX IF NOT EXISTS idx_orders_delivery_service ON orders(delivery_service);
+
+CREATE INDEX IF NOT EXISTS idx_payments_provider ON payments(provider);
+CREATE INDEX IF NOT EXISTS idx_payments_currency ON payments(currency);
+
+CREATE INDEX IF NOT EXISTS idx_items_brand ON items(brand);
+CREATE INDEX IF NOT EXISTS idx_items_nm_id ON items(nm_id);
@@ -0,0 +1,3 @@
+-- Миграция вниз: удаляем индекс по транзакции.
+
+DROP INDEX IF EXISTS idx_payments_transaction;
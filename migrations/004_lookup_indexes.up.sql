@@ -0,0 +1,4 @@
+-- Миграция вверх: индексы для поиска заказа по трек-номеру и транзакции.
+-- Индекс по orders(track_number) уже создан в 003.
+
+CREATE INDEX IF NOT EXISTS idx_payments_transaction ON payments(transaction);
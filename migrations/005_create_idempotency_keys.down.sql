@@ -0,0 +1,3 @@
+-- Миграция вниз: удаляем таблицу ключей идемпотентности.
+
+DROP TABLE IF EXISTS idempotency_keys;
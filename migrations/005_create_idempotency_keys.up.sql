@@ -0,0 +1,11 @@
+-- Миграция вверх: сохранённые ответы на запросы с заголовком Idempotency-Key.
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    key           TEXT PRIMARY KEY,
+    request_hash  TEXT NOT NULL,
+    status_code   INTEGER NOT NULL,
+    response      BYTEA NOT NULL,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_created_at ON idempotency_keys(created_at);
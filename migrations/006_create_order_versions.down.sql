@@ -0,0 +1,3 @@
+-- Миграция вниз: удаляем историю версий заказов.
+
+DROP TABLE IF EXISTS order_versions;
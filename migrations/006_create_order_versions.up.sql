@@ -0,0 +1,13 @@
+-- Миграция вверх: история версий заказов. Каждая запись заказа добавляет
+-- снимок целиком (JSON как в API) и источник записи.
+-- Внешнего ключа на orders нет намеренно: история должна пережить удаление заказа.
+
+CREATE TABLE IF NOT EXISTS order_versions (
+    order_uid   TEXT NOT NULL,
+    version     INTEGER NOT NULL,
+    data        JSONB NOT NULL,
+    source      TEXT NOT NULL,
+    source_ref  TEXT NOT NULL DEFAULT '',
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (order_uid, version)
+);
@@ -0,0 +1,6 @@
+-- Миграция вниз: убираем колонки свежести заказа.
+
+ALTER TABLE orders
+    DROP COLUMN IF EXISTS source_ts,
+    DROP COLUMN IF EXISTS updated_at,
+    DROP COLUMN IF EXISTS version;
@@ -0,0 +1,9 @@
+-- Миграция вверх: защита от устаревших записей.
+-- version растёт на каждой применённой записи, updated_at — время последней записи,
+-- source_ts — свежесть самой новой применённой копии заказа (payment_dt, заголовок
+-- или время сообщения Kafka). Копия старее source_ts не применяется.
+
+ALTER TABLE orders
+    ADD COLUMN IF NOT EXISTS version    BIGINT      NOT NULL DEFAULT 1,
+    ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    ADD COLUMN IF NOT EXISTS source_ts  TIMESTAMPTZ;
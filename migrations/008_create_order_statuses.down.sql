@@ -0,0 +1,4 @@
+-- Миграция вниз: удаляем статусы и историю переходов.
+
+DROP TABLE IF EXISTS order_status_history;
+DROP TABLE IF EXISTS order_statuses;
@@ -0,0 +1,26 @@
+-- Миграция вверх: статусы заказов и позиций и история переходов.
+-- rid = '' — статус самого заказа, иначе — позиции с этим rid.
+-- Строки нет, пока не пришло первое событие: заказ считается в статусе created.
+
+CREATE TABLE IF NOT EXISTS order_statuses (
+    order_uid  TEXT NOT NULL REFERENCES orders(order_uid) ON DELETE CASCADE,
+    rid        TEXT NOT NULL DEFAULT '',
+    status     TEXT NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (order_uid, rid)
+);
+
+CREATE TABLE IF NOT EXISTS order_status_history (
+    id          BIGSERIAL PRIMARY KEY,
+    order_uid   TEXT NOT NULL,
+    rid         TEXT NOT NULL DEFAULT '',
+    from_status TEXT NOT NULL,
+    to_status   TEXT NOT NULL,
+    reason      TEXT NOT NULL DEFAULT '',
+    source      TEXT NOT NULL,
+    source_ref  TEXT NOT NULL DEFAULT '',
+    changed_at  TIMESTAMPTZ NOT NULL,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_order_status_history_order ON order_status_history(order_uid, changed_at, id);
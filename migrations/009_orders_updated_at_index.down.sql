@@ -0,0 +1,3 @@
+-- Миграция вниз: удаляем индекс по времени изменения заказа.
+
+DROP INDEX IF EXISTS idx_orders_updated_at;
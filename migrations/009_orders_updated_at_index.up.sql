@@ -0,0 +1,3 @@
+-- Миграция вверх: индекс для догрузки заказов, изменённых после снимка кэша.
+
+CREATE INDEX IF NOT EXISTS idx_orders_updated_at ON orders(updated_at);
@@ -0,0 +1,5 @@
+-- Миграция вниз: удаляем незавершённые резервы и возвращаем NOT NULL.
+
+DELETE FROM idempotency_keys WHERE status_code IS NULL OR response IS NULL;
+ALTER TABLE idempotency_keys ALTER COLUMN status_code SET NOT NULL;
+ALTER TABLE idempotency_keys ALTER COLUMN response SET NOT NULL;
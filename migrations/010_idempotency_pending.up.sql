@@ -0,0 +1,5 @@
+-- Миграция вверх: ключ идемпотентности занимается до выполнения запроса.
+-- Пока ответа нет (status_code IS NULL), запрос с этим ключом ещё выполняется.
+
+ALTER TABLE idempotency_keys ALTER COLUMN status_code DROP NOT NULL;
+ALTER TABLE idempotency_keys ALTER COLUMN response DROP NOT NULL;
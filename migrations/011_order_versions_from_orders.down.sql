@@ -0,0 +1,4 @@
+-- Миграция вниз: возвращаем INTEGER для номера версии в истории.
+-- Выровненные orders.version не откатываются: прежних значений не сохранилось.
+
+ALTER TABLE order_versions ALTER COLUMN version TYPE INTEGER;
@@ -0,0 +1,13 @@
+-- Миграция вверх: номер версии в истории берётся из orders.version.
+-- Раньше история нумеровалась отдельным счётчиком и могла обогнать orders.version
+-- (пакетная запись нескольких вариантов заказа) или отстать от него (заказы,
+-- сохранённые до миграции 006). orders.version выравнивается по последней версии
+-- в истории, а у заказов без истории становится 0, чтобы следующая запись
+-- получила следующий номер без пропусков.
+
+ALTER TABLE order_versions ALTER COLUMN version TYPE BIGINT;
+
+UPDATE orders o SET version = COALESCE(
+    (SELECT MAX(v.version) FROM order_versions v WHERE v.order_uid = o.order_uid),
+    0
+);
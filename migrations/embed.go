@@ -0,0 +1,10 @@
+// Package migrations встраивает SQL-миграции в бинарники сервиса и cmd/migrate,
+// чтобы они не зависели от рабочей директории.
+package migrations
+
+import "embed"
+
+// FS — файлы NNN_name.up.sql и NNN_name.down.sql, см. internal/migrate.
+//
+//go:embed *.sql
+var FS embed.FS
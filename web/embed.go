@@ -0,0 +1,9 @@
+// Package web встраивает статику UI в бинарник сервиса.
+package web
+
+import "embed"
+
+// FS — файлы UI, index.html в корне.
+//
+//go:embed *.html
+var FS embed.FS